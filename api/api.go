@@ -2,10 +2,15 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/OleksiiKhanin/companysvc/domain"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -13,14 +18,44 @@ type API struct {
 	iCompany  domain.ICompany
 	l         *log.Logger
 	logPrefix string
+
+	verifier    domain.TokenVerifier // can be nil, authentication is disabled then
+	readScopes  []string
+	writeScopes []string
+
+	defaultPageSize int
+	maxPageSize     int
+	requireIfMatch  bool
+	maxImportSize   int64
+	includeDeleted  bool // include_deleted=true is rejected without it
+
+	webhooks      domain.Webhooks // can be nil, webhooks are disabled then
+	webhookScopes []string
+
+	trustedProxies []netip.Prefix // forwarding headers are read from these peers only
 }
 
-func middlewareSetUserIP(next http.Handler) http.Handler {
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	MaxImportSize   = 32 << 20
+)
+
+// Option - optional API feature
+type Option func(*API)
+
+// middlewareSetRequestID keeps the X-Request-ID of the caller or generates a new one
+func middlewareSetRequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		addr := strings.Split(r.RemoteAddr, ":")
-		ctx := context.WithValue(r.Context(), domain.CtxUserIPKey, addr[0])
-		r = r.WithContext(ctx)
-		next.ServeHTTP(w, r)
+		requestID := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+		if requestID == "" || len(requestID) > 128 {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), domain.CtxRequestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -38,14 +73,74 @@ func getRecoveryMiddleware(l *log.Logger) mux.MiddlewareFunc {
 	}
 }
 
+// WithPageSize - page size of GET /v1/companies when the limit is not set and its hard maximum
+func WithPageSize(defaultSize, maxSize int) Option {
+	return func(a *API) {
+		if maxSize > 0 {
+			a.maxPageSize = maxSize
+		}
+		if defaultSize > 0 {
+			a.defaultPageSize = defaultSize
+		}
+		if a.defaultPageSize > a.maxPageSize {
+			a.defaultPageSize = a.maxPageSize
+		}
+	}
+}
+
+// WithIncludeDeleted - accept include_deleted=true, the company service must check who can list deleted companies
+func WithIncludeDeleted() Option {
+	return func(a *API) {
+		a.includeDeleted = true
+	}
+}
+
+// parseIncludeDeleted rejects include_deleted=true with 403 unless it is accepted by WithIncludeDeleted
+func (a *API) parseIncludeDeleted(query url.Values) (bool, error) {
+	include, _ := strconv.ParseBool(query.Get("include_deleted"))
+	if include && !a.includeDeleted {
+		return false, httpError{problem: problemForbidden, detail: "Deleted companies can't be listed without authorization rules"}
+	}
+	return include, nil
+}
+
 // InitAPI - init all CRUD operation
-func InitAPI(r *mux.Router, company domain.ICompany, l *log.Logger) {
-	api := API{iCompany: company, l: l, logPrefix: "API"}
+func InitAPI(r *mux.Router, company domain.ICompany, l *log.Logger, opts ...Option) {
+	api := API{
+		iCompany:        company,
+		l:               l,
+		logPrefix:       "API",
+		defaultPageSize: DefaultPageSize,
+		maxPageSize:     MaxPageSize,
+		maxImportSize:   MaxImportSize,
+	}
+	for _, opt := range opts {
+		opt(&api)
+	}
 
-	r.Use(getRecoveryMiddleware(l), middlewareSetUserIP)
+	r.Use(getRecoveryMiddleware(l), middlewareSetRequestID, api.middlewareSetUserIP)
+	if api.verifier != nil {
+		r.Use(api.getAuthMiddleware())
+	}
 	r.Methods(http.MethodGet).Path("/v1/companies").HandlerFunc(api.getCompaniesHandler)
+	r.Methods(http.MethodGet).Path("/v1/companies/search").HandlerFunc(api.searchCompaniesHandler)
+	r.Methods(http.MethodPost).Path("/v1/companies:batch").HandlerFunc(api.batchCompaniesHandler)
+	r.Methods(http.MethodGet).Path("/v1/companies/export").HandlerFunc(api.exportCompaniesHandler)
+	r.Methods(http.MethodPost).Path("/v1/companies/import").HandlerFunc(api.importCompaniesHandler)
 	r.Methods(http.MethodGet).Path("/v1/company/{name}/{code}").HandlerFunc(api.getCompanyHandler)
+	r.Methods(http.MethodGet).Path("/v1/company/{name}/{code}/history").HandlerFunc(api.getCompanyHistoryHandler)
 	r.Methods(http.MethodDelete).Path("/v1/company/{name}/{code}").HandlerFunc(api.deleteCompanyHandler)
 	r.Methods(http.MethodPut).Path("/v1/company/{name}/{code}").HandlerFunc(api.updateCompanyHandler)
+	r.Methods(http.MethodPatch).Path("/v1/company/{name}/{code}").HandlerFunc(api.patchCompanyHandler)
 	r.Methods(http.MethodPost).Path("/v1/company").HandlerFunc(api.createCompanyHandler)
+	r.Methods(http.MethodPost).Path("/v1/company/{name}/{code}/restore").HandlerFunc(api.restoreCompanyHandler)
+
+	r.Methods(http.MethodGet).Path("/v2/companies/{id}").HandlerFunc(api.getCompanyByIDHandler)
+	r.Methods(http.MethodPut).Path("/v2/companies/{id}").HandlerFunc(api.updateCompanyByIDHandler)
+	r.Methods(http.MethodPatch).Path("/v2/companies/{id}").HandlerFunc(api.patchCompanyByIDHandler)
+	r.Methods(http.MethodDelete).Path("/v2/companies/{id}").HandlerFunc(api.deleteCompanyByIDHandler)
+
+	if api.webhooks != nil {
+		api.initWebhooks(r)
+	}
 }
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+)
+
+// WithAuth - require a valid bearer token for every request. Safe methods (GET, HEAD, OPTIONS)
+// need readScopes and all other methods need writeScopes
+func WithAuth(verifier domain.TokenVerifier, readScopes, writeScopes []string) Option {
+	return func(a *API) {
+		a.verifier = verifier
+		a.readScopes = readScopes
+		a.writeScopes = writeScopes
+	}
+}
+
+func (a *API) getAuthMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="companysvc"`)
+				a.handleError(w, r, httpError{problem: problemUnauthorized, detail: "Bearer token is required"})
+				return
+			}
+			claims, err := a.verifier.Verify(token)
+			if err != nil {
+				a.l.Infof("%s:Verify token: %s", a.logPrefix, err.Error())
+				w.Header().Set("WWW-Authenticate", `Bearer realm="companysvc", error="invalid_token"`)
+				a.handleError(w, r, httpError{problem: problemUnauthorized, detail: "Invalid bearer token"})
+				return
+			}
+			scopes := a.writeScopes
+			if isSafeMethod(r.Method) {
+				scopes = a.readScopes
+			}
+			if !claims.HasScopes(scopes...) {
+				a.l.Infof("%s:Subject %s has no scopes %v", a.logPrefix, claims.Subject(), scopes)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="companysvc", error="insufficient_scope"`)
+				a.handleError(w, r, httpError{problem: problemForbidden, detail: "Insufficient scope"})
+				return
+			}
+			ctx := context.WithValue(r.Context(), domain.CtxUserSubjectKey, claims.Subject())
+			ctx = context.WithValue(ctx, domain.CtxUserClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+	return token, token != ""
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
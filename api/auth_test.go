@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type VerifierMock func(token string) (domain.Claims, error)
+
+func (v VerifierMock) Verify(token string) (domain.Claims, error) {
+	return v(token)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	verifier := VerifierMock(func(token string) (domain.Claims, error) {
+		switch token {
+		case "reader":
+			return domain.Claims{"sub": "reader", "scope": "company:read"}, nil
+		case "writer":
+			return domain.Claims{"sub": "writer", "scope": "company:read company:write"}, nil
+		}
+		return nil, errors.New("invalid token")
+	})
+	company := &MockCompany{
+		t: t,
+		get: func(ctx context.Context, name, code string) (domain.Company, error) {
+			if ctx.Value(domain.CtxUserSubjectKey) == nil {
+				t.Error("subject should be set in context")
+			}
+			return domain.Company{Name: name, Code: code}, nil
+		},
+		delete: func(_ context.Context, _ string, _ string) error {
+			return nil
+		},
+	}
+	cases := []struct {
+		method string
+		token  string
+		code   int
+	}{
+		{method: http.MethodGet, code: http.StatusUnauthorized},
+		{method: http.MethodGet, token: "unknown", code: http.StatusUnauthorized},
+		{method: http.MethodGet, token: "reader", code: http.StatusOK},
+		{method: http.MethodDelete, token: "reader", code: http.StatusForbidden},
+		{method: http.MethodDelete, token: "writer", code: http.StatusAccepted},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, "/v1/company/test/testCode", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		r := mux.NewRouter()
+		InitAPI(r, company, log.StandardLogger(),
+			WithAuth(verifier, []string{"company:read"}, []string{"company:write"}))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != c.code {
+			t.Errorf("%s with token %q: want %d but got %d", c.method, c.token, c.code, rr.Code)
+		}
+	}
+}
+
+func TestForbiddenErrorStatus(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/v1/company/Name/Code", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		delete: func(_ context.Context, _ string, _ string) error {
+			return &domain.AccessDeniedError{Operation: "delete", Subject: "user", Reason: "test"}
+		},
+	})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("want %d for denied delete but got %d", http.StatusForbidden, rr.Code)
+	}
+}
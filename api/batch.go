@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+type batchRequest struct {
+	// ContinueOnError applies every operation on its own, otherwise the batch is applied all or nothing
+	ContinueOnError bool             `json:"continueOnError"`
+	Operations      []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Action  domain.BatchAction `json:"action"`
+	Name    string             `json:"name"` // of the updated or deleted company
+	Code    string             `json:"code"`
+	Version int64              `json:"version"` // optional, like If-Match of a single change
+	Company *domain.Company    `json:"company"`
+}
+
+type batchItem struct {
+	Index   int             `json:"index"`
+	Status  int             `json:"status"`
+	Company *domain.Company `json:"company,omitempty"`
+	Error   *problem        `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []batchItem `json:"items"`
+}
+
+// batchStatus - the status of an applied operation matches the status of the single change
+var batchStatus = map[domain.BatchAction]int{
+	domain.BatchCreate: http.StatusCreated,
+	domain.BatchUpdate: http.StatusAccepted,
+	domain.BatchDelete: http.StatusAccepted,
+}
+
+func parseBatch(r *http.Request) ([]domain.BatchOperation, *domain.BatchOptions, error) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, nil, badRequest(err.Error())
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > domain.MaxBatchSize {
+		return nil, nil, badRequest(fmt.Sprintf("operations must contain from 1 to %d items", domain.MaxBatchSize))
+	}
+	var v domain.ValidationError
+	ops := make([]domain.BatchOperation, 0, len(req.Operations))
+	for i, o := range req.Operations {
+		op := domain.BatchOperation{Action: o.Action, Name: o.Name, Code: o.Code, Company: o.Company, Version: o.Version}
+		var invalid *domain.ValidationError
+		if err := op.Validate(); err != nil && errors.As(err, &invalid) {
+			for _, f := range invalid.Fields {
+				v.Add(fmt.Sprintf("operations[%d].%s", i, f.Field), f.Message)
+			}
+		}
+		ops = append(ops, op)
+	}
+	if err := v.OrNil(); err != nil {
+		return nil, nil, invalidRequest("Batch is invalid", err)
+	}
+	return ops, &domain.BatchOptions{ContinueOnError: req.ContinueOnError}, nil
+}
+
+// batchCompaniesHandler applies many creates, updates and deletes and reports the status of each one
+func (a *API) batchCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	ops, options, err := parseBatch(r)
+	if err != nil {
+		a.l.Infof("%s:Parse batch: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	results, err := a.iCompany.Batch(r.Context(), ops, options)
+	if err != nil {
+		a.l.Warnf("%s:Batch companies: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	res := batchResponse{Items: make([]batchItem, 0, len(results))}
+	for i := range results {
+		item := batchItem{Index: i, Status: batchStatus[ops[i].Action]}
+		if results[i].Err != nil {
+			p := a.toProblem(r, results[i].Err)
+			item.Status, item.Error = p.Status, &p
+			res.Failed++
+		} else {
+			if ops[i].Action != domain.BatchDelete {
+				item.Company = &results[i].Company
+			}
+			res.Succeeded++
+		}
+		res.Items = append(res.Items, item)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
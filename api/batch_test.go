@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+func TestBatchCompaniesHandler(t *testing.T) {
+	body := `{"continueOnError": true, "operations": [
+		{"action": "create", "company": {"name": "a", "code": "c", "country": "Ukraine"}},
+		{"action": "update", "name": "b", "code": "c", "version": 2, "company": {"name": "b", "code": "c", "country": "Cyprus"}},
+		{"action": "delete", "name": "d", "code": "c"}
+	]}`
+	req := httptest.NewRequest("POST", "/v1/companies:batch", strings.NewReader(body))
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		batch: func(_ context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+			if len(ops) != 3 || !options.ContinueOnError || ops[1].Version != 2 || ops[2].Name != "d" {
+				t.Errorf("unexpected batch %+v %+v", ops, options)
+			}
+			return []domain.BatchResult{
+				{Company: domain.Company{ID: "1", Name: "a", Code: "c", Version: 1}},
+				{Err: domain.ErrPreconditionFailed},
+				{Company: domain.Company{ID: "3", Name: "d", Code: "c"}},
+			}, nil
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	var res batchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Succeeded != 2 || res.Failed != 1 || len(res.Items) != 3 {
+		t.Fatalf("want 2 succeeded and 1 failed operations but got %+v", res)
+	}
+	if res.Items[0].Status != http.StatusCreated || res.Items[0].Company == nil || res.Items[0].Company.ID != "1" {
+		t.Errorf("want the created company but got %+v", res.Items[0])
+	}
+	if res.Items[1].Status != http.StatusPreconditionFailed || res.Items[1].Error == nil ||
+		res.Items[1].Error.Type != problemPreconditionFailed.uri() {
+		t.Errorf("want precondition failed problem but got %+v", res.Items[1])
+	}
+	if res.Items[2].Status != http.StatusAccepted || res.Items[2].Company != nil {
+		t.Errorf("want the delete accepted but got %+v", res.Items[2])
+	}
+}
+
+func TestBatchCompaniesHandlerInvalid(t *testing.T) {
+	cases := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"operations": []}`, nil},
+		{`{"operations": [{"action": "move"}]}`, []string{"operations[0].action"}},
+		{`{"operations": [{"action": "create"}, {"action": "delete", "name": "a"}]}`, []string{"operations[0].company", "operations[1].code"}},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/v1/companies:batch", strings.NewReader(c.body))
+		rr := execRequest(req, &MockCompany{t: t})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400 but got %d", c.body, rr.Code)
+			continue
+		}
+		var p problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Errors) != len(c.fields) {
+			t.Errorf("%s: want errors of %v but got %v", c.body, c.fields, p.Errors)
+			continue
+		}
+		for i := range c.fields {
+			if p.Errors[i].Field != c.fields[i] {
+				t.Errorf("%s: want error of %s but got %v", c.body, c.fields[i], p.Errors[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// WithTrustedProxies - the client address is taken from Forwarded, X-Forwarded-For or X-Real-IP when the request
+// comes through these networks, e.g. the ingress. Without trusted proxies the headers are ignored.
+func WithTrustedProxies(prefixes []netip.Prefix) Option {
+	return func(a *API) {
+		a.trustedProxies = prefixes
+	}
+}
+
+// middlewareSetUserIP puts the netip.Addr of the client in the context, requests whose address is unknown
+// get no address and are denied by the geo check
+func (a *API) middlewareSetUserIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip, ok := a.clientIP(r); ok {
+			r = r.WithContext(context.WithValue(r.Context(), domain.CtxUserIPKey, ip))
+		} else {
+			a.l.Debugf("%s: unknown client address %q", a.logPrefix, r.RemoteAddr)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP walks the hops from the nearest one and returns the first address which is not a trusted proxy.
+// If every hop is trusted the farthest one is the client. The client is unknown when the walk reaches a hop
+// which can't be parsed (e.g. "unknown" or an obfuscated identifier), a trusted proxy is never returned instead.
+func (a *API) clientIP(r *http.Request) (netip.Addr, bool) {
+	ip, ok := parseHop(r.RemoteAddr)
+	if !ok || !a.isTrustedProxy(ip) {
+		return ip, ok
+	}
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		hops = headerList(r.Header, "X-Forwarded-For")
+	}
+	if len(hops) == 0 {
+		hops = headerList(r.Header, "X-Real-IP")
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			return netip.Addr{}, false
+		}
+		ip = hop
+		if !a.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip, true
+}
+
+func (a *API) isTrustedProxy(ip netip.Addr) bool {
+	for _, p := range a.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerList returns comma separated values of all the header lines in order
+func headerList(h http.Header, name string) []string {
+	var list []string
+	for _, line := range h.Values(name) {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// forwardedFor returns the for= nodes of the RFC 7239 Forwarded header, a proxy element without for= is "unknown"
+func forwardedFor(h http.Header) []string {
+	elements := headerList(h, "Forwarded")
+	nodes := make([]string, 0, len(elements))
+	for _, element := range elements {
+		node := "unknown"
+		for _, pair := range strings.Split(element, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "for") {
+				node = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// parseHop parses an address of a hop with an optional port: 192.0.2.1, 192.0.2.1:80, 2001:db8::1 or [2001:db8::1]:80.
+// Obfuscated identifiers and "unknown" are not addresses.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	} else if strings.HasPrefix(hop, "[") && strings.HasSuffix(hop, "]") {
+		hop = hop[1 : len(hop)-1]
+	}
+	ip, err := netip.ParseAddr(hop)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.WithZone("").Unmap(), true
+}
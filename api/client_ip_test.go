@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseHop(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.1":             "192.0.2.1",
+		"192.0.2.1:8080":        "192.0.2.1",
+		"2001:db8::1":           "2001:db8::1",
+		"[2001:db8::1]":         "2001:db8::1",
+		"[2001:db8::1]:4711":    "2001:db8::1",
+		"[::ffff:192.0.2.1]:80": "192.0.2.1",
+		"[fe80::1%eth0]:80":     "fe80::1",
+	}
+	for hop, want := range cases {
+		if ip, ok := parseHop(hop); !ok || ip.String() != want {
+			t.Errorf("%q: want %s but got %s %t", hop, want, ip, ok)
+		}
+	}
+	for _, hop := range []string{"", "unknown", "_hidden", "192.0.2.1:80:80", "[192.0.2.1"} {
+		if ip, ok := parseHop(hop); ok {
+			t.Errorf("%q: want no address but got %s", hop, ip)
+		}
+	}
+}
+
+func TestMiddlewareSetUserIP(t *testing.T) {
+	api := &API{
+		l:         log.New(),
+		logPrefix: "API",
+		trustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("fd00::/8"),
+		},
+	}
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"direct IPv4", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"direct IPv6", "[2001:db8::7]:5000", nil, "2001:db8::7"},
+		{"untrusted peer", "203.0.113.7:5000", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted peer without headers", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"x-forwarded-for", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed x-forwarded-for", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"x-forwarded-for lines", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1"}}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"10.1.1.1, 10.0.0.2"}}, "10.1.1.1"},
+		{"x-real-ip", "10.0.0.1:5000", map[string][]string{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"ipv6 proxy", "[fd00::1]:5000", map[string][]string{"X-Forwarded-For": {"2001:db8::9"}}, "2001:db8::9"},
+		{
+			"forwarded", "10.0.0.1:5000",
+			map[string][]string{"Forwarded": {`for=192.0.2.43, for="[2001:db8:cafe::17]:4711";proto=https;by=10.0.0.1`}},
+			"2001:db8:cafe::17",
+		},
+		{
+			"forwarded before x-forwarded-for", "10.0.0.1:5000",
+			map[string][]string{"Forwarded": {"For=192.0.2.43"}, "X-Forwarded-For": {"198.51.100.1"}},
+			"192.0.2.43",
+		},
+	}
+	for _, c := range cases {
+		var got any
+		handler := api.middlewareSetUserIP(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			got = r.Context().Value(domain.CtxUserIPKey)
+		}))
+		req := httptest.NewRequest("GET", "/v1/companies", nil)
+		req.RemoteAddr = c.remote
+		for name, values := range c.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if ip, ok := got.(netip.Addr); !ok || ip != netip.MustParseAddr(c.want) {
+			t.Errorf("%s: want %s but got %v", c.name, c.want, got)
+		}
+	}
+
+	unknown := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+	}{
+		{"unparsable remote address", "@", nil},
+		{"unknown hop", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"198.51.100.1, unknown, 10.0.0.2"}}},
+		{"forwarded unknown", "10.0.0.1:5000", map[string][]string{"Forwarded": {"for=unknown;proto=https"}}},
+		{"forwarded obfuscated", "10.0.0.1:5000", map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}}},
+		{"forwarded without for", "10.0.0.1:5000", map[string][]string{"Forwarded": {"proto=https;by=10.0.0.1"}}},
+		{"garbage x-real-ip", "10.0.0.1:5000", map[string][]string{"X-Real-Ip": {"not an address"}}},
+	}
+	for _, c := range unknown {
+		var got any = "not called"
+		handler := api.middlewareSetUserIP(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			got = r.Context().Value(domain.CtxUserIPKey)
+		}))
+		req := httptest.NewRequest("GET", "/v1/companies", nil)
+		req.RemoteAddr = c.remote
+		for name, values := range c.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != nil {
+			t.Errorf("%s: want no address but got %v", c.name, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/OleksiiKhanin/companysvc/domain"
 	"github.com/gorilla/mux"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -35,13 +36,18 @@ func (a *API) getCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	p, err := a.parseParameters(r)
 	if err != nil {
 		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusBadRequest, message: err.Error()})
+		a.handleError(w, r, badRequest(err.Error()))
 		return
 	}
 	company, err := a.iCompany.Get(r.Context(), p.name, p.code)
 	if err != nil {
 		a.l.Warnf("%s:Get company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusInternalServerError, message: "Can not get company"})
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	if notModified(r, &company) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -49,25 +55,87 @@ func (a *API) getCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(company)
 }
 
-// getCompaniesHandler - return a list of companies by filter in query parameters like name, code, website etc
-func (a *API) getCompaniesHandler(w http.ResponseWriter, r *http.Request) {
-	var companyParams = []string{"name", "code", "country", "website", "phone"}
-	query := r.URL.Query()
-	filter := domain.FilterOptions{
-		Params: make(map[string]string),
+// getCompanyHistoryHandler - return revisions of a company newest first, deleted companies keep their history
+func (a *API) getCompanyHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := a.parseParameters(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	revisions, err := a.iCompany.History(r.Context(), p.name, p.code)
+	if err != nil {
+		a.l.Warnf("%s:Get company history: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Items []domain.Revision `json:"items"`
+	}{Items: revisions})
+}
+
+// parseLimit parses the limit query parameter, it is capped by the max page size
+func (a *API) parseLimit(query url.Values) (int, error) {
+	limit := a.defaultPageSize
+	if query.Has("limit") {
+		var err error
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit < 1 {
+			return 0, badRequest("limit must be a positive integer")
+		}
+	}
+	if limit > a.maxPageSize {
+		limit = a.maxPageSize
+	}
+	return limit, nil
+}
+
+// parsePage parses limit, sort, cursor, total and include_deleted query parameters
+func (a *API) parsePage(query url.Values, filter *domain.FilterOptions) error {
+	limit, err := a.parseLimit(query)
+	if err != nil {
+		return err
 	}
-	if limit, err := strconv.Atoi(query.Get("limit")); err == nil {
-		filter.Limit = &limit
+	filter.Limit = &limit
+
+	sort, err := domain.ParseSort(query.Get("sort"))
+	if err != nil {
+		return badRequest(err.Error())
 	}
-	for _, param := range companyParams {
-		if query.Has(param) {
-			filter.Params[param] = strings.TrimSpace(query.Get(param))
+	filter.Sort = sort
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.After, err = domain.DecodeCursor(cursor, sort); err != nil {
+			return badRequest(err.Error())
 		}
 	}
+	filter.WithTotal, _ = strconv.ParseBool(query.Get("total"))
+	filter.IncludeDeleted, err = a.parseIncludeDeleted(query)
+	return err
+}
+
+// getCompaniesHandler - return a page of companies by filter in query parameters like name, code, website etc.,
+// see parseFilter for the filter syntax.
+// Pages are sorted by the sort parameter (e.g. sort=name,-country) and the next page is requested with nextCursor.
+func (a *API) getCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter domain.FilterOptions
+	if err := a.parsePage(query, &filter); err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	where, err := parseFilter(query)
+	if err != nil {
+		a.l.Infof("%s:Parse filter: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, filterError(err))
+		return
+	}
+	filter.Where = where
 	companies, err := a.iCompany.GetMany(r.Context(), &filter)
 	if err != nil {
 		a.l.Warnf("%s:Get many companies: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusInternalServerError, message: "Can not get companies"})
+		a.handleError(w, r, err)
 
 		return
 	}
@@ -76,18 +144,58 @@ func (a *API) getCompaniesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(companies)
 }
 
+// searchCompaniesHandler - return companies ranked by relevance to the q parameter with highlighted matches,
+// filter parameters of getCompaniesHandler narrow the search
+func (a *API) searchCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := domain.SearchOptions{Query: strings.TrimSpace(query.Get("q"))}
+	if len(domain.SearchTerms(options.Query)) == 0 {
+		a.l.Infof("%s:Parse query parameters: empty search query", a.logPrefix)
+		a.handleError(w, r, badRequest("q parameter must contain letters or digits"))
+		return
+	}
+	var err error
+	if options.Limit, err = a.parseLimit(query); err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	if options.Where, err = parseFilter(query); err != nil {
+		a.l.Infof("%s:Parse filter: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, filterError(err))
+		return
+	}
+	results, err := a.iCompany.Search(r.Context(), &options)
+	if err != nil {
+		a.l.Warnf("%s:Search companies: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Items []domain.SearchResult `json:"items"`
+	}{Items: results})
+}
+
 func (a *API) createCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	var newCompany domain.Company
 	if err := json.NewDecoder(r.Body).Decode(&newCompany); err != nil {
 		a.l.Infof("%s:Parse company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusBadRequest, message: err.Error()})
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if newCompany.ID != "" {
+		a.l.Infof("%s:Create company with id %s", a.logPrefix, newCompany.ID)
+		a.handleError(w, r, &domain.ValidationError{Fields: []domain.FieldError{{Field: "id", Message: "is assigned by the server"}}})
 		return
 	}
 	if err := a.iCompany.Create(r.Context(), &newCompany); err != nil {
 		a.l.Warnf("%s:Create company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusInternalServerError, message: "Can not create company"})
+		a.handleError(w, r, err)
 		return
 	}
+	w.Header().Set("ETag", etag(&newCompany))
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newCompany)
@@ -97,36 +205,98 @@ func (a *API) updateCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	p, err := a.parseParameters(r)
 	if err != nil {
 		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusBadRequest, message: err.Error()})
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
 		return
 	}
 	var company domain.Company
 	if err := json.NewDecoder(r.Body).Decode(&company); err != nil {
 		a.l.Infof("%s:Parse company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusBadRequest, message: err.Error()})
+		a.handleError(w, r, badRequest(err.Error()))
 		return
 	}
-	if err := a.iCompany.Update(r.Context(), p.name, p.code, &company); err != nil {
+	if _, err := a.iCompany.Update(r.Context(), p.name, p.code, &company); err != nil {
 		a.l.Warnf("%s:Update company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusInternalServerError, message: "Can not update company"})
+		a.handleError(w, r, err)
 		return
 	}
+	w.Header().Set("ETag", etag(&company))
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(company)
 }
 
+// patchCompanyHandler changes only the fields of a merge patch or a JSON Patch
+func (a *API) patchCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := a.parseParameters(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	patch, err := parsePatch(r)
+	if err != nil {
+		a.l.Infof("%s:Parse patch: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	_, company, err := a.iCompany.Patch(r.Context(), p.name, p.code, patch)
+	if err != nil {
+		a.l.Warnf("%s:Patch company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
+
 func (a *API) deleteCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	p, err := a.parseParameters(r)
 	if err != nil {
 		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusBadRequest, message: err.Error()})
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
 		return
 	}
 	if err = a.iCompany.Delete(r.Context(), p.name, p.code); err != nil {
-		a.l.Warnf("%s:Update company: %s", a.logPrefix, err.Error())
-		a.handleError(w, httpError{code: http.StatusInternalServerError, message: "Can not delete company"})
+		a.l.Warnf("%s:Delete company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// restoreCompanyHandler returns the last deleted company with the name and code back
+func (a *API) restoreCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := a.parseParameters(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	company, err := a.iCompany.Restore(r.Context(), p.name, p.code)
+	if err != nil {
+		a.l.Warnf("%s:Restore company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
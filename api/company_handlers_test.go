@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -17,9 +18,20 @@ type MockCompany struct {
 	t       *testing.T
 	create  func(_ context.Context, _ *domain.Company) error
 	update  func(_ context.Context, _ string, _ string, _ *domain.Company) error
+	patch   func(_ context.Context, _ string, _ string, _ *domain.CompanyPatch) (domain.Company, error)
 	delete  func(_ context.Context, _ string, _ string) error
 	get     func(_ context.Context, _ string, _ string) (domain.Company, error)
-	getMany func(_ context.Context, _ *domain.FilterOptions) ([]domain.Company, error)
+	getByID func(_ context.Context, _ string) (domain.Company, error)
+	getMany func(_ context.Context, _ *domain.FilterOptions) (domain.CompanyPage, error)
+	export  func(_ context.Context, _ *domain.FilterOptions, _ func(*domain.Company) error) error
+	search  func(_ context.Context, _ *domain.SearchOptions) ([]domain.SearchResult, error)
+	history func(_ context.Context, _ string, _ string) ([]domain.Revision, error)
+	restore func(_ context.Context, _ string, _ string) (domain.Company, error)
+	batch   func(_ context.Context, _ []domain.BatchOperation, _ *domain.BatchOptions) ([]domain.BatchResult, error)
+
+	updateByID func(_ context.Context, _ string, _ *domain.Company) error
+	patchByID  func(_ context.Context, _ string, _ *domain.CompanyPatch) (domain.Company, error)
+	deleteByID func(_ context.Context, _ string) error
 }
 
 func (m *MockCompany) Get(ctx context.Context, name, code string) (domain.Company, error) {
@@ -30,14 +42,54 @@ func (m *MockCompany) Get(ctx context.Context, name, code string) (domain.Compan
 	return domain.Company{}, errors.New("not implemented method")
 }
 
-func (m *MockCompany) GetMany(ctx context.Context, filter *domain.FilterOptions) ([]domain.Company, error) {
+func (m *MockCompany) GetByID(ctx context.Context, id string) (domain.Company, error) {
+	if m.getByID != nil {
+		return m.getByID(ctx, id)
+	}
+	m.t.Error("should not be called")
+	return domain.Company{}, errors.New("not implemented method")
+}
+
+func (m *MockCompany) GetMany(ctx context.Context, filter *domain.FilterOptions) (domain.CompanyPage, error) {
 	if m.getMany != nil {
 		return m.getMany(ctx, filter)
 	}
 	m.t.Error("should not be called")
+	return domain.CompanyPage{}, errors.New("not implemented method")
+}
+
+func (m *MockCompany) Export(ctx context.Context, filter *domain.FilterOptions, fn func(*domain.Company) error) error {
+	if m.export != nil {
+		return m.export(ctx, filter, fn)
+	}
+	m.t.Error("should not be called")
+	return errors.New("not implemented method")
+}
+
+func (m *MockCompany) Search(ctx context.Context, options *domain.SearchOptions) ([]domain.SearchResult, error) {
+	if m.search != nil {
+		return m.search(ctx, options)
+	}
+	m.t.Error("should not be called")
 	return nil, errors.New("not implemented method")
 }
 
+func (m *MockCompany) History(ctx context.Context, name, code string) ([]domain.Revision, error) {
+	if m.history != nil {
+		return m.history(ctx, name, code)
+	}
+	m.t.Error("should not be called")
+	return nil, errors.New("not implemented method")
+}
+
+func (m *MockCompany) Restore(ctx context.Context, name, code string) (domain.Company, error) {
+	if m.restore != nil {
+		return m.restore(ctx, name, code)
+	}
+	m.t.Error("should not be called")
+	return domain.Company{}, errors.New("not implemented method")
+}
+
 func (m *MockCompany) Create(ctx context.Context, company *domain.Company) error {
 	if m.create != nil {
 		return m.create(ctx, company)
@@ -46,12 +98,21 @@ func (m *MockCompany) Create(ctx context.Context, company *domain.Company) error
 	return errors.New("not implemented method")
 }
 
-func (m *MockCompany) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) error {
+func (m *MockCompany) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) (domain.Company, error) {
 	if m.update != nil {
-		return m.update(ctx, oldName, oldCode, company)
+		return domain.Company{}, m.update(ctx, oldName, oldCode, company)
 	}
 	m.t.Error("should not be called")
-	return errors.New("not implemented method")
+	return domain.Company{}, errors.New("not implemented method")
+}
+
+func (m *MockCompany) Patch(ctx context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	if m.patch != nil {
+		company, err := m.patch(ctx, name, code, patch)
+		return domain.Company{}, company, err
+	}
+	m.t.Error("should not be called")
+	return domain.Company{}, domain.Company{}, errors.New("not implemented method")
 }
 
 func (m *MockCompany) Delete(ctx context.Context, name, code string) error {
@@ -62,9 +123,42 @@ func (m *MockCompany) Delete(ctx context.Context, name, code string) error {
 	return errors.New("not implemented method")
 }
 
-func execRequest(req *http.Request, company domain.ICompany) *httptest.ResponseRecorder {
+func (m *MockCompany) UpdateByID(ctx context.Context, id string, company *domain.Company) (domain.Company, error) {
+	if m.updateByID != nil {
+		return domain.Company{}, m.updateByID(ctx, id, company)
+	}
+	m.t.Error("should not be called")
+	return domain.Company{}, errors.New("not implemented method")
+}
+
+func (m *MockCompany) PatchByID(ctx context.Context, id string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	if m.patchByID != nil {
+		company, err := m.patchByID(ctx, id, patch)
+		return domain.Company{}, company, err
+	}
+	m.t.Error("should not be called")
+	return domain.Company{}, domain.Company{}, errors.New("not implemented method")
+}
+
+func (m *MockCompany) DeleteByID(ctx context.Context, id string) error {
+	if m.deleteByID != nil {
+		return m.deleteByID(ctx, id)
+	}
+	m.t.Error("should not be called")
+	return errors.New("not implemented method")
+}
+
+func (m *MockCompany) Batch(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+	if m.batch != nil {
+		return m.batch(ctx, ops, options)
+	}
+	m.t.Error("should not be called")
+	return nil, errors.New("not implemented method")
+}
+
+func execRequest(req *http.Request, company domain.ICompany, opts ...Option) *httptest.ResponseRecorder {
 	r := mux.NewRouter()
-	InitAPI(r, company, log.StandardLogger())
+	InitAPI(r, company, log.StandardLogger(), opts...)
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
 	return rr
@@ -104,19 +198,20 @@ func TestGetManyCompaniesHandler(t *testing.T) {
 	rr := execRequest(
 		req,
 		&MockCompany{
-			getMany: func(_ context.Context, options *domain.FilterOptions) ([]domain.Company, error) {
-				if options.Params["name"] != "test" {
-					t.Errorf("want test but get %s", options.Params["name"])
+			getMany: func(_ context.Context, options *domain.FilterOptions) (domain.CompanyPage, error) {
+				want := domain.And{
+					domain.Condition{Field: "code", Op: domain.FilterContains, Values: []string{"testCode"}},
+					domain.Condition{Field: "name", Op: domain.FilterContains, Values: []string{"test"}},
 				}
-				if options.Params["code"] != "testCode" {
-					t.Errorf("want testCode but get %s", options.Params["code"])
+				if !reflect.DeepEqual(options.Where, want) {
+					t.Errorf("want filter %+v but get %+v", want, options.Where)
 				}
 				if options.Limit == nil {
 					t.Error("limit should be equal 3 but get nil")
 				} else if *options.Limit != 3 {
 					t.Errorf("want 3 and but get %d", *options.Limit)
 				}
-				return []domain.Company{}, nil
+				return domain.CompanyPage{Items: []domain.Company{}}, nil
 			},
 		},
 	)
@@ -125,6 +220,172 @@ func TestGetManyCompaniesHandler(t *testing.T) {
 	}
 }
 
+func TestSearchCompaniesHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/companies/search?q=acme+corporation&limit=5&country_code=UA", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		search: func(_ context.Context, options *domain.SearchOptions) ([]domain.SearchResult, error) {
+			if options.Query != "acme corporation" || options.Limit != 5 {
+				t.Errorf("want query acme corporation with limit 5 but got %+v", options)
+			}
+			if options.Where == nil {
+				t.Error("filter parameters should narrow the search")
+			}
+			return []domain.SearchResult{{
+				Company:    domain.Company{Name: "Acme Corp"},
+				Score:      0.5,
+				Highlights: map[string]string{"name": "<em>Acme</em> <em>Corp</em>"},
+			}}, nil
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d but got %d", http.StatusOK, rr.Code)
+	}
+	var res struct {
+		Items []domain.SearchResult `json:"items"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 1 || res.Items[0].Score != 0.5 || res.Items[0].Highlights["name"] == "" {
+		t.Errorf("want the found company with score and highlights but got %+v", res.Items)
+	}
+
+	req, err = http.NewRequest("GET", "/v1/companies/search?q=%20-", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := execRequest(req, &MockCompany{t: t}); rr.Code != http.StatusBadRequest {
+		t.Errorf("want status %d for an empty query but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestGetCompanyHistoryHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/company/a/c/history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		history: func(_ context.Context, name, code string) ([]domain.Revision, error) {
+			if name != "a" || code != "c" {
+				t.Errorf("want history of a/c but got %s/%s", name, code)
+			}
+			return []domain.Revision{
+				{ID: 2, Operation: domain.UpdateCompany, Actor: "alice", Before: &domain.Company{Name: "b"}, After: &domain.Company{Name: "a"}},
+				{ID: 1, Operation: domain.CreateCompany, Actor: "bob", After: &domain.Company{Name: "b"}},
+			}, nil
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d but got %d", http.StatusOK, rr.Code)
+	}
+	var res struct {
+		Items []domain.Revision `json:"items"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 2 || res.Items[0].Actor != "alice" || res.Items[1].Before != nil {
+		t.Errorf("want two revisions newest first but got %+v", res.Items)
+	}
+}
+
+func TestRestoreCompanyHandler(t *testing.T) {
+	req, err := http.NewRequest("POST", "/v1/company/a/c/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		restore: func(_ context.Context, name, code string) (domain.Company, error) {
+			return domain.Company{ID: testID, Name: name, Code: code}, nil
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d but got %d", http.StatusOK, rr.Code)
+	}
+	var company domain.Company
+	if err := json.NewDecoder(rr.Body).Decode(&company); err != nil {
+		t.Fatal(err)
+	}
+	if company.ID != testID || company.DeletedAt != nil {
+		t.Errorf("want the restored company but got %+v", company)
+	}
+
+	rr = execRequest(req, &MockCompany{
+		t: t,
+		restore: func(_ context.Context, _, _ string) (domain.Company, error) {
+			return domain.Company{}, domain.ErrAlreadyExists
+		},
+	})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("want status %d when an active company exists but got %d", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestGetManyCompaniesPagination(t *testing.T) {
+	cursor := domain.NewCursor(
+		[]domain.SortField{{Field: "name"}, {Field: "country", Desc: true}},
+		&domain.Company{ID: testID, Name: "a", Country: "Ukraine"},
+	).Encode()
+	crafted := (&domain.Cursor{Sort: "name", Values: []string{"a"}, ID: "not-uuid"}).Encode()
+	req, err := http.NewRequest("GET", "/v1/companies?limit=1000&sort=name,-country&total=true&include_deleted=true&cursor="+cursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		getMany: func(_ context.Context, options *domain.FilterOptions) (domain.CompanyPage, error) {
+			if options.Limit == nil || *options.Limit != MaxPageSize {
+				t.Errorf("limit should be capped to %d but got %v", MaxPageSize, options.Limit)
+			}
+			if len(options.Sort) != 2 || options.Sort[0].Field != "name" || !options.Sort[1].Desc {
+				t.Errorf("want sort by name and descending country but got %v", options.Sort)
+			}
+			if options.After == nil || options.After.Values[0] != "a" {
+				t.Errorf("want cursor after a but got %v", options.After)
+			}
+			if !options.WithTotal {
+				t.Error("total should be requested")
+			}
+			if !options.IncludeDeleted {
+				t.Error("deleted companies should be requested")
+			}
+			total := 2
+			return domain.CompanyPage{Items: []domain.Company{{Name: "b"}}, NextCursor: "next", Total: &total}, nil
+		},
+	}, WithIncludeDeleted())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("incorrect status code when try get companies: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	var page domain.CompanyPage
+	if err = json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Parse error %s", err.Error())
+	}
+	if len(page.Items) != 1 || page.NextCursor != "next" || page.Total == nil || *page.Total != 2 {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/companies?include_deleted=true", nil)
+	if rr := execRequest(req, &MockCompany{t: t}); rr.Code != http.StatusForbidden {
+		t.Errorf("want %d for deleted companies without authorization but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	for _, query := range []string{"sort=unknown", "sort=code&cursor=" + cursor, "sort=name&cursor=" + crafted, "limit=-1"} {
+		req, err := http.NewRequest("GET", "/v1/companies?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr := execRequest(req, &MockCompany{t: t}); rr.Code != http.StatusBadRequest {
+			t.Errorf("want %d for %s but got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
+
 func TestCreateCompanyHandler(t *testing.T) {
 	req, err := http.NewRequest(
 		"POST",
@@ -155,6 +416,22 @@ func TestCreateCompanyHandler(t *testing.T) {
 	}
 }
 
+func TestCreateCompanyHandlerRejectsID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/company", strings.NewReader(`{"id":"`+testID+`","name":"test","code":"testCode"}`))
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		create: func(_ context.Context, _ *domain.Company) error {
+			t.Error("a company with an id of the client must not be created")
+			return nil
+		},
+	})
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if rr.Code != http.StatusUnprocessableEntity || len(p.Errors) != 1 || p.Errors[0].Field != "id" {
+		t.Errorf("want validation problem of id, got %d: %+v", rr.Code, p)
+	}
+}
+
 func TestUpdateCompanyHandler(t *testing.T) {
 	req, err := http.NewRequest(
 		"PUT",
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+)
+
+func (a *API) parseID(r *http.Request) (string, error) {
+	id := strings.ToLower(strings.TrimSpace(mux.Vars(r)["id"]))
+	if !domain.IsValidID(id) {
+		return "", errors.New("id parameter must be a UUID")
+	}
+	return id, nil
+}
+
+// getCompanyByIDHandler get exact one company by its stable identifier
+func (a *API) getCompanyByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	company, err := a.iCompany.GetByID(r.Context(), id)
+	if err != nil {
+		a.l.Warnf("%s:Get company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	if notModified(r, &company) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
+
+// updateCompanyByIDHandler updates a company found by its identifier, the identifier never changes
+func (a *API) updateCompanyByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	var company domain.Company
+	if err := json.NewDecoder(r.Body).Decode(&company); err != nil {
+		a.l.Infof("%s:Parse company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	company.ID = id
+	if _, err := a.iCompany.UpdateByID(r.Context(), id, &company); err != nil {
+		a.l.Warnf("%s:Update company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(company)
+}
+
+// patchCompanyByIDHandler changes only the fields of a patch of a company found by its identifier
+func (a *API) patchCompanyByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	patch, err := parsePatch(r)
+	if err != nil {
+		a.l.Infof("%s:Parse patch: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	_, company, err := a.iCompany.PatchByID(r.Context(), id, patch)
+	if err != nil {
+		a.l.Warnf("%s:Patch company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(&company))
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
+
+func (a *API) deleteCompanyByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if r, err = a.withIfMatch(r); err != nil {
+		a.l.Infof("%s:Parse If-Match: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	if err = a.iCompany.DeleteByID(r.Context(), id); err != nil {
+		a.l.Warnf("%s:Delete company: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+const testID = "5f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f"
+
+func TestGetCompanyByIDHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v2/companies/"+testID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		getByID: func(_ context.Context, id string) (domain.Company, error) {
+			return domain.Company{ID: id, Name: "test", Code: "testCode"}, nil
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Errorf("incorrect status code when try get company: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	var company domain.Company
+	if err = json.NewDecoder(rr.Body).Decode(&company); err != nil {
+		t.Errorf("Parse error %s", err.Error())
+	}
+	if company.ID != testID {
+		t.Errorf("want id %s but got %s", testID, company.ID)
+	}
+
+	req, err = http.NewRequest("GET", "/v2/companies/not-uuid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr = execRequest(req, &MockCompany{t: t}); rr.Code != http.StatusBadRequest {
+		t.Errorf("want %d for invalid id but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestUpdateCompanyByIDHandler(t *testing.T) {
+	req, err := http.NewRequest(
+		"PUT",
+		"/v2/companies/"+testID,
+		strings.NewReader("{\"name\":\"new\",\"code\":\"newCode\"}"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		updateByID: func(_ context.Context, id string, company *domain.Company) error {
+			if id != testID || company.ID != testID {
+				t.Errorf("want the update of %s but got %s with id %s", testID, id, company.ID)
+			}
+			if company.Name != "new" || company.Code != "newCode" {
+				t.Errorf("want new:newCode but got %s:%s", company.Name, company.Code)
+			}
+			return nil
+		},
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("incorrect status code when try update company: want %d but got %d", http.StatusAccepted, rr.Code)
+	}
+}
+
+func TestDeleteCompanyByIDHandler(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/v2/companies/"+testID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		deleteByID: func(_ context.Context, id string) error {
+			if id != testID {
+				t.Errorf("want the delete of %s but got %s", testID, id)
+			}
+			return domain.ErrNotFound
+		},
+	})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("incorrect status code when try delete unknown company: want %d but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestPatchCompanyByIDHandler(t *testing.T) {
+	req, err := http.NewRequest("PATCH", "/v2/companies/"+testID, strings.NewReader(`{"website":"https://example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr := execRequest(req, &MockCompany{
+		t: t,
+		patchByID: func(_ context.Context, id string, patch *domain.CompanyPatch) (domain.Company, error) {
+			if id != testID || patch.Website == nil || *patch.Website != "https://example.com" {
+				t.Errorf("want the website patch of %s but got %s %+v", testID, id, patch)
+			}
+			return domain.Company{ID: id, Name: "test", Code: "testCode", Website: *patch.Website, Version: 2}, nil
+		},
+	})
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == "" {
+		t.Errorf("want %d with an ETag but got %d", http.StatusOK, rr.Code)
+	}
+}
@@ -2,27 +2,130 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemType - an entry of the problem types catalogue, see docs/problems.md
+type problemType struct {
+	name   string
+	title  string
+	status int
+}
+
+func (p problemType) uri() string {
+	return "urn:companysvc:problem:" + p.name
+}
+
+// The catalogue of problem types. Names are stable, clients can switch on them.
+var (
+	problemBadRequest           = problemType{name: "bad-request", title: "Bad request", status: http.StatusBadRequest}
+	problemUnauthorized         = problemType{name: "unauthorized", title: "Authentication required", status: http.StatusUnauthorized}
+	problemForbidden            = problemType{name: "forbidden", title: "Operation not allowed", status: http.StatusForbidden}
+	problemNotFound             = problemType{name: "not-found", title: "Company not found", status: http.StatusNotFound}
+	problemAlreadyExists        = problemType{name: "already-exists", title: "Company already exists", status: http.StatusConflict}
+	problemValidation           = problemType{name: "validation-failed", title: "Company data is invalid", status: http.StatusUnprocessableEntity}
+	problemPreconditionFailed   = problemType{name: "precondition-failed", title: "Company was changed", status: http.StatusPreconditionFailed}
+	problemPreconditionRequired = problemType{name: "precondition-required", title: "Company version is required", status: http.StatusPreconditionRequired}
+	problemRolledBack           = problemType{name: "rolled-back", title: "Operation rolled back", status: http.StatusFailedDependency}
+	problemTooLarge             = problemType{name: "too-large", title: "Request is too large", status: http.StatusRequestEntityTooLarge}
+	problemUnavailable          = problemType{name: "unavailable", title: "Service temporarily unavailable", status: http.StatusServiceUnavailable}
+	problemWebhookNotFound      = problemType{name: "webhook-not-found", title: "Webhook not found", status: http.StatusNotFound}
+	problemWebhookValidation    = problemType{name: "webhook-validation-failed", title: "Webhook data is invalid", status: http.StatusUnprocessableEntity}
+	problemInternal             = problemType{name: "internal", title: "Internal server error", status: http.StatusInternalServerError}
 )
 
 type httpError struct {
-	message any
-	code    int
+	problem problemType
+	detail  string
+	errors  []domain.FieldError
 }
 
 func (e httpError) Error() string {
-	if data, err := json.Marshal(e.message); err == nil {
-		return string(data)
+	return e.problem.title + ": " + e.detail
+}
+
+// problem - RFC 7807 problem details document
+type problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	Errors    []domain.FieldError `json:"errors,omitempty"`
+	RequestID string              `json:"requestId,omitempty"`
+}
+
+func badRequest(detail string) httpError {
+	return httpError{problem: problemBadRequest, detail: detail}
+}
+
+// invalidRequest reports field violations of request parameters or of a request body shape as a bad request
+func invalidRequest(detail string, err error) httpError {
+	var v *domain.ValidationError
+	if errors.As(err, &v) {
+		return httpError{problem: problemBadRequest, detail: detail, errors: v.Fields}
+	}
+	return badRequest(err.Error())
+}
+
+// toHTTPError translates a domain error into httpError
+func toHTTPError(err error) httpError {
+	var e httpError
+	if errors.As(err, &e) {
+		return e
+	}
+	var validation *domain.ValidationError
+	var denied *domain.AccessDeniedError
+	switch {
+	case errors.As(err, &validation):
+		return httpError{problem: problemValidation, detail: "One or more fields are invalid", errors: validation.Fields}
+	case errors.Is(err, domain.ErrValidation):
+		return httpError{problem: problemValidation, detail: err.Error()}
+	case errors.As(err, &denied):
+		return httpError{problem: problemForbidden, detail: denied.Reason}
+	case errors.Is(err, domain.ErrForbidden):
+		return httpError{problem: problemForbidden}
+	case errors.Is(err, domain.ErrNotFound):
+		return httpError{problem: problemNotFound}
+	case errors.Is(err, domain.ErrAlreadyExists):
+		return httpError{problem: problemAlreadyExists, detail: "A company with the same name and code already exists"}
+	case errors.Is(err, domain.ErrRolledBack):
+		return httpError{problem: problemRolledBack, detail: "Another operation of the batch failed"}
+	case errors.Is(err, domain.ErrPreconditionFailed):
+		return httpError{problem: problemPreconditionFailed, detail: "Get the company again and retry with its current ETag"}
+	case errors.Is(err, domain.ErrUnavailable):
+		return httpError{problem: problemUnavailable, detail: "Please retry the request later"}
 	}
-	return ""
+	return httpError{problem: problemInternal}
 }
 
-func (a *API) handleError(w http.ResponseWriter, err error) {
-	e, ok := err.(httpError)
-	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+// toProblem translates any error of the request into an RFC 7807 problem document
+func (a *API) toProblem(r *http.Request, err error) problem {
+	e := toHTTPError(err)
+	if e.problem.status >= http.StatusInternalServerError {
+		a.l.Errorf("%s:%s %s: %s", a.logPrefix, r.Method, r.URL.Path, err.Error())
+	}
+	requestID, _ := r.Context().Value(domain.CtxRequestIDKey).(string)
+	return problem{
+		Type:      e.problem.uri(),
+		Title:     e.problem.title,
+		Status:    e.problem.status,
+		Detail:    e.detail,
+		Instance:  r.URL.RequestURI(),
+		Errors:    e.errors,
+		RequestID: requestID,
 	}
-	w.WriteHeader(e.code)
-	w.Write([]byte(e.Error()))
+}
+
+// handleError writes any error as an RFC 7807 problem document
+func (a *API) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	p := a.toProblem(r, err)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
 }
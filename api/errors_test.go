@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+func TestErrorStatusMapping(t *testing.T) {
+	cases := map[int]error{
+		http.StatusNotFound:            fmt.Errorf("get company: %w", domain.ErrNotFound),
+		http.StatusConflict:            fmt.Errorf("create company: %w", domain.ErrAlreadyExists),
+		http.StatusForbidden:           &domain.AccessDeniedError{Operation: "get", Subject: "test", Reason: "test"},
+		http.StatusUnprocessableEntity: &domain.ValidationError{Fields: []domain.FieldError{{Field: "name", Message: "required"}}},
+		http.StatusServiceUnavailable:  fmt.Errorf("resolve ip: %w", domain.ErrUnavailable),
+		http.StatusInternalServerError: errors.New("unexpected"),
+	}
+	for code, serviceErr := range cases {
+		req, err := http.NewRequest("GET", "/v1/company/test/testCode", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", "req-1")
+		rr := execRequest(req, &MockCompany{
+			t: t,
+			get: func(_ context.Context, _ string, _ string) (domain.Company, error) {
+				return domain.Company{}, serviceErr
+			},
+		})
+		if rr.Code != code {
+			t.Errorf("want %d for %q but got %d", code, serviceErr.Error(), rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != problemContentType {
+			t.Errorf("want content type %s but got %s", problemContentType, ct)
+		}
+		var body problem
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Errorf("parse error body: %s", err.Error())
+			continue
+		}
+		if body.Type == "" || body.Title == "" || body.Status != code {
+			t.Errorf("problem should contain type, title and status %d but got %+v", code, body)
+		}
+		if body.Instance != "/v1/company/test/testCode" {
+			t.Errorf("want instance /v1/company/test/testCode but got %s", body.Instance)
+		}
+		if body.RequestID != "req-1" {
+			t.Errorf("want request id req-1 but got %s", body.RequestID)
+		}
+		if code == http.StatusUnprocessableEntity && len(body.Errors) != 1 {
+			t.Errorf("want one field violation but got %v", body.Errors)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// WithIfMatchRequired rejects PUT, PATCH and DELETE without an If-Match header with 428
+func WithIfMatchRequired(required bool) Option {
+	return func(a *API) {
+		a.requireIfMatch = required
+	}
+}
+
+// etag is a strong entity tag of a company version. The id is a part of the tag, so a tag of a deleted company
+// never matches a new company with the same version.
+func etag(company *domain.Company) string {
+	return `"` + company.ID + "-" + strconv.FormatInt(company.Version, 10) + `"`
+}
+
+// parseETags parses entity tags of If-Match or If-None-Match, wildcard is true for "*". Weak tags match only
+// with the weak comparison of If-None-Match (RFC 9110, section 13.1.2), foreign tags never match a version.
+func parseETags(values []string, weak bool) (versions domain.ExpectedVersions, wildcard bool) {
+	versions = domain.ExpectedVersions{}
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" {
+				return nil, true
+			}
+			if strings.HasPrefix(tag, "W/") {
+				if !weak {
+					continue
+				}
+				tag = tag[2:]
+			}
+			if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+				continue
+			}
+			tag = tag[1 : len(tag)-1]
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 || !domain.IsValidID(tag[:i]) {
+				continue
+			}
+			if v, err := strconv.ParseInt(tag[i+1:], 10, 64); err == nil {
+				versions = append(versions, domain.ExpectedVersion{ID: tag[:i], Version: v})
+			}
+		}
+	}
+	return versions, false
+}
+
+// withIfMatch puts versions of If-Match into the request context, so the change fails if the company was changed
+func (a *API) withIfMatch(r *http.Request) (*http.Request, error) {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		if a.requireIfMatch {
+			return r, httpError{problem: problemPreconditionRequired, detail: "Send the ETag of the company in If-Match"}
+		}
+		return r, nil
+	}
+	versions, wildcard := parseETags(values, false)
+	if wildcard {
+		return r, nil // any existing company
+	}
+	return r.WithContext(context.WithValue(r.Context(), domain.CtxExpectedVersionKey, versions)), nil
+}
+
+// notModified reports whether If-None-Match has the company version, the response is 304 then
+func notModified(r *http.Request, company *domain.Company) bool {
+	values := r.Header.Values("If-None-Match")
+	if len(values) == 0 {
+		return false
+	}
+	versions, wildcard := parseETags(values, true)
+	return wildcard || versions.Allows(company.ID, company.Version)
+}
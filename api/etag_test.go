@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const otherID = "6f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f"
+
+func TestParseETags(t *testing.T) {
+	tag := func(id string, version int64) string {
+		return fmt.Sprintf(`"%s-%d"`, id, version)
+	}
+	cases := []struct {
+		values   []string
+		weak     bool
+		versions domain.ExpectedVersions
+		wildcard bool
+	}{
+		{[]string{tag(testID, 3)}, false, domain.ExpectedVersions{{ID: testID, Version: 3}}, false},
+		{
+			[]string{tag(testID, 1) + ", " + tag(otherID, 2), tag(testID, 5)}, false,
+			domain.ExpectedVersions{{ID: testID, Version: 1}, {ID: otherID, Version: 2}, {ID: testID, Version: 5}}, false,
+		},
+		{[]string{"W/" + tag(testID, 3), `"3"`, `"abc-3"`, tag(testID, 4)[1:]}, false, domain.ExpectedVersions{}, false},
+		{[]string{"W/" + tag(testID, 3)}, true, domain.ExpectedVersions{{ID: testID, Version: 3}}, false},
+		{[]string{tag(testID, 1) + ", *"}, false, nil, true},
+	}
+	for _, c := range cases {
+		versions, wildcard := parseETags(c.values, c.weak)
+		if wildcard != c.wildcard || !reflect.DeepEqual(versions, c.versions) {
+			t.Errorf("%q: want %v %t but got %v %t", c.values, c.versions, c.wildcard, versions, wildcard)
+		}
+	}
+}
+
+func TestGetCompanyHandlerETag(t *testing.T) {
+	company := &MockCompany{
+		t: t,
+		get: func(_ context.Context, name, code string) (domain.Company, error) {
+			return domain.Company{ID: testID, Name: name, Code: code, Version: 7}, nil
+		},
+	}
+	want := `"` + testID + `-7"`
+	req := httptest.NewRequest("GET", "/v1/company/test/testCode", nil)
+	rr := execRequest(req, company)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != want {
+		t.Errorf("want 200 with ETag %s but got %d with %q", want, rr.Code, rr.Header().Get("ETag"))
+	}
+
+	for _, ifNoneMatch := range []string{`"` + testID + `-6", ` + want, "W/" + want} {
+		req = httptest.NewRequest("GET", "/v1/company/test/testCode", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rr = execRequest(req, company)
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("%s: want 304 without body but got %d: %s", ifNoneMatch, rr.Code, rr.Body.String())
+		}
+	}
+
+	// a stale version and the same version of a deleted company with another id
+	for _, ifNoneMatch := range []string{`"` + testID + `-6"`, `"` + otherID + `-7"`, `"7"`} {
+		req = httptest.NewRequest("GET", "/v1/company/test/testCode", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		if rr = execRequest(req, company); rr.Code != http.StatusOK {
+			t.Errorf("%s: want 200 for a stale ETag but got %d", ifNoneMatch, rr.Code)
+		}
+	}
+}
+
+func TestUpdateCompanyHandlerIfMatch(t *testing.T) {
+	var expected interface{}
+	company := &MockCompany{
+		t: t,
+		update: func(ctx context.Context, _, _ string, c *domain.Company) error {
+			expected = ctx.Value(domain.CtxExpectedVersionKey)
+			versions, ok := expected.(domain.ExpectedVersions)
+			if ok && !versions.Allows(testID, 3) {
+				return fmt.Errorf("%w: current version is 3", domain.ErrPreconditionFailed)
+			}
+			c.ID, c.Version = testID, 4
+			return nil
+		},
+	}
+	body := `{"name": "a", "code": "c", "country": "Ukraine", "website": "example.com", "phone": "+380441234567"}`
+
+	req := httptest.NewRequest("PUT", "/v1/company/a/c", strings.NewReader(body))
+	req.Header.Set("If-Match", `"`+testID+`-3"`)
+	rr := execRequest(req, company)
+	if rr.Code != http.StatusAccepted || rr.Header().Get("ETag") != `"`+testID+`-4"` {
+		t.Errorf("want 202 with the ETag of version 4 but got %d with %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	if !reflect.DeepEqual(expected, domain.ExpectedVersions{{ID: testID, Version: 3}}) {
+		t.Errorf("want expected version 3 in the context but got %v", expected)
+	}
+
+	// a stale version, the version of another company and a weak tag never match
+	for _, ifMatch := range []string{`"` + testID + `-2"`, `"` + otherID + `-3"`, `W/"` + testID + `-3"`} {
+		req = httptest.NewRequest("PUT", "/v1/company/a/c", strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		rr = execRequest(req, company)
+		if rr.Code != http.StatusPreconditionFailed || !strings.Contains(rr.Body.String(), "precondition-failed") {
+			t.Errorf("%s: want 412 precondition-failed but got %d: %s", ifMatch, rr.Code, rr.Body.String())
+		}
+	}
+
+	req = httptest.NewRequest("PUT", "/v1/company/a/c", strings.NewReader(body))
+	req.Header.Set("If-Match", "*")
+	if rr = execRequest(req, company); rr.Code != http.StatusAccepted || expected != nil {
+		t.Errorf("want 202 without expected versions for * but got %d and %v", rr.Code, expected)
+	}
+}
+
+func TestDeleteCompanyHandlerIfMatchRequired(t *testing.T) {
+	r := mux.NewRouter()
+	InitAPI(r, &MockCompany{
+		t: t,
+		delete: func(ctx context.Context, _, _ string) error {
+			return nil
+		},
+	}, log.StandardLogger(), WithIfMatchRequired(true))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("DELETE", "/v1/company/a/c", nil))
+	if rr.Code != http.StatusPreconditionRequired || !strings.Contains(rr.Body.String(), "precondition-required") {
+		t.Errorf("want 428 precondition-required but got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest("DELETE", "/v1/company/a/c", nil)
+	req.Header.Set("If-Match", `"`+testID+`-1"`)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("want 202 with If-Match but got %d", rr.Code)
+	}
+}
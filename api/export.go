@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+
+	exportFlushRows = 100 // rows are sent to the client in portions of it
+)
+
+// exportColumns - the CSV header of an export, import reads the same columns
+var exportColumns = []string{"id", "name", "code", "country", "countryCode", "website", "phone", "phoneE164", "version", "deletedAt"}
+
+// companyEncoder writes companies of an export one by one
+type companyEncoder interface {
+	Encode(c *domain.Company) error
+	Flush() error
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) (*csvEncoder, error) {
+	e := &csvEncoder{w: csv.NewWriter(w)}
+	return e, e.w.Write(exportColumns)
+}
+
+func (e *csvEncoder) Encode(c *domain.Company) error {
+	deletedAt := ""
+	if c.DeletedAt != nil {
+		deletedAt = c.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return e.w.Write([]string{
+		c.ID, c.Name, c.Code, c.Country, c.CountryCode, c.Website, c.Phone.Original, c.Phone.E164,
+		strconv.FormatInt(c.Version, 10), deletedAt,
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonEncoder) Encode(c *domain.Company) error {
+	return e.enc.Encode(c) // a json.Encoder ends every value with a new line
+}
+
+func (e ndjsonEncoder) Flush() error {
+	return nil
+}
+
+// exportCompaniesHandler streams all companies matching the filter parameters of getCompaniesHandler
+// as CSV or NDJSON (format parameter) sorted by the sort parameter
+func (a *API) exportCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatNDJSON {
+		a.handleError(w, r, badRequest("format must be csv or ndjson"))
+		return
+	}
+	var filter domain.FilterOptions
+	sort, err := domain.ParseSort(query.Get("sort"))
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	filter.Sort = sort
+	if filter.IncludeDeleted, err = a.parseIncludeDeleted(query); err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	if filter.Where, err = parseFilter(query); err != nil {
+		a.l.Infof("%s:Parse filter: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, filterError(err))
+		return
+	}
+
+	var (
+		enc  companyEncoder
+		rows int
+	)
+	// the response starts with the first company, so errors of the filter and the policy are still returned as problems
+	start := func() error {
+		if format == formatNDJSON {
+			w.Header().Set("Content-Type", contentTypeNDJSON)
+		} else {
+			w.Header().Set("Content-Type", contentTypeCSV+"; charset=utf-8")
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="companies.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		if format == formatNDJSON {
+			enc = ndjsonEncoder{json.NewEncoder(w)}
+			return nil
+		}
+		var err error
+		enc, err = newCSVEncoder(w)
+		return err
+	}
+	err = a.iCompany.Export(r.Context(), &filter, func(company *domain.Company) error {
+		if enc == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(company); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && enc == nil {
+		a.l.Warnf("%s:Export companies: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	if err != nil {
+		// the status is sent already, the client gets a truncated export
+		a.l.Errorf("%s:Export companies after %d rows: %s", a.logPrefix, rows, err.Error())
+		return
+	}
+	if enc == nil {
+		if err := start(); err != nil {
+			a.l.Errorf("%s:Export companies: %s", a.logPrefix, err.Error())
+			return
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		a.l.Errorf("%s:Export companies: %s", a.logPrefix, err.Error())
+	}
+}
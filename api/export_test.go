@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+func exportMock(t *testing.T, companies ...domain.Company) *MockCompany {
+	return &MockCompany{
+		t: t,
+		export: func(_ context.Context, filter *domain.FilterOptions, fn func(*domain.Company) error) error {
+			if filter.Limit != nil {
+				t.Errorf("export should not be limited but got %d", *filter.Limit)
+			}
+			for i := range companies {
+				if err := fn(&companies[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestExportCompaniesHandlerCSV(t *testing.T) {
+	company := exportMock(t,
+		domain.Company{ID: "1", Name: "Acme, Inc", Code: "a", Country: "Ukraine", CountryCode: "UA", Version: 2,
+			Phone: domain.Phone{Original: "044 123 45 67", E164: "+380441234567"}},
+		domain.Company{ID: "2", Name: "Beta", Code: "b", Country: "Cyprus", CountryCode: "CY", Version: 1},
+	)
+	rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export?format=csv&country=eq:UA", nil), company)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), contentTypeCSV) {
+		t.Fatalf("want CSV but got %d %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(exportColumns, ",") {
+		t.Fatalf("want a header and 2 rows but got %v", rows)
+	}
+	if rows[1][1] != "Acme, Inc" || rows[1][7] != "+380441234567" || rows[1][8] != "2" {
+		t.Errorf("unexpected row %v", rows[1])
+	}
+}
+
+func TestExportCompaniesHandlerNDJSON(t *testing.T) {
+	company := exportMock(t, domain.Company{ID: "1", Name: "a"}, domain.Company{ID: "2", Name: "b"})
+	rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export?format=ndjson", nil), company)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != contentTypeNDJSON {
+		t.Fatalf("want NDJSON but got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines but got %q", lines)
+	}
+	var c domain.Company
+	if err := json.Unmarshal([]byte(lines[1]), &c); err != nil || c.ID != "2" {
+		t.Errorf("want company 2 but got %+v, %v", c, err)
+	}
+}
+
+func TestExportCompaniesHandlerErrors(t *testing.T) {
+	if rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export?format=xml", nil), &MockCompany{t: t}); rr.Code != http.StatusBadRequest {
+		t.Errorf("want 400 for an unknown format but got %d", rr.Code)
+	}
+	denied := &MockCompany{
+		t: t,
+		export: func(_ context.Context, _ *domain.FilterOptions, _ func(*domain.Company) error) error {
+			return &domain.AccessDeniedError{Operation: "getMany", Subject: "alice", Reason: "missing required scopes or roles"}
+		},
+	}
+	if rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export", nil), denied); rr.Code != http.StatusForbidden {
+		t.Errorf("want 403 before the export starts but got %d", rr.Code)
+	}
+	if rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export?include_deleted=true", nil), &MockCompany{t: t}); rr.Code != http.StatusForbidden {
+		t.Errorf("want 403 for deleted companies without authorization but got %d", rr.Code)
+	}
+	broken := exportMock(t, domain.Company{ID: "1"})
+	export := broken.export
+	broken.export = func(ctx context.Context, filter *domain.FilterOptions, fn func(*domain.Company) error) error {
+		if err := export(ctx, filter, fn); err != nil {
+			return err
+		}
+		return errors.New("connection reset")
+	}
+	rr := execRequest(httptest.NewRequest("GET", "/v1/companies/export", nil), broken)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "problem") {
+		t.Errorf("want a truncated export without a problem but got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// filterParamAliases - query parameter names which differ from the json names of Company fields
+var filterParamAliases = map[string]string{"country_code": "countryCode"}
+
+// parseFilter builds the filter of company list query parameters. Every parameter is a condition
+// field=[not:][op:]value with op one of eq, prefix, contains (default), in (comma separated values),
+// empty and null (no value). An "or" parameter is a group of conditions separated by "|", at least one
+// of them must match, e.g. or=name=prefix:Acme|country_code=in:UA,CY. All parameters and groups are ANDed.
+// country is the stored country name, ISO codes are filtered by country_code.
+func parseFilter(query url.Values) (domain.Filter, error) {
+	var v domain.ValidationError
+	where := domain.And{}
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+	sort.Strings(params) // stable order of conditions gives stable SQL
+	for _, param := range params {
+		field := param
+		if alias, ok := filterParamAliases[param]; ok {
+			field = alias
+		}
+		if !domain.IsFilterField(field) {
+			continue // limit, sort and other parameters which are not filters
+		}
+		for _, value := range query[param] {
+			cond, err := parseCondition(field, value)
+			if err != nil {
+				v.Add(param, err.Error())
+				continue
+			}
+			where = append(where, cond)
+		}
+	}
+	for _, group := range query["or"] {
+		or := domain.Or{}
+		for _, expr := range strings.Split(group, "|") {
+			param, value, ok := strings.Cut(expr, "=")
+			param = strings.TrimSpace(param)
+			field := param
+			if alias, found := filterParamAliases[param]; found {
+				field = alias
+			}
+			if !ok || !domain.IsFilterField(field) {
+				v.Add("or", fmt.Sprintf("%q must be field=[not:][op:]value with one of fields %s",
+					expr, strings.Join(domain.CompanyFilterFields, ", ")))
+				continue
+			}
+			cond, err := parseCondition(field, value)
+			if err != nil {
+				v.Add("or", param+": "+err.Error())
+				continue
+			}
+			or = append(or, cond)
+		}
+		where = append(where, or)
+	}
+	if err := v.OrNil(); err != nil {
+		return nil, err
+	}
+	if len(where) == 0 {
+		return nil, nil
+	}
+	return where, nil
+}
+
+// filterError reports invalid parameters of parseFilter as a bad request
+func filterError(err error) httpError {
+	return invalidRequest("Filter is invalid", err)
+}
+
+// parseCondition parses [not:][op:]value of a field
+func parseCondition(field, value string) (domain.Condition, error) {
+	cond := domain.Condition{Field: field, Op: domain.FilterContains}
+	if field == "id" || field == "countryCode" {
+		cond.Op = domain.FilterEq // substrings of codes make no sense
+	}
+	value = strings.TrimSpace(value)
+	if rest, ok := cutPrefixFold(value, "not:"); ok {
+		cond.Not, value = true, rest
+	}
+	for _, op := range []domain.FilterOp{domain.FilterEq, domain.FilterPrefix, domain.FilterContains, domain.FilterIn} {
+		if rest, ok := cutPrefixFold(value, string(op)+":"); ok {
+			cond.Op, value = op, rest
+			break
+		}
+	}
+	for _, op := range []domain.FilterOp{domain.FilterEmpty, domain.FilterNull} {
+		if strings.EqualFold(value, string(op)) {
+			cond.Op, value = op, ""
+			return cond, nil
+		}
+	}
+
+	if cond.Op == domain.FilterIn {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				cond.Values = append(cond.Values, item)
+			}
+		}
+	} else if value = strings.TrimSpace(value); value != "" {
+		cond.Values = []string{value}
+	}
+	if len(cond.Values) == 0 {
+		return cond, errors.New("value can not be empty, use empty or null to find companies without a value")
+	}
+	if field == "id" && (cond.Op == domain.FilterEq || cond.Op == domain.FilterIn) {
+		for i := range cond.Values {
+			if !domain.IsValidID(cond.Values[i]) {
+				return cond, fmt.Errorf("%q is not a valid company id", cond.Values[i])
+			}
+		}
+	}
+	if field == "phone" {
+		for i := range cond.Values {
+			if domain.PhoneDigits(cond.Values[i]) == "" {
+				return cond, errors.New("phone filter must contain digits")
+			}
+		}
+	}
+	return cond, nil
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+func TestParseFilter(t *testing.T) {
+	testCases := map[string]domain.Filter{
+		"limit=10&sort=name": nil,
+		"country_code=in:UA,%20CY&name=prefix:Acme&website=empty": domain.And{
+			domain.Condition{Field: "countryCode", Op: domain.FilterIn, Values: []string{"UA", "CY"}},
+			domain.Condition{Field: "name", Op: domain.FilterPrefix, Values: []string{"Acme"}},
+			domain.Condition{Field: "website", Op: domain.FilterEmpty},
+		},
+		"country=eq:Ukraine&country_code=not:ua&phone=not:null": domain.And{
+			domain.Condition{Field: "country", Op: domain.FilterEq, Values: []string{"Ukraine"}},
+			domain.Condition{Field: "countryCode", Op: domain.FilterEq, Values: []string{"ua"}, Not: true},
+			domain.Condition{Field: "phone", Op: domain.FilterNull, Not: true},
+		},
+		"name=eq:prefix:x&name=Acme:Corp": domain.And{
+			domain.Condition{Field: "name", Op: domain.FilterEq, Values: []string{"prefix:x"}},
+			domain.Condition{Field: "name", Op: domain.FilterContains, Values: []string{"Acme:Corp"}},
+		},
+		"or=name%3Dprefix:Acme|country_code%3Din:UA,CY&phone=%2B380%2044": domain.And{
+			domain.Condition{Field: "phone", Op: domain.FilterContains, Values: []string{"+380 44"}},
+			domain.Or{
+				domain.Condition{Field: "name", Op: domain.FilterPrefix, Values: []string{"Acme"}},
+				domain.Condition{Field: "countryCode", Op: domain.FilterIn, Values: []string{"UA", "CY"}},
+			},
+		},
+	}
+	for query, want := range testCases {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseFilter(values)
+		if err != nil {
+			t.Errorf("error was not expected for %s: %s", query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %+v but got %+v", query, want, got)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, query := range []string{
+		"name=prefix:",
+		"country=in:,",
+		"phone=abc",
+		"id=eq:1",
+		"or=unknown%3Da",
+		"or=name",
+	} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseFilter(values); err == nil {
+			t.Errorf("want error for %s", query)
+		}
+	}
+}
+
+func TestGetManyCompaniesInvalidFilter(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/companies?name=prefix:", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := execRequest(req, &MockCompany{t: t})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want status %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// importFields - company fields read by an import and their default column names besides the field name
+var importFields = map[string][]string{
+	"name":        nil,
+	"code":        nil,
+	"country":     nil,
+	"countryCode": {"country_code"},
+	"website":     nil,
+	"phone":       nil,
+}
+
+const (
+	maxNDJSONLine   = 1 << 20
+	maxImportErrors = 100
+)
+
+// importRecord - values of a CSV row or a NDJSON object by lower case column names
+type importRecord map[string]string
+
+// recordReader reads records of an upload one by one, it returns io.EOF at the end.
+// An error of a single record is returned with its line, the reader can continue.
+type recordReader interface {
+	Read() (line int, record importRecord, err error)
+}
+
+// lineError - a malformed record, the import continues
+type lineError struct {
+	err error
+}
+
+func (e lineError) Error() string {
+	return e.err.Error()
+}
+
+type csvRecordReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVRecordReader(r io.Reader) (*csvRecordReader, error) {
+	c := &csvRecordReader{r: csv.NewReader(r)}
+	c.r.FieldsPerRecord = -1 // rows of other length are reported as line errors
+	header, err := c.r.Read()
+	if err != nil {
+		return nil, badRequest("CSV header is required: " + err.Error())
+	}
+	for _, h := range header {
+		c.header = append(c.header, strings.ToLower(strings.TrimSpace(h)))
+	}
+	return c, nil
+}
+
+func (c *csvRecordReader) Read() (int, importRecord, error) {
+	row, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.Line, nil, err // can't continue after a broken quote
+		}
+		return 0, nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	if len(row) != len(c.header) {
+		return line, nil, lineError{fmt.Errorf("row has %d fields but the header has %d", len(row), len(c.header))}
+	}
+	record := make(importRecord, len(row))
+	for i := range row {
+		record[c.header[i]] = row[i]
+	}
+	return line, record, nil
+}
+
+type ndjsonRecordReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONRecordReader(r io.Reader) *ndjsonRecordReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	return &ndjsonRecordReader{s: s}
+}
+
+func (n *ndjsonRecordReader) Read() (int, importRecord, error) {
+	for n.s.Scan() {
+		n.line++
+		text := strings.TrimSpace(n.s.Text())
+		if text == "" {
+			continue
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(text), &values); err != nil {
+			return n.line, nil, lineError{fmt.Errorf("line must be a JSON object: %w", err)}
+		}
+		record := make(importRecord, len(values))
+		for key, raw := range values {
+			var value *string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return n.line, nil, lineError{fmt.Errorf("%s must be a string or null", key)}
+			}
+			if value != nil {
+				record[strings.ToLower(key)] = *value
+			}
+		}
+		return n.line, record, nil
+	}
+	if err := n.s.Err(); err != nil {
+		return n.line + 1, nil, err
+	}
+	return n.line, nil, io.EOF
+}
+
+// importMapping - column names of company fields, map.<field>=<column> parameters override the defaults
+type importMapping map[string][]string
+
+func parseImportMapping(query url.Values) (importMapping, error) {
+	var v domain.ValidationError
+	mapping := make(importMapping, len(importFields))
+	for field, aliases := range importFields {
+		mapping[field] = append([]string{strings.ToLower(field)}, aliases...)
+	}
+	for param := range query {
+		field := strings.TrimPrefix(param, "map.")
+		if field == param {
+			continue
+		}
+		if _, ok := importFields[field]; !ok {
+			v.Add(param, "is not a company field")
+			continue
+		}
+		mapping[field] = []string{strings.ToLower(strings.TrimSpace(query.Get(param)))}
+	}
+	if err := v.OrNil(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// missing returns mapped fields without a column of the CSV header
+func (m importMapping) missing(header []string, query url.Values) []domain.FieldError {
+	var errs []domain.FieldError
+	for field, columns := range m {
+		if query.Get("map."+field) == "" {
+			continue
+		}
+		found := false
+		for _, h := range header {
+			found = found || h == columns[0]
+		}
+		if !found {
+			errs = append(errs, domain.FieldError{Field: "map." + field, Message: "column " + columns[0] + " is not found"})
+		}
+	}
+	return errs
+}
+
+func (m importMapping) value(record importRecord, field string) string {
+	for _, column := range m[field] {
+		if value, ok := record[column]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func (m importMapping) company(record importRecord) *domain.Company {
+	return &domain.Company{
+		Name:        m.value(record, "name"),
+		Code:        m.value(record, "code"),
+		Country:     m.value(record, "country"),
+		CountryCode: m.value(record, "countryCode"),
+		Website:     m.value(record, "website"),
+		Phone:       domain.Phone{Original: m.value(record, "phone")},
+	}
+}
+
+type importLineError struct {
+	Line  int     `json:"line"`
+	Error problem `json:"error"`
+}
+
+type importReport struct {
+	DryRun    bool              `json:"dryRun"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"` // created, or valid with dry run
+	Failed    int               `json:"failed"`
+	Errors    []importLineError `json:"errors"` // the first maxImportErrors by line
+	// LastLine - every row up to this line is counted, a failed import can be resumed after it
+	LastLine int `json:"lastLine"`
+}
+
+// add counts the failed rows of a processed chunk and lists their errors while the list is not full
+func (r *importReport) add(errs []importLineError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	r.Failed += len(errs)
+	for i := 0; i < len(errs) && len(r.Errors) < maxImportErrors; i++ {
+		r.Errors = append(r.Errors, errs[i])
+	}
+}
+
+// importProblem - a problem which stopped the import with the report of the rows processed before
+type importProblem struct {
+	problem
+	Report importReport `json:"report"`
+}
+
+var errImportTooLarge = errors.New("import is too large")
+
+// importBody fails with errImportTooLarge after max bytes, so a too large upload is not cut silently
+type importBody struct {
+	r    io.Reader
+	left int64
+}
+
+func (b *importBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, errImportTooLarge
+	}
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.left {
+		n, b.left = int(b.left), -1
+		return n, errImportTooLarge
+	}
+	b.left -= int64(n)
+	return n, err
+}
+
+// WithMaxImportSize - the largest upload of POST /v1/companies/import in bytes
+func WithMaxImportSize(size int64) Option {
+	return func(a *API) {
+		if size > 0 {
+			a.maxImportSize = size
+		}
+	}
+}
+
+// importFormat is the format parameter or the format of the Content-Type, CSV by default
+func importFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = formatCSV
+		if contentType == contentTypeNDJSON {
+			format = formatNDJSON
+		}
+	}
+	if format != formatCSV && format != formatNDJSON {
+		return "", badRequest("format must be csv or ndjson")
+	}
+	return format, nil
+}
+
+// importCompaniesHandler creates companies of a CSV or NDJSON upload. Every row is created on its own and
+// rows are sent to the storage in batches of domain.MaxBatchSize. With dry_run=true rows are only validated.
+// If the import stops, e.g. the storage is unavailable, the problem has the report of the processed rows.
+func (a *API) importCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := importFormat(r)
+	if err != nil {
+		a.handleError(w, r, err)
+		return
+	}
+	mapping, err := parseImportMapping(query)
+	if err != nil {
+		a.l.Infof("%s:Parse import mapping: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, invalidRequest("Mapping is invalid", err))
+		return
+	}
+	body := &importBody{r: r.Body, left: a.maxImportSize}
+	var reader recordReader
+	if format == formatCSV {
+		csvReader, err := newCSVRecordReader(body)
+		if err != nil {
+			a.handleError(w, r, err)
+			return
+		}
+		if errs := mapping.missing(csvReader.header, query); len(errs) > 0 {
+			a.handleError(w, r, httpError{problem: problemBadRequest, detail: "Mapping is invalid", errors: errs})
+			return
+		}
+		reader = csvReader
+	} else {
+		reader = newNDJSONRecordReader(body)
+	}
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+	report := importReport{DryRun: dryRun, Errors: make([]importLineError, 0)}
+	options := &domain.BatchOptions{ContinueOnError: true, DryRun: dryRun}
+
+	// rows read since the last batch, the report counts them when the batch is done
+	ops := make([]domain.BatchOperation, 0, domain.MaxBatchSize)
+	lines := make([]int, 0, domain.MaxBatchSize)
+	var malformed []importLineError
+	lastLine := 0
+	apply := func() error {
+		var errs []importLineError
+		if len(ops) > 0 {
+			results, err := a.iCompany.Batch(r.Context(), ops, options)
+			if err != nil {
+				return err
+			}
+			for i := range results {
+				if results[i].Err != nil {
+					errs = append(errs, importLineError{Line: lines[i], Error: a.toProblem(r, results[i].Err)})
+				} else {
+					report.Succeeded++
+				}
+			}
+		}
+		report.add(append(errs, malformed...))
+		report.Total += len(ops) + len(malformed)
+		report.LastLine = lastLine
+		ops, lines, malformed = ops[:0], lines[:0], nil
+		return nil
+	}
+	fail := func(err error) {
+		a.l.Warnf("%s:Import companies: %s", a.logPrefix, err.Error())
+		p := importProblem{problem: a.toProblem(r, err), Report: report}
+		w.Header().Set("Content-Type", problemContentType)
+		w.WriteHeader(p.Status)
+		json.NewEncoder(w).Encode(p)
+	}
+	for {
+		line, record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errImportTooLarge) {
+			if err := apply(); err != nil {
+				fail(err)
+				return
+			}
+			fail(httpError{problem: problemTooLarge, detail: fmt.Sprintf("Upload at most %d bytes", a.maxImportSize)})
+			return
+		}
+		lastLine = line
+		if err != nil {
+			malformed = append(malformed, importLineError{Line: line, Error: a.toProblem(r, badRequest(err.Error()))})
+			var lineErr lineError
+			if errors.As(err, &lineErr) {
+				continue
+			}
+			break // the rest of the upload can't be read
+		}
+		ops = append(ops, domain.BatchOperation{Action: domain.BatchCreate, Company: mapping.company(record)})
+		lines = append(lines, line)
+		if len(ops) == domain.MaxBatchSize {
+			if err := apply(); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}
+	if err := apply(); err != nil {
+		fail(err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
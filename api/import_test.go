@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+func importMock(t *testing.T, created *[]domain.Company, dryRun bool) *MockCompany {
+	return &MockCompany{
+		t: t,
+		batch: func(_ context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+			if !options.ContinueOnError || options.DryRun != dryRun {
+				t.Errorf("unexpected options %+v", options)
+			}
+			results := make([]domain.BatchResult, len(ops))
+			for i, op := range ops {
+				if op.Action != domain.BatchCreate {
+					t.Errorf("want create but got %s", op.Action)
+				}
+				if op.Company.Country == "" && op.Company.CountryCode == "" {
+					results[i].Err = &domain.ValidationError{Fields: []domain.FieldError{{Field: "country", Message: "is required"}}}
+					continue
+				}
+				*created = append(*created, *op.Company)
+				results[i].Company = *op.Company
+			}
+			return results, nil
+		},
+	}
+}
+
+func decodeReport(t *testing.T, body string) importReport {
+	var report importReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestImportCompaniesHandlerCSV(t *testing.T) {
+	body := "Company Name,Code,Country_Code,Phone,Ignored\n" +
+		"Acme,a,UA,044 123 45 67,x\n" +
+		"Beta,b,,,\n" +
+		"Gamma,g\n" +
+		"Delta,d,CY,,\n"
+	var created []domain.Company
+	req := httptest.NewRequest("POST", "/v1/companies/import?map.name=company+name", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentTypeCSV)
+	rr := execRequest(req, importMock(t, &created, false))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	report := decodeReport(t, rr.Body.String())
+	if report.Total != 4 || report.Succeeded != 2 || report.Failed != 2 || len(report.Errors) != 2 {
+		t.Fatalf("want 2 created and 2 failed rows but got %+v", report)
+	}
+	if report.Errors[0].Line != 3 || report.Errors[0].Error.Type != problemValidation.uri() {
+		t.Errorf("want invalid row 3 reported but got %+v", report.Errors[0])
+	}
+	if report.Errors[1].Line != 4 || report.Errors[1].Error.Type != problemBadRequest.uri() {
+		t.Errorf("want the short row 4 reported but got %+v", report.Errors[1])
+	}
+	if len(created) != 2 || created[0].Name != "Acme" || created[0].CountryCode != "UA" || created[0].Phone.Original != "044 123 45 67" {
+		t.Errorf("unexpected companies %+v", created)
+	}
+}
+
+func TestImportCompaniesHandlerNDJSONDryRun(t *testing.T) {
+	body := `{"name": "Acme", "code": "a", "country": "Ukraine"}
+
+{"name": "Beta", "code": 1}
+{"name": "Gamma", "code": "g", "website": null}
+`
+	var created []domain.Company
+	req := httptest.NewRequest("POST", "/v1/companies/import?format=ndjson&dry_run=true", strings.NewReader(body))
+	rr := execRequest(req, importMock(t, &created, true))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	report := decodeReport(t, rr.Body.String())
+	if !report.DryRun || report.Total != 3 || report.Succeeded != 1 || report.Failed != 2 {
+		t.Fatalf("want 1 valid and 2 invalid lines but got %+v", report)
+	}
+	if report.Errors[0].Line != 3 || report.Errors[1].Line != 4 {
+		t.Errorf("want lines 3 and 4 reported but got %+v", report.Errors)
+	}
+}
+
+func TestImportCompaniesHandlerInvalidMapping(t *testing.T) {
+	for _, target := range []string{"/v1/companies/import?map.id=x", "/v1/companies/import?map.name=title"} {
+		req := httptest.NewRequest("POST", target, strings.NewReader("name,code\na,b\n"))
+		if rr := execRequest(req, &MockCompany{t: t}); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400 but got %d", target, rr.Code)
+		}
+	}
+}
+
+func TestImportCompaniesHandlerStopped(t *testing.T) {
+	var body strings.Builder
+	body.WriteString("name,code,country\n")
+	for i := 0; i < domain.MaxBatchSize+10; i++ {
+		fmt.Fprintf(&body, "c%d,%d,\n", i, i) // every row is invalid
+	}
+	var created []domain.Company
+	mock := importMock(t, &created, false)
+	batch, calls := mock.batch, 0
+	mock.batch = func(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+		if calls++; calls > 1 {
+			return nil, domain.ErrUnavailable
+		}
+		return batch(ctx, ops, options)
+	}
+	req := httptest.NewRequest("POST", "/v1/companies/import", strings.NewReader(body.String()))
+	rr := execRequest(req, mock)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	var p importProblem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	report := p.Report
+	if p.Type != problemUnavailable.uri() || report.Total != domain.MaxBatchSize || report.Failed != domain.MaxBatchSize ||
+		report.LastLine != domain.MaxBatchSize+1 {
+		t.Errorf("want the first batch in the report of the problem but got %+v", p)
+	}
+	if len(report.Errors) != maxImportErrors || report.Errors[0].Line != 2 {
+		t.Errorf("want the first %d errors listed but got %d", maxImportErrors, len(report.Errors))
+	}
+}
+
+func TestImportCompaniesHandlerTooLarge(t *testing.T) {
+	head := "name,code,country\nAcme,a,UA\nBeta,b,UA\n"
+	body := head + strings.Repeat("Gamma,g,UA\n", 10)
+	var created []domain.Company
+	r := mux.NewRouter()
+	InitAPI(r, importMock(t, &created, false), log.StandardLogger(), WithMaxImportSize(int64(len(head)+2)))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/companies/import", strings.NewReader(body)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want 413 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	var p importProblem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Report.Succeeded != 2 || p.Report.LastLine != 3 || len(created) != 2 {
+		t.Errorf("want the rows before the limit imported but got %+v and %+v", p.Report, created)
+	}
+}
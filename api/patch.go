@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+const (
+	contentTypeMergePatch = "application/merge-patch+json" // RFC 7396
+	contentTypeJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// jsonPatchOperation - an operation of RFC 6902, only top level fields of a company can be changed
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// parsePatch reads a merge patch or a JSON Patch by the Content-Type, plain application/json is a merge patch
+func parsePatch(r *http.Request) (*domain.CompanyPatch, error) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = contentTypeMergePatch
+	}
+	switch contentType {
+	case contentTypeMergePatch, "application/json":
+		return parseMergePatch(r)
+	case contentTypeJSONPatch:
+		return parseJSONPatch(r)
+	}
+	return nil, badRequest(fmt.Sprintf("Content-Type must be %s or %s", contentTypeMergePatch, contentTypeJSONPatch))
+}
+
+// parseMergePatch - null removes a field, it clears an optional field and fails validation of a required one
+func parseMergePatch(r *http.Request) (*domain.CompanyPatch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return nil, badRequest("merge patch must be a JSON object: " + err.Error())
+	}
+	var (
+		patch domain.CompanyPatch
+		v     domain.ValidationError
+	)
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names) // stable order of errors
+	for _, field := range names {
+		setPatchField(&patch, &v, field, fields[field])
+	}
+	if err := v.OrNil(); err != nil {
+		return nil, patchError(err)
+	}
+	return &patch, nil
+}
+
+// parseJSONPatch supports add, replace and remove of top level fields, operations are applied in order
+func parseJSONPatch(r *http.Request) (*domain.CompanyPatch, error) {
+	var operations []jsonPatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+		return nil, badRequest("JSON Patch must be an array of operations: " + err.Error())
+	}
+	var (
+		patch domain.CompanyPatch
+		v     domain.ValidationError
+	)
+	for i, op := range operations {
+		field := strings.TrimPrefix(op.Path, "/")
+		if !strings.HasPrefix(op.Path, "/") || strings.Contains(field, "/") {
+			v.Add(field, fmt.Sprintf("operation %d: path must point to a top level field", i))
+			continue
+		}
+		switch op.Op {
+		case "add", "replace":
+			if op.Value == nil {
+				v.Add(field, fmt.Sprintf("operation %d: value is required", i))
+				continue
+			}
+			setPatchField(&patch, &v, field, op.Value)
+		case "remove":
+			setPatchField(&patch, &v, field, json.RawMessage("null"))
+		default:
+			v.Add(field, fmt.Sprintf("operation %d: %q is not supported", i, op.Op))
+		}
+	}
+	if err := v.OrNil(); err != nil {
+		return nil, patchError(err)
+	}
+	return &patch, nil
+}
+
+// setPatchField sets a field to a string or removes it with null. The phone can also be the object of responses,
+// its original number (or E.164 one) is used then.
+func setPatchField(patch *domain.CompanyPatch, v *domain.ValidationError, field string, raw json.RawMessage) {
+	var value *string
+	if field == "phone" && strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		var phone domain.Phone
+		if err := json.Unmarshal(raw, &phone); err != nil || phone.IsEmpty() {
+			v.Add(field, "must be a string, a phone object with original or e164, or null")
+			return
+		}
+		value = &phone.Original
+	} else if err := json.Unmarshal(raw, &value); err != nil {
+		v.Add(field, "must be a string or null")
+		return
+	}
+	if value == nil {
+		value = new(string)
+	}
+	if !patch.Set(field, value) {
+		v.Add(field, "can't be patched, use one of "+strings.Join(domain.PatchFields, ", "))
+	}
+}
+
+// patchError reports invalid fields of a patch as a bad request
+func patchError(err error) httpError {
+	return invalidRequest("Patch is invalid", err)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+func TestPatchCompanyHandler(t *testing.T) {
+	var got *domain.CompanyPatch
+	company := &MockCompany{
+		t: t,
+		patch: func(_ context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, error) {
+			if name != "a" || code != "c" {
+				t.Errorf("want company a:c but got %s:%s", name, code)
+			}
+			got = patch
+			return domain.Company{ID: testID, Name: name, Code: code, Version: 2}, nil
+		},
+	}
+
+	req := httptest.NewRequest("PATCH", "/v1/company/a/c", strings.NewReader(`{"phone": "+380441234567", "website": null}`))
+	req.Header.Set("Content-Type", contentTypeMergePatch)
+	rr := execRequest(req, company)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"`+testID+`-2"` {
+		t.Fatalf("want 200 with the ETag of version 2 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got.Name != nil || got.Phone == nil || *got.Phone != "+380441234567" || got.Website == nil || *got.Website != "" {
+		t.Errorf("want the phone set and the website removed but got %+v", got)
+	}
+
+	req = httptest.NewRequest("PATCH", "/v1/company/a/c", strings.NewReader(
+		`[{"op": "replace", "path": "/name", "value": "b"}, {"op": "remove", "path": "/phone"}]`,
+	))
+	req.Header.Set("Content-Type", contentTypeJSONPatch)
+	if rr = execRequest(req, company); rr.Code != http.StatusOK {
+		t.Fatalf("want 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got.Name == nil || *got.Name != "b" || got.Phone == nil || *got.Phone != "" || got.Website != nil {
+		t.Errorf("want the name replaced and the phone removed but got %+v", got)
+	}
+
+	// the phone object of a response can be sent back
+	for contentType, body := range map[string]string{
+		contentTypeMergePatch: `{"phone": {"original": "044 123 45 67", "e164": "+380441234567", "national": "441234567"}}`,
+		contentTypeJSONPatch:  `[{"op": "replace", "path": "/phone", "value": {"e164": "+380441234567"}}]`,
+	} {
+		req = httptest.NewRequest("PATCH", "/v1/company/a/c", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if rr = execRequest(req, company); rr.Code != http.StatusOK {
+			t.Fatalf("%s: want 200 but got %d: %s", body, rr.Code, rr.Body.String())
+		}
+		if got.Phone == nil || (*got.Phone != "044 123 45 67" && *got.Phone != "+380441234567") {
+			t.Errorf("%s: want the phone of the object but got %+v", body, got)
+		}
+	}
+}
+
+func TestPatchCompanyHandlerInvalid(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		fields      []string
+	}{
+		{contentTypeMergePatch, `{"id": "x"}`, []string{"id"}},
+		{contentTypeMergePatch, `{"name": 1}`, []string{"name"}},
+		{contentTypeMergePatch, `{"name": {"original": "a"}, "phone": {"national": "441234567"}}`, []string{"name", "phone"}},
+		{contentTypeMergePatch, `[]`, nil},
+		{contentTypeJSONPatch, `[{"op": "move", "path": "/name"}, {"op": "add", "path": "/phone/e164", "value": "1"}]`, []string{"name", "phone/e164"}},
+		{"text/plain", `{}`, nil},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("PATCH", "/v1/company/a/c", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+		rr := execRequest(req, &MockCompany{t: t})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: want 400 but got %d", c.contentType, c.body, rr.Code)
+			continue
+		}
+		var problem struct {
+			Errors []domain.FieldError `json:"errors"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		if len(problem.Errors) != len(c.fields) {
+			t.Errorf("%s: want errors of %v but got %v", c.body, c.fields, problem.Errors)
+			continue
+		}
+		for i := range c.fields {
+			if problem.Errors[i].Field != c.fields[i] {
+				t.Errorf("%s: want error of %s but got %v", c.body, c.fields[i], problem.Errors[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+)
+
+// WithWebhooks - manage webhook subscriptions under /v1/webhooks. Webhooks stream the changes of
+// all companies, so besides the read or write scopes every request needs the manage scopes.
+// They are not served without WithAuth, no manageScopes means WebhookManageScope.
+func WithWebhooks(webhooks domain.Webhooks, manageScopes []string) Option {
+	return func(a *API) {
+		a.webhooks = webhooks
+		a.webhookScopes = manageScopes
+		if len(a.webhookScopes) == 0 {
+			a.webhookScopes = []string{WebhookManageScope}
+		}
+	}
+}
+
+func (a *API) initWebhooks(r *mux.Router) {
+	if a.verifier == nil {
+		a.l.Warnf("%s:Webhooks are not served since authentication is disabled", a.logPrefix)
+		return
+	}
+	r.Methods(http.MethodGet).Path("/v1/webhooks").HandlerFunc(a.manageWebhooks(a.listWebhooksHandler))
+	r.Methods(http.MethodPost).Path("/v1/webhooks").HandlerFunc(a.manageWebhooks(a.createWebhookHandler))
+	r.Methods(http.MethodGet).Path("/v1/webhooks/{id}").HandlerFunc(a.manageWebhooks(a.getWebhookHandler))
+	r.Methods(http.MethodPut).Path("/v1/webhooks/{id}").HandlerFunc(a.manageWebhooks(a.updateWebhookHandler))
+	r.Methods(http.MethodDelete).Path("/v1/webhooks/{id}").HandlerFunc(a.manageWebhooks(a.deleteWebhookHandler))
+	r.Methods(http.MethodGet).Path("/v1/webhooks/{id}/deliveries").HandlerFunc(a.manageWebhooks(a.getDeliveriesHandler))
+	r.Methods(http.MethodPost).Path("/v1/webhooks/{id}/deliveries:replay").HandlerFunc(a.manageWebhooks(a.replayDeliveriesHandler))
+}
+
+// manageWebhooks checks the manage scopes in the claims left by the auth middleware
+func (a *API) manageWebhooks(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(domain.CtxUserClaimsKey).(domain.Claims)
+		if claims == nil || !claims.HasScopes(a.webhookScopes...) {
+			a.l.Infof("%s:Subject %s has no scopes %v", a.logPrefix, claims.Subject(), a.webhookScopes)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="companysvc", error="insufficient_scope"`)
+			a.handleError(w, r, httpError{problem: problemForbidden, detail: "Insufficient scope"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+const WebhookManageScope = "webhooks:manage"
+
+type replayRequest struct {
+	IDs []int64 `json:"ids"` // failed deliveries to replay, all of them if it is empty
+}
+
+type replayResponse struct {
+	Replayed int64 `json:"replayed"`
+}
+
+// webhookError translates errors of webhook handlers, they are about webhooks rather than companies
+func webhookError(err error) error {
+	var validation *domain.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		return httpError{problem: problemWebhookValidation, detail: "One or more fields are invalid", errors: validation.Fields}
+	case errors.Is(err, domain.ErrNotFound):
+		return httpError{problem: problemWebhookNotFound}
+	}
+	return err
+}
+
+func newWebhookSecret() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func parseWebhook(r *http.Request) (domain.Webhook, error) {
+	var webhook domain.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		return webhook, badRequest(err.Error())
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []domain.EventType{}
+	}
+	return webhook, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *API) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := a.webhooks.ListWebhooks(r.Context())
+	if err != nil {
+		a.l.Warnf("%s:List webhooks: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+// createWebhookHandler generates the secret if it is not set, the response is the only place it is returned
+func (a *API) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, err := parseWebhook(r)
+	if err != nil {
+		a.l.Infof("%s:Parse webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = newWebhookSecret()
+	}
+	if err := webhook.Validate(); err != nil {
+		a.l.Infof("%s:Create webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	if err := a.webhooks.CreateWebhook(r.Context(), &webhook); err != nil {
+		a.l.Warnf("%s:Create webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	w.Header().Set("Location", "/v1/webhooks/"+webhook.ID)
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+func (a *API) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	webhook, err := a.webhooks.GetWebhook(r.Context(), id)
+	if err != nil {
+		a.l.Warnf("%s:Get webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	webhook.Secret = ""
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// updateWebhookHandler replaces the url and event types, the secret is rotated only if it is set
+func (a *API) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	webhook, err := parseWebhook(r)
+	if err != nil {
+		a.l.Infof("%s:Parse webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	old, err := a.webhooks.GetWebhook(r.Context(), id)
+	if err != nil {
+		a.l.Warnf("%s:Update webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	webhook.ID = old.ID
+	if webhook.Secret == "" {
+		webhook.Secret = old.Secret
+	}
+	if err := webhook.Validate(); err != nil {
+		a.l.Infof("%s:Update webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	if err := a.webhooks.UpdateWebhook(r.Context(), &webhook); err != nil {
+		a.l.Warnf("%s:Update webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	webhook.Secret = ""
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func (a *API) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if err := a.webhooks.DeleteWebhook(r.Context(), id); err != nil {
+		a.l.Warnf("%s:Delete webhook: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeliveriesHandler returns the newest deliveries of the webhook, optionally of the status only
+func (a *API) getDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	query := r.URL.Query()
+	limit, err := a.parseLimit(query)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, err)
+		return
+	}
+	status := domain.DeliveryStatus(strings.ToLower(strings.TrimSpace(query.Get("status"))))
+	switch status {
+	case "", domain.DeliveryPending, domain.DeliveryDelivered, domain.DeliveryFailed:
+	default:
+		a.l.Infof("%s:Parse query parameters: unknown status %s", a.logPrefix, status)
+		a.handleError(w, r, badRequest("status must be pending, delivered or failed"))
+		return
+	}
+	if _, err := a.webhooks.GetWebhook(r.Context(), id); err != nil {
+		a.l.Warnf("%s:Get webhook deliveries: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	deliveries, err := a.webhooks.Deliveries(r.Context(), id, status, limit)
+	if err != nil {
+		a.l.Warnf("%s:Get webhook deliveries: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// replayDeliveriesHandler makes failed deliveries pending again, the body with ids is optional
+func (a *API) replayDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.parseID(r)
+	if err != nil {
+		a.l.Infof("%s:Parse query parameters: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		a.l.Infof("%s:Parse replay request: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	if _, err := a.webhooks.GetWebhook(r.Context(), id); err != nil {
+		a.l.Warnf("%s:Replay webhook deliveries: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	n, err := a.webhooks.Replay(r.Context(), id, req.IDs...)
+	if err != nil {
+		a.l.Warnf("%s:Replay webhook deliveries: %s", a.logPrefix, err.Error())
+		a.handleError(w, r, webhookError(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, replayResponse{Replayed: n})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhooksMock keeps webhooks in memory, delivery of events is not used by the API
+type webhooksMock struct {
+	domain.Webhooks
+	webhooks map[string]domain.Webhook
+	replayed []int64
+}
+
+func (m *webhooksMock) CreateWebhook(_ context.Context, w *domain.Webhook) error {
+	w.ID = testID
+	m.webhooks[w.ID] = *w
+	return nil
+}
+
+func (m *webhooksMock) GetWebhook(_ context.Context, id string) (domain.Webhook, error) {
+	w, ok := m.webhooks[id]
+	if !ok {
+		return w, domain.ErrNotFound
+	}
+	return w, nil
+}
+
+func (m *webhooksMock) UpdateWebhook(_ context.Context, w *domain.Webhook) error {
+	m.webhooks[w.ID] = *w
+	return nil
+}
+
+func (m *webhooksMock) Replay(_ context.Context, _ string, ids ...int64) (int64, error) {
+	m.replayed = ids
+	return int64(len(ids)), nil
+}
+
+// webhookVerifier grants the company scopes to "writer" and the webhook scope to "manager" too
+var webhookVerifier = VerifierMock(func(token string) (domain.Claims, error) {
+	switch token {
+	case "writer":
+		return domain.Claims{"sub": "writer", "scope": "company:read company:write"}, nil
+	case "manager":
+		return domain.Claims{"sub": "manager", "scope": "company:read company:write webhooks:manage"}, nil
+	}
+	return nil, errors.New("invalid token")
+})
+
+func execWebhookRequest(req *http.Request, webhooks domain.Webhooks) *httptest.ResponseRecorder {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer manager")
+	}
+	r := mux.NewRouter()
+	InitAPI(r, &MockCompany{}, log.StandardLogger(),
+		WithAuth(webhookVerifier, []string{"company:read"}, []string{"company:write"}),
+		WithWebhooks(webhooks, nil))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCreateWebhookHandler(t *testing.T) {
+	webhooks := &webhooksMock{webhooks: map[string]domain.Webhook{}}
+	req := httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(`{"url":"https://partner.example/hook","eventTypes":["create"]}`))
+	rr := execWebhookRequest(req, webhooks)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want %d but got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	var created domain.Webhook
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != testID || len(created.Secret) < domain.MinWebhookSecret {
+		t.Errorf("the generated secret must be returned on create, got %+v", created)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/webhooks/"+testID, nil)
+	rr = execWebhookRequest(req, webhooks)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Secret) {
+		t.Errorf("the secret must not be returned, got %d: %s", rr.Code, rr.Body)
+	}
+
+	req = httptest.NewRequest("PUT", "/v1/webhooks/"+testID, strings.NewReader(`{"url":"https://partner.example/v2"}`))
+	if rr = execWebhookRequest(req, webhooks); rr.Code != http.StatusOK {
+		t.Fatalf("want %d but got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if w := webhooks.webhooks[testID]; w.Secret != created.Secret || w.URL != "https://partner.example/v2" {
+		t.Errorf("update without a secret must keep it, got %+v", w)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(`{"url":"ftp://partner","eventTypes":["rename"]}`))
+	rr = execWebhookRequest(req, webhooks)
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if rr.Code != http.StatusUnprocessableEntity || p.Type != problemWebhookValidation.uri() || len(p.Errors) != 2 {
+		t.Errorf("want webhook validation problem, got %d: %+v", rr.Code, p)
+	}
+}
+
+func TestWebhookNotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/webhooks/"+testID+"/deliveries", nil)
+	rr := execWebhookRequest(req, &webhooksMock{webhooks: map[string]domain.Webhook{}})
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if rr.Code != http.StatusNotFound || p.Type != problemWebhookNotFound.uri() {
+		t.Errorf("want webhook not found problem, got %d: %+v", rr.Code, p)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/webhooks", nil)
+	if rr = execRequest(req, &MockCompany{}); rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("webhooks must not be routed without WithWebhooks, got %d", rr.Code)
+	}
+}
+
+func TestWebhooksNeedManageScope(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(`{"url":"https://partner.example/hook"}`))
+	req.Header.Set("Authorization", "Bearer writer")
+	webhooks := &webhooksMock{webhooks: map[string]domain.Webhook{}}
+	if rr := execWebhookRequest(req, webhooks); rr.Code != http.StatusForbidden || len(webhooks.webhooks) != 0 {
+		t.Errorf("company writers must not manage webhooks, got %d", rr.Code)
+	}
+
+	r := mux.NewRouter()
+	InitAPI(r, &MockCompany{}, log.StandardLogger(), WithWebhooks(webhooks, nil))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(`{"url":"https://partner.example/hook"}`)))
+	if rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("webhooks must not be routed without authentication, got %d", rr.Code)
+	}
+}
+
+func TestReplayDeliveriesHandler(t *testing.T) {
+	webhooks := &webhooksMock{webhooks: map[string]domain.Webhook{testID: {ID: testID}}}
+	req := httptest.NewRequest("POST", "/v1/webhooks/"+testID+"/deliveries:replay", strings.NewReader(`{"ids":[3,5]}`))
+	rr := execWebhookRequest(req, webhooks)
+	var resp replayResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Replayed != 2 || len(webhooks.replayed) != 2 {
+		t.Errorf("want 2 replayed deliveries, got %d: %+v", rr.Code, resp)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/webhooks/"+testID+"/deliveries:replay", nil)
+	if rr = execWebhookRequest(req, webhooks); rr.Code != http.StatusOK || len(webhooks.replayed) != 0 {
+		t.Errorf("replay without a body must replay all failed deliveries, got %d", rr.Code)
+	}
+}
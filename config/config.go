@@ -7,13 +7,25 @@ type Config struct {
 	Loc      LocatorConfig  `yaml:"loc"`
 	Db       DatabaseConfig `yaml:"db"`
 	Event    QueueConfig    `yaml:"event"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Authz    AuthzConfig    `yaml:"authz"`
+	Consumer ConsumerConfig `yaml:"consumer"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
 	LogLevel string         `yaml:"logLevel"`
 }
 
 type LocatorConfig struct {
-	URL                  string   `yaml:"url"`
-	RetryAttempt         int      `yaml:"retryAttempt"`
-	AllowedCountiesCodes []string `yaml:"allowedCountiesCodes"`
+	URL                  string        `yaml:"url"`
+	RetryAttempt         int           `yaml:"retryAttempt"`
+	Timeout              time.Duration `yaml:"timeout"` // of a request to url
+	AllowedCountiesCodes []string      `yaml:"allowedCountiesCodes"`
+	// Resolvers - ipapi, mmdb and static in the order they are asked, the next one is asked if a resolver fails
+	Resolvers []string `yaml:"resolvers"`
+	MMDB      string   `yaml:"mmdb"`    // MaxMind GeoLite2 Country or City database of the mmdb resolver
+	Country   string   `yaml:"country"` // the country of every address of the static resolver
+	// CacheSize and CacheTTL - resolved countries of the most recently used addresses are cached, zero TTL disables it
+	CacheSize int           `yaml:"cacheSize"`
+	CacheTTL  time.Duration `yaml:"cacheTTL"`
 }
 
 type DatabaseConfig struct {
@@ -24,17 +36,125 @@ type DatabaseConfig struct {
 	NameDB     string `yaml:"nameDB"`
 	MaxConns   int    `yaml:"maxConns"`
 	Migrations string `yaml:"migrations"`
+	// Retention - deleted companies are removed permanently after it, zero keeps them forever
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purgeInterval"`
 }
 
 type QueueConfig struct {
+	// Driver - nats, jetstream, rest-proxy, redis, file or memory, it is nats or jetstream by the jetStream flag if empty
+	Driver        string        `yaml:"driver"`
 	URL           string        `yaml:"url"`
 	Port          int           `yaml:"port"`
 	EventChannel  string        `yaml:"eventChannel"`
 	ReconnectWait time.Duration `yaml:"reconnectWait"`
 	PingInterval  time.Duration `yaml:"pingInterval"`
+	// Outbox - events are stored in the transaction of the change and published by a relay
+	Outbox         bool          `yaml:"outbox"`
+	OutboxInterval time.Duration `yaml:"outboxInterval"`
+	// OutboxRetention - published events are removed after it, zero keeps them forever
+	OutboxRetention time.Duration `yaml:"outboxRetention"`
+	// OutboxMaxAttempts - a failed event is parked after them and not published anymore, zero retries forever
+	OutboxMaxAttempts int `yaml:"outboxMaxAttempts"`
+	// JetStream - events are published to the stream with acks and deduplication instead of core NATS
+	JetStream bool   `yaml:"jetStream"`
+	Stream    string `yaml:"stream"` // default is the upper case eventChannel
+	// AckTimeout and PublishRetries - how long to wait for a JetStream ack and how many times to retry
+	AckTimeout     time.Duration `yaml:"ackTimeout"`
+	PublishRetries int           `yaml:"publishRetries"`
+	// Source - CloudEvents source of the events, Mode - structured (JSON envelope) or binary (ce- headers)
+	Source string `yaml:"source"`
+	Mode   string `yaml:"mode"`
+
+	RESTProxy RESTProxyConfig `yaml:"restProxy"`
+	Redis     RedisConfig     `yaml:"redis"`
+	File      string          `yaml:"file"` // newline delimited JSON file of the file driver
+}
+
+// RESTProxyConfig - the rest-proxy driver produces events to Kafka through the REST proxy API v2,
+// the topic is eventChannel
+type RESTProxyConfig struct {
+	URL string `yaml:"url"`
+}
+
+// RedisConfig - the redis driver adds events to the eventChannel stream
+type RedisConfig struct {
+	URL    string `yaml:"url"`    // redis://[[user]:password@]host[:port][/db]
+	MaxLen int64  `yaml:"maxLen"` // approximate stream length, zero keeps all events
+}
+
+// ConsumerConfig - the consumer of company events which maintains the country counts projection
+type ConsumerConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Subject    string        `yaml:"subject"` // eventChannel.> with JetStream, eventChannel otherwise
+	Group      string        `yaml:"group"`   // queue group and durable JetStream consumer name
+	DeadLetter string        `yaml:"deadLetter"`
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+}
+
+// WebhooksConfig - webhook subscriptions to company events and the dispatcher of their deliveries
+type WebhooksConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // how often due deliveries are checked
+	Timeout  time.Duration `yaml:"timeout"`  // of a single delivery request
+	// MaxAttempts - a delivery is failed after them, RetryWait is doubled by every failed attempt
+	MaxAttempts int           `yaml:"maxAttempts"`
+	RetryWait   time.Duration `yaml:"retryWait"`
+	// ManageScopes - needed besides auth.writeScopes to manage webhooks, they are not served without auth
+	ManageScopes []string `yaml:"manageScopes"`
 }
 
 type ServerConfig struct {
-	URL       string `yaml:"url"`
-	PrefixAPI string `yaml:"prefixAPI"`
+	URL             string `yaml:"url"`
+	PrefixAPI       string `yaml:"prefixAPI"`
+	DefaultPageSize int    `yaml:"defaultPageSize"`
+	MaxPageSize     int    `yaml:"maxPageSize"` // hard limit of companies per page
+	// RequireIfMatch - PUT, PATCH and DELETE without If-Match are rejected with 428
+	RequireIfMatch bool `yaml:"requireIfMatch"`
+	// TrustedProxies - CIDRs or addresses of proxies whose Forwarded, X-Forwarded-For and X-Real-IP are trusted
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// MaxImportSize - the largest upload of an import in bytes, api.MaxImportSize by default
+	MaxImportSize int64 `yaml:"maxImportSize"`
+	// AdminURL - separate listener of /debug/vars which must not be reachable by clients, empty disables it
+	AdminURL string `yaml:"adminURL"`
+}
+
+type AuthConfig struct {
+	Enabled     bool            `yaml:"enabled"`
+	Issuer      string          `yaml:"issuer"`
+	Audience    string          `yaml:"audience"`
+	Leeway      time.Duration   `yaml:"leeway"`
+	Keys        []AuthKeyConfig `yaml:"keys"`
+	JWKSFile    string          `yaml:"jwksFile"`
+	ReadScopes  []string        `yaml:"readScopes"`
+	WriteScopes []string        `yaml:"writeScopes"`
+	// AllowNoExpiry - tokens without exp are accepted, they never expire
+	AllowNoExpiry bool `yaml:"allowNoExpiry"`
+}
+
+type AuthKeyConfig struct {
+	ID            string `yaml:"id"`
+	Algorithm     string `yaml:"algorithm"` // HS256 or RS256
+	Secret        string `yaml:"secret"`    // HS256 shared secret
+	PublicKeyFile string `yaml:"publicKeyFile"`
+}
+
+// AuthzConfig - authorization rules per company operation, see service.Policy
+type AuthzConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Get     []PolicyRuleConfig `yaml:"get"`
+	GetMany []PolicyRuleConfig `yaml:"getMany"`
+	Create  []PolicyRuleConfig `yaml:"create"`
+	Update  []PolicyRuleConfig `yaml:"update"`
+	Delete  []PolicyRuleConfig `yaml:"delete"`
+	Restore []PolicyRuleConfig `yaml:"restore"`
+	// IncludeDeleted - rules to list deleted companies in addition to getMany
+	IncludeDeleted []PolicyRuleConfig `yaml:"includeDeleted"`
+}
+
+type PolicyRuleConfig struct {
+	Scopes    []string `yaml:"scopes"`
+	Roles     []string `yaml:"roles"`
+	Countries []string `yaml:"countries"`
 }
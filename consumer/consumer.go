@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler processes a company event, an error retries it
+type Handler func(ctx context.Context, e *domain.CloudEvent) error
+
+// Consumer decodes company events of a subject and passes them to the handler of their type.
+// A failed event is retried with a doubling delay, after the last attempt it is published to the dead letter
+// subject (or dropped without it) and the next event is processed.
+type Consumer struct {
+	subscriber domain.Subscriber
+	subject    string
+	group      string
+	handlers   map[domain.EventType]Handler
+
+	attempts   int
+	backoff    time.Duration
+	deadLetter domain.Publisher
+	dlqSubject string
+
+	l         *log.Logger
+	logPrefix string
+
+	mu     sync.Mutex
+	sub    domain.Subscription
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option - optional behaviour of the consumer
+type Option func(*Consumer)
+
+// WithRetry makes attempts to process an event waiting backoff, 2*backoff etc. between them
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(c *Consumer) {
+		if attempts > 0 {
+			c.attempts = attempts
+		}
+		c.backoff = backoff
+	}
+}
+
+// WithDeadLetter publishes events which can't be decoded or processed to the subject
+func WithDeadLetter(publisher domain.Publisher, subject string) Option {
+	return func(c *Consumer) {
+		c.deadLetter, c.dlqSubject = publisher, subject
+	}
+}
+
+// New creates a consumer of the subject, consumers of the same group share the events
+func New(subscriber domain.Subscriber, subject, group string, l *log.Logger, opts ...Option) *Consumer {
+	c := &Consumer{
+		subscriber: subscriber,
+		subject:    subject,
+		group:      group,
+		handlers:   map[domain.EventType]Handler{},
+		attempts:   3,
+		backoff:    time.Second,
+		l:          l,
+		logPrefix:  "consumer " + group,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Handle registers the handler of the event types, events of other types are skipped
+func (c *Consumer) Handle(h Handler, types ...domain.EventType) {
+	for _, t := range types {
+		c.handlers[t] = h
+	}
+}
+
+// Start subscribes the consumer, handlers get a context which is done when the consumer is drained
+func (c *Consumer) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sub != nil {
+		return fmt.Errorf("consumer %s is already started", c.group)
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	sub, err := c.subscriber.Subscribe(c.subject, c.group, func(msg *domain.Message) error {
+		return c.process(c.ctx, msg)
+	})
+	if err != nil {
+		c.cancel()
+		return err
+	}
+	c.sub = sub
+	c.l.Infof("%s: consume %s", c.logPrefix, c.subject)
+	return nil
+}
+
+// Drain stops receiving events and waits until received events are processed. Retries of a failed event
+// are stopped, so the event is redelivered by subscribers which support it.
+func (c *Consumer) Drain() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sub == nil {
+		return nil
+	}
+	c.cancel()
+	err := c.sub.Drain()
+	c.sub = nil
+	c.l.Infof("%s: drained", c.logPrefix)
+	return err
+}
+
+// process returns an error only if the event must be redelivered
+func (c *Consumer) process(ctx context.Context, msg *domain.Message) error {
+	e, err := domain.DecodeCloudEvent(msg)
+	if err != nil {
+		return c.toDeadLetter(msg, err)
+	}
+	h, ok := c.handlers[e.EventType()]
+	if !ok {
+		return nil
+	}
+	wait := c.backoff
+	for attempt := 1; ; attempt++ {
+		if err = h(ctx, &e); err == nil {
+			return nil
+		}
+		c.l.Warnf("%s: process event %s, attempt %d: %s", c.logPrefix, e.ID, attempt, err.Error())
+		if attempt >= c.attempts {
+			return c.toDeadLetter(msg, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Consumer) toDeadLetter(msg *domain.Message, reason error) error {
+	if c.deadLetter == nil {
+		c.l.Errorf("%s: drop message %s: %s", c.logPrefix, msg.ID, reason.Error())
+		return nil
+	}
+	header := make(map[string]string, len(msg.Header)+1)
+	for k, v := range msg.Header {
+		header[k] = v
+	}
+	header["x-error"] = reason.Error()
+	dead := domain.Message{ID: msg.ID, Header: header, Data: msg.Data}
+	if err := domain.PublishMessage(c.deadLetter, c.dlqSubject, &dead); err != nil {
+		return fmt.Errorf("publish to dead letter subject %s: %w", c.dlqSubject, err)
+	}
+	c.l.Warnf("%s: message %s is moved to %s: %s", c.logPrefix, msg.ID, c.dlqSubject, reason.Error())
+	return nil
+}
@@ -0,0 +1,176 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+type subscriberMock struct {
+	subject, group string
+	handler        func(msg *domain.Message) error
+	drained        bool
+}
+
+func (s *subscriberMock) Subscribe(subj, group string, handler func(msg *domain.Message) error) (domain.Subscription, error) {
+	s.subject, s.group, s.handler = subj, group, handler
+	return s, nil
+}
+
+func (s *subscriberMock) Drain() error {
+	s.drained = true
+	return nil
+}
+
+type publisherMock struct {
+	subjects []string
+	messages []domain.Message
+}
+
+func (p *publisherMock) Publish(subj string, data []byte) error {
+	return p.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (p *publisherMock) PublishMessage(subj string, msg *domain.Message) error {
+	p.subjects = append(p.subjects, subj)
+	p.messages = append(p.messages, *msg)
+	return nil
+}
+
+type countsMock struct {
+	applied map[string]map[string]int64
+}
+
+func (c *countsMock) Apply(_ context.Context, eventID string, deltas map[string]int64) (bool, error) {
+	if _, ok := c.applied[eventID]; ok {
+		return false, nil
+	}
+	c.applied[eventID] = deltas
+	return true, nil
+}
+
+func (c *countsMock) Counts(context.Context) (map[string]int64, error) {
+	counts := map[string]int64{}
+	for _, deltas := range c.applied {
+		for code, delta := range deltas {
+			counts[code] += delta
+		}
+	}
+	return counts, nil
+}
+
+func encode(t *testing.T, op domain.EventType, before, after *domain.Company, binary bool) *domain.Message {
+	e := domain.NewEvent(context.Background(), op, before, after)
+	msg, err := domain.EventFormat{Binary: binary}.Encode(&e)
+	if err != nil {
+		t.Fatalf("encode event: %s", err)
+	}
+	return msg
+}
+
+func TestConsumerRetriesAndDeadLetters(t *testing.T) {
+	sub := &subscriberMock{}
+	dlq := &publisherMock{}
+	c := New(sub, "companies.>", "test", log.StandardLogger(), WithRetry(3, time.Millisecond), WithDeadLetter(dlq, "companies-dlq"))
+	calls := 0
+	c.Handle(func(_ context.Context, e *domain.CloudEvent) error {
+		calls++
+		if e.Data.After.Name == "broken" || calls < 2 {
+			return errors.New("try again")
+		}
+		return nil
+	}, domain.CreateCompany)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if sub.subject != "companies.>" || sub.group != "test" {
+		t.Errorf("want subscription of test to companies.> but got %s of %s", sub.subject, sub.group)
+	}
+
+	company := &domain.Company{ID: "1", Name: "ok", CountryCode: "UA"}
+	if err := sub.handler(encode(t, domain.CreateCompany, nil, company, false)); err != nil || calls != 2 {
+		t.Errorf("want the event processed by the second attempt but got %v after %d calls", err, calls)
+	}
+	if err := sub.handler(encode(t, domain.DeleteCompany, company, nil, false)); err != nil || calls != 2 {
+		t.Errorf("events without handler should be skipped but got %v", err)
+	}
+
+	calls = 0
+	broken := encode(t, domain.CreateCompany, nil, &domain.Company{ID: "2", Name: "broken"}, true)
+	if err := sub.handler(broken); err != nil || calls != 3 {
+		t.Errorf("want the event moved to dead letters after 3 attempts but got %v after %d calls", err, calls)
+	}
+	if err := sub.handler(&domain.Message{Data: []byte("not json")}); err != nil {
+		t.Errorf("a message which can't be decoded should be moved to dead letters but got %v", err)
+	}
+	if !reflect.DeepEqual(dlq.subjects, []string{"companies-dlq", "companies-dlq"}) ||
+		!reflect.DeepEqual(dlq.messages[0].Data, broken.Data) || dlq.messages[0].Header["ce-id"] != broken.Header["ce-id"] ||
+		dlq.messages[0].Header["x-error"] != "try again" {
+		t.Errorf("unexpected dead letters %+v", dlq.messages)
+	}
+
+	if err := c.Drain(); err != nil || !sub.drained {
+		t.Errorf("want the subscription drained but got %v", err)
+	}
+}
+
+func TestConsumerStopsRetriesWhenDrained(t *testing.T) {
+	sub := &subscriberMock{}
+	c := New(sub, "companies", "test", log.StandardLogger(), WithRetry(10, time.Hour))
+	c.Handle(func(context.Context, *domain.CloudEvent) error {
+		return errors.New("try again")
+	}, domain.CreateCompany)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- sub.handler(encode(t, domain.CreateCompany, nil, &domain.Company{ID: "1"}, false))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Drain()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want the event returned for redelivery but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retries should stop when the consumer is drained")
+	}
+}
+
+func TestCountryCounts(t *testing.T) {
+	sub := &subscriberMock{}
+	store := &countsMock{applied: map[string]map[string]int64{}}
+	c := New(sub, "companies", "country-counts", log.StandardLogger())
+	CountryCounts(c, store)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+
+	ua := &domain.Company{ID: "1", CountryCode: "UA"}
+	cy := &domain.Company{ID: "1", CountryCode: "CY"}
+	created := encode(t, domain.CreateCompany, nil, ua, false)
+	for _, msg := range []*domain.Message{
+		created,
+		encode(t, domain.CreateCompany, nil, &domain.Company{ID: "2", CountryCode: "UA"}, true),
+		encode(t, domain.UpdateCompany, ua, cy, false),
+		encode(t, domain.UpdateCompany, cy, cy, false), // the country is not changed
+		encode(t, domain.DeleteCompany, cy, nil, false),
+		encode(t, domain.RestoreCompany, nil, cy, false),
+		created, // redelivered
+	} {
+		if err := sub.handler(msg); err != nil {
+			t.Fatalf("error was not expected: %s", err)
+		}
+	}
+	counts, _ := store.Counts(context.Background())
+	if !reflect.DeepEqual(counts, map[string]int64{"UA": 1, "CY": 1}) {
+		t.Errorf("want a company in UA and CY but got %v", counts)
+	}
+}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"context"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// CountryCounts registers the projection of the number of active companies per country in the store
+func CountryCounts(c *Consumer, store domain.CountryCounts) {
+	c.Handle(func(ctx context.Context, e *domain.CloudEvent) error {
+		deltas := CountryDeltas(e)
+		if len(deltas) == 0 {
+			return nil
+		}
+		_, err := store.Apply(ctx, e.ID, deltas)
+		return err
+	}, domain.CreateCompany, domain.UpdateCompany, domain.DeleteCompany, domain.RestoreCompany)
+}
+
+// CountryDeltas returns changes of the company counts per country code by the event,
+// the company is counted out of the country before the change and into the country after it
+func CountryDeltas(e *domain.CloudEvent) map[string]int64 {
+	deltas := map[string]int64{}
+	if before := e.Data.Before; before != nil && before.CountryCode != "" {
+		deltas[before.CountryCode]--
+	}
+	if after := e.Data.After; after != nil && after.CountryCode != "" {
+		deltas[after.CountryCode]++
+	}
+	for code, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, code)
+		}
+	}
+	return deltas
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// withTx runs fn in a transaction which is committed if fn succeeds
+func (c *companyPostgreRepo) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := c.storage.BeginTx(ctx, nil)
+	if err != nil {
+		return storageError(err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return storageError(tx.Commit())
+}
+
+// auditContext returns who makes the change, the actor is anonymous when authentication is disabled
+func auditContext(ctx context.Context) (actor, ip, requestID string) {
+	actor, _ = ctx.Value(domain.CtxUserSubjectKey).(string)
+	if actor == "" {
+		actor = "anonymous"
+	}
+	if addr, ok := ctx.Value(domain.CtxUserIPKey).(netip.Addr); ok && addr.IsValid() {
+		ip = addr.String()
+	}
+	requestID, _ = ctx.Value(domain.CtxRequestIDKey).(string)
+	return actor, ip, requestID
+}
+
+func auditSnapshot(company *domain.Company) (any, error) {
+	if company == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(company)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// recordChange appends a revision to company_audit and, with WithOutbox, the event of the change to outbox.
+// It must be called in the transaction of the change.
+func (c *companyPostgreRepo) recordChange(ctx context.Context, tx *sql.Tx, op domain.EventType, before, after *domain.Company) error {
+	id := ""
+	if after != nil {
+		id = after.ID
+	} else if before != nil {
+		id = before.ID
+	}
+	beforeData, err := auditSnapshot(before)
+	if err != nil {
+		return fmt.Errorf("marshal company snapshot: %w", err)
+	}
+	afterData, err := auditSnapshot(after)
+	if err != nil {
+		return fmt.Errorf("marshal company snapshot: %w", err)
+	}
+	actor, ip, requestID := auditContext(ctx)
+	query := "INSERT INTO company_audit (company_id, operation, actor, ip, request_id, before, after) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	if _, err := tx.ExecContext(ctx, query, id, string(op), actor, ip, requestID, beforeData, afterData); err != nil {
+		return fmt.Errorf("record company change: %w", storageError(err))
+	}
+	if !c.outbox {
+		return nil
+	}
+	payload, err := json.Marshal(domain.NewEvent(ctx, op, before, after))
+	if err != nil {
+		return fmt.Errorf("marshal company event: %w", err)
+	}
+	query = "INSERT INTO outbox (event_type, company_id, payload) VALUES ($1, $2, $3)"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	if _, err := tx.ExecContext(ctx, query, string(op), id, payload); err != nil {
+		return fmt.Errorf("write company event to outbox: %w", storageError(err))
+	}
+	return nil
+}
+
+// snapshotCompany - a company in company_audit, the phone is restored from its stored forms
+type snapshotCompany struct {
+	domain.Company
+	Phone struct {
+		Original string `json:"original"`
+		E164     string `json:"e164"`
+	} `json:"phone"`
+}
+
+func restoreSnapshot(data []byte) (*domain.Company, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var s snapshotCompany
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	s.Company.Phone = domain.RestorePhone(s.Phone.Original, s.Phone.E164, s.CountryCode)
+	return &s.Company, nil
+}
+
+// History finds the company by its current name and code, then the last deleted one,
+// then the last revision with them if it is purged
+func (c *companyPostgreRepo) History(ctx context.Context, name, code string) ([]domain.Revision, error) {
+	query := "SELECT id, company_id, operation, actor, ip, request_id, before, after, created_at FROM company_audit " +
+		"WHERE company_id = COALESCE(" +
+		"(SELECT id FROM companies WHERE name=$1 AND code=$2 ORDER BY deleted_at DESC NULLS FIRST LIMIT 1), " +
+		"(SELECT company_id FROM company_audit WHERE (after->>'name' = $1 AND after->>'code' = $2) " +
+		"OR (before->>'name' = $1 AND before->>'code' = $2) ORDER BY id DESC LIMIT 1)) " +
+		"ORDER BY id DESC"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query, name, code)
+	if err != nil {
+		return nil, fmt.Errorf("get company history: %w", storageError(err))
+	}
+	defer rows.Close()
+	revisions := make([]domain.Revision, 0)
+	for rows.Next() {
+		var r domain.Revision
+		var before, after []byte
+		if err := rows.Scan(&r.ID, &r.CompanyID, &r.Operation, &r.Actor, &r.IP, &r.RequestID, &before, &after, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan company revision: %w", storageError(err))
+		}
+		if r.Before, err = restoreSnapshot(before); err != nil {
+			return nil, fmt.Errorf("unmarshal revision %d: %w", r.ID, err)
+		}
+		if r.After, err = restoreSnapshot(after); err != nil {
+			return nil, fmt.Errorf("unmarshal revision %d: %w", r.ID, err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get company history: %w", storageError(err))
+	}
+	if len(revisions) == 0 {
+		// companies created before the audit was introduced have no revisions
+		if _, err := c.Get(ctx, name, code); err != nil {
+			return nil, err
+		}
+	}
+	return revisions, nil
+}
@@ -3,127 +3,670 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"strings"
+	"time"
 )
 
-func buildPGRequest(start int, options *domain.FilterOptions) (string, []any) {
-	if options == nil || (len(options.Params) == 0 && options.Limit == nil) {
-		return "true", []any{}
+const pqUniqueViolation = "23505"
+
+// storageError converts known storage errors into domain errors and keeps the original message
+func storageError(err error) error {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return domain.ErrNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation:
+		return fmt.Errorf("%w: %s", domain.ErrAlreadyExists, err.Error())
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %s", domain.ErrUnavailable, err.Error())
 	}
-	var limit string
-	q := make([]string, 0, len(options.Params))
-	values := make([]any, 0, len(options.Params))
-	for k := range options.Params {
-		start++
-		q = append(q, fmt.Sprintf("%s ILIKE $%d", k, start))
-		values = append(values, "%"+options.Params[k]+"%")
+	return err
+}
+
+// checkAffected returns domain.ErrNotFound if the statement did not change any row
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// sortColumns - whitelist of sortable fields and their SQL expressions
+var sortColumns = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"code":        "code",
+	"country":     "COALESCE(country, '')",
+	"countryCode": "COALESCE(country_code, '')",
+	"website":     "COALESCE(website, '')",
+	"phone":       "COALESCE(phone_e164, '')",
+}
+
+// buildPGKeyset builds the condition "row is after the cursor" for any mix of sort directions:
+// (a > x) OR (a = x AND b < y) OR (a = x AND b = y AND id > z)
+func buildPGKeyset(start int, sort []domain.SortField, after *domain.Cursor) (string, []any) {
+	columns := make([]string, 0, len(sort)+1)
+	ops := make([]string, 0, len(sort)+1)
+	values := make([]any, 0, len(sort)+1)
+	for i, f := range sort {
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+		columns = append(columns, sortColumns[f.Field])
+		ops = append(ops, op)
+		values = append(values, after.Values[i])
 	}
+	columns = append(columns, "id")
+	ops = append(ops, ">")
+	values = append(values, after.ID)
+
+	or := make([]string, 0, len(columns))
+	for i := range columns {
+		and := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, fmt.Sprintf("%s = $%d", columns[j], start+j+1))
+		}
+		and = append(and, fmt.Sprintf("%s %s $%d", columns[i], ops[i], start+i+1))
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return "(" + strings.Join(or, " OR ") + ")", values
+}
+
+// buildPGRequest builds the filter, keyset, order and limit part of a query starting from WHERE
+func buildPGRequest(start int, options *domain.FilterOptions) (string, []any, error) {
+	where, values, err := buildPGWhere(start, options)
+	if err != nil {
+		return "", nil, err
+	}
+	if options == nil {
+		return where + " ORDER BY id", values, nil
+	}
+	start += len(values)
+	for _, f := range options.Sort {
+		if _, ok := sortColumns[f.Field]; !ok {
+			// fields are validated by domain.ParseSort, never build SQL from unknown input
+			return "", nil, fmt.Errorf("%w: field %q can not be sorted", domain.ErrValidation, f.Field)
+		}
+	}
+	if options.After != nil {
+		keyset, keysetValues := buildPGKeyset(start, options.Sort, options.After)
+		where += " AND " + keyset
+		values = append(values, keysetValues...)
+		start += len(keysetValues)
+	}
+	order := make([]string, 0, len(options.Sort)+1)
+	for _, f := range options.Sort {
+		if f.Desc {
+			order = append(order, sortColumns[f.Field]+" DESC")
+		} else {
+			order = append(order, sortColumns[f.Field])
+		}
+	}
+	order = append(order, "id")
+	query := where + " ORDER BY " + strings.Join(order, ", ")
 	if options.Limit != nil {
 		start++
-		limit = fmt.Sprintf(" LIMIT $%d", start)
-		values = append(values, *options.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", start)
+		values = append(values, *options.Limit+1) // one more row tells whether there is a next page
 	}
-	return strings.Join(q, " AND ") + limit, values
+	return query, values, nil
+}
+
+const companyColumns = "id, name, code, country, COALESCE(country_code, ''), website, phone, COALESCE(phone_e164, ''), deleted_at, version"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanCompany scans companyColumns and then extra columns of the query
+func scanCompany(row rowScanner, extra ...any) (domain.Company, error) {
+	var company domain.Company
+	var phone, phoneE164 string
+	dest := append([]any{
+		&company.ID,
+		&company.Name,
+		&company.Code,
+		&company.Country,
+		&company.CountryCode,
+		&company.Website,
+		&phone,
+		&phoneE164,
+		&company.DeletedAt,
+		&company.Version,
+	}, extra...)
+	err := row.Scan(dest...)
+	company.Phone = domain.RestorePhone(phone, phoneE164, company.CountryCode)
+	return company, err
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 type companyPostgreRepo struct {
 	storage   *sql.DB
 	l         *log.Logger
 	logPrefix string
+	outbox    bool
 }
 
-func NewCompanyPostgresRepo(storage *sql.DB, l *log.Logger) domain.ICompany {
-	return &companyPostgreRepo{storage: storage, l: l, logPrefix: "Repository"}
+// Option - optional behaviour of the company repository
+type Option func(*companyPostgreRepo)
+
+// WithOutbox writes an event of every change to the outbox table in the same transaction, see NewOutboxPostgresRepo
+func WithOutbox() Option {
+	return func(c *companyPostgreRepo) {
+		c.outbox = true
+	}
+}
+
+func NewCompanyPostgresRepo(storage *sql.DB, l *log.Logger, opts ...Option) domain.ICompany {
+	c := &companyPostgreRepo{storage: storage, l: l, logPrefix: "Repository"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func (c *companyPostgreRepo) Get(ctx context.Context, name, code string) (domain.Company, error) {
-	query := "SELECT name, code, country, website, phone FROM companies WHERE name=$1 AND code=$2"
+	query := "SELECT " + companyColumns + " FROM companies WHERE name=$1 AND code=$2 AND deleted_at IS NULL"
 	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
-	var company domain.Company
-	row := c.storage.QueryRowContext(ctx, query, name, code)
-	err := row.Scan(
-		&company.Name,
-		&company.Code,
-		&company.Country,
-		&company.Website,
-		&company.Phone,
-	)
+	company, err := scanCompany(c.storage.QueryRowContext(ctx, query, name, code))
 	if err != nil {
-		return company, fmt.Errorf("get company from storage %w", err)
+		return company, fmt.Errorf("get company from storage: %w", storageError(err))
 	}
 	return company, nil
 }
 
-func (c *companyPostgreRepo) GetMany(ctx context.Context, options *domain.FilterOptions) ([]domain.Company, error) {
-	whereStmt, values := buildPGRequest(0, options)
-	query := fmt.Sprintf("SELECT name, code, country, website, phone FROM companies WHERE %s", whereStmt)
+func (c *companyPostgreRepo) GetByID(ctx context.Context, id string) (domain.Company, error) {
+	query := "SELECT " + companyColumns + " FROM companies WHERE id=$1 AND deleted_at IS NULL"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	company, err := scanCompany(c.storage.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return company, fmt.Errorf("get company %s from storage: %w", id, storageError(err))
+	}
+	return company, nil
+}
+
+func (c *companyPostgreRepo) GetMany(ctx context.Context, options *domain.FilterOptions) (domain.CompanyPage, error) {
+	var page domain.CompanyPage
+	whereStmt, values, err := buildPGRequest(0, options)
+	if err != nil {
+		return page, fmt.Errorf("get list of companies: %w", err)
+	}
+	query := fmt.Sprintf("SELECT %s FROM companies WHERE %s", companyColumns, whereStmt)
 	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
 	rows, err := c.storage.QueryContext(ctx, query, values...)
 	if err != nil {
-		return nil, fmt.Errorf("get list of companies %w", err)
+		return page, fmt.Errorf("get list of companies: %w", storageError(err))
 	}
 	defer rows.Close()
-	var companies []domain.Company
+	page.Items = make([]domain.Company, 0)
 	for rows.Next() {
-		var company domain.Company
-		if err = rows.Scan(
-			&company.Name,
-			&company.Code,
-			&company.Country,
-			&company.Website,
-			&company.Phone,
-		); err == nil {
-			companies = append(companies, company)
+		if company, err := scanCompany(rows); err == nil {
+			page.Items = append(page.Items, company)
+		}
+	}
+	if options != nil && options.Limit != nil && len(page.Items) > *options.Limit {
+		page.Items = page.Items[:*options.Limit]
+		page.NextCursor = domain.NewCursor(options.Sort, &page.Items[len(page.Items)-1]).Encode()
+	}
+	if options != nil && options.WithTotal {
+		whereStmt, values, _ := buildPGWhere(0, options) // compiled above already
+		query := "SELECT COUNT(*) FROM companies WHERE " + whereStmt
+		c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+		var total int
+		if err := c.storage.QueryRowContext(ctx, query, values...).Scan(&total); err != nil {
+			return page, fmt.Errorf("count companies: %w", storageError(err))
 		}
+		page.Total = &total
 	}
-	return companies, nil
+	return page, nil
+}
+
+func (c *companyPostgreRepo) Export(ctx context.Context, options *domain.FilterOptions, fn func(*domain.Company) error) error {
+	all := domain.FilterOptions{}
+	if options != nil {
+		all = *options
+	}
+	all.Limit, all.After, all.WithTotal = nil, nil, false
+	whereStmt, values, err := buildPGRequest(0, &all)
+	if err != nil {
+		return fmt.Errorf("export companies: %w", err)
+	}
+	query := fmt.Sprintf("SELECT %s FROM companies WHERE %s", companyColumns, whereStmt)
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query, values...)
+	if err != nil {
+		return fmt.Errorf("export companies: %w", storageError(err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		company, err := scanCompany(rows)
+		if err != nil {
+			return fmt.Errorf("export companies: %w", storageError(err))
+		}
+		if err := fn(&company); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("export companies: %w", storageError(err))
+	}
+	return nil
+}
+
+// buildPGTSQuery builds a tsquery matching any of terms as a word prefix, terms contain only letters and digits
+func buildPGTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i := range terms {
+		parts[i] = terms[i] + ":*"
+	}
+	return strings.Join(parts, " | ")
+}
+
+// Search ranks companies by full text match of the search_vector column and trigram similarity of search_text,
+// see migrations/000006_add_search
+func (c *companyPostgreRepo) Search(ctx context.Context, options *domain.SearchOptions) ([]domain.SearchResult, error) {
+	terms := domain.SearchTerms(options.Query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: search query must contain letters or digits", domain.ErrValidation)
+	}
+	where, values, err := buildPGWhere(2, &domain.FilterOptions{Where: options.Where})
+	if err != nil {
+		return nil, fmt.Errorf("search companies: %w", err)
+	}
+	query := fmt.Sprintf("SELECT %s, "+
+		"(ts_rank_cd(search_vector, to_tsquery('simple', $1), 32) + word_similarity($2, search_text)) / 2 AS score "+
+		"FROM companies WHERE (search_vector @@ to_tsquery('simple', $1) OR $2 <%% search_text) AND %s "+
+		"ORDER BY score DESC, id LIMIT $%d", companyColumns, where, len(values)+3)
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	args := append([]any{buildPGTSQuery(terms), strings.Join(terms, " ")}, values...)
+	rows, err := c.storage.QueryContext(ctx, query, append(args, options.Limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("search companies: %w", storageError(err))
+	}
+	defer rows.Close()
+	results := make([]domain.SearchResult, 0)
+	for rows.Next() {
+		var result domain.SearchResult
+		if result.Company, err = scanCompany(rows, &result.Score); err != nil {
+			return nil, fmt.Errorf("search companies: %w", storageError(err))
+		}
+		result.Highlights = domain.HighlightCompany(&result.Company, terms)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search companies: %w", storageError(err))
+	}
+	return results, nil
+}
+
+// getForUpdate locks the company row until the end of the transaction, deleted selects the last deleted company
+func (c *companyPostgreRepo) getForUpdate(ctx context.Context, tx *sql.Tx, name, code string, deleted bool) (domain.Company, error) {
+	query := "SELECT " + companyColumns + " FROM companies WHERE name=$1 AND code=$2 AND deleted_at IS NULL FOR UPDATE"
+	if deleted {
+		query = "SELECT " + companyColumns + " FROM companies WHERE name=$1 AND code=$2 AND deleted_at IS NOT NULL " +
+			"ORDER BY deleted_at DESC LIMIT 1 FOR UPDATE"
+	}
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	company, err := scanCompany(tx.QueryRowContext(ctx, query, name, code))
+	if err != nil {
+		return company, storageError(err)
+	}
+	return company, nil
+}
+
+// getByIDForUpdate locks the active company with the id until the end of the transaction
+func (c *companyPostgreRepo) getByIDForUpdate(ctx context.Context, tx *sql.Tx, id string) (domain.Company, error) {
+	query := "SELECT " + companyColumns + " FROM companies WHERE id=$1 AND deleted_at IS NULL FOR UPDATE"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	company, err := scanCompany(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return company, storageError(err)
+	}
+	return company, nil
+}
+
+// checkVersion fails if the caller expects other versions of the company, see domain.CtxExpectedVersionKey
+func checkVersion(ctx context.Context, company *domain.Company) error {
+	expected, ok := ctx.Value(domain.CtxExpectedVersionKey).(domain.ExpectedVersions)
+	if ok && !expected.Allows(company.ID, company.Version) {
+		return fmt.Errorf("%w: current version is %d", domain.ErrPreconditionFailed, company.Version)
+	}
+	return nil
 }
 
 func (c *companyPostgreRepo) Create(ctx context.Context, company *domain.Company) error {
-	query := "INSERT INTO companies (name, code, country, website, phone) VALUES ($1, $2, $3, $4, $5)"
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		return c.create(ctx, tx, company)
+	})
+	if err != nil {
+		return fmt.Errorf("create company in storage: %w", err)
+	}
+	return nil
+}
+
+func (c *companyPostgreRepo) create(ctx context.Context, tx *sql.Tx, company *domain.Company) error {
+	query := "INSERT INTO companies (id, name, code, country, country_code, website, phone, phone_e164) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	// the id is never taken from the caller, a chosen one could collide with a deleted or purged company
+	company.ID = domain.NewID()
+	company.Version = 1
 	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
-	_, err := c.storage.ExecContext(ctx,
+	_, err := tx.ExecContext(ctx,
 		query,
+		company.ID,
 		company.Name,
 		company.Code,
 		company.Country,
+		company.CountryCode,
 		company.Website,
-		company.Phone,
+		company.Phone.Original,
+		nullString(company.Phone.E164),
 	)
 	if err != nil {
-		return fmt.Errorf("create company in storage: %w", err)
+		return storageError(err)
 	}
-	return nil
+	return c.recordChange(ctx, tx, domain.CreateCompany, nil, company)
+}
+
+func (c *companyPostgreRepo) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) (domain.Company, error) {
+	var before domain.Company
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if before, err = c.getForUpdate(ctx, tx, oldName, oldCode, false); err != nil {
+			return err
+		}
+		return c.update(ctx, tx, &before, company)
+	})
+	if err != nil {
+		return before, fmt.Errorf("update company in storage: %w", err)
+	}
+	return before, nil
 }
 
-func (c *companyPostgreRepo) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) error {
-	query := "UPDATE companies SET name=$1, code=$2, country=$3, website=$4, phone=$5 WHERE name=$6 and code=$7"
+// UpdateByID updates the company locked by its id, so a concurrent rename can't redirect the update to other company
+func (c *companyPostgreRepo) UpdateByID(ctx context.Context, id string, company *domain.Company) (domain.Company, error) {
+	var before domain.Company
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if before, err = c.getByIDForUpdate(ctx, tx, id); err != nil {
+			return err
+		}
+		return c.update(ctx, tx, &before, company)
+	})
+	if err != nil {
+		return before, fmt.Errorf("update company %s in storage: %w", id, err)
+	}
+	return before, nil
+}
+
+// update replaces the company locked in the transaction
+func (c *companyPostgreRepo) update(ctx context.Context, tx *sql.Tx, before, company *domain.Company) error {
+	query := "UPDATE companies SET name=$1, code=$2, country=$3, country_code=$4, website=$5, phone=$6, phone_e164=$7, " +
+		"version=version+1 WHERE id=$8 RETURNING version"
+	if err := checkVersion(ctx, before); err != nil {
+		return err
+	}
+	company.ID = before.ID
 	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
-	_, err := c.storage.ExecContext(ctx,
+	err := tx.QueryRowContext(ctx,
 		query,
 		company.Name,
 		company.Code,
 		company.Country,
+		company.CountryCode,
 		company.Website,
-		company.Phone,
-		oldName,
-		oldCode,
-	)
+		company.Phone.Original,
+		nullString(company.Phone.E164),
+		company.ID,
+	).Scan(&company.Version)
 	if err != nil {
-		return fmt.Errorf("update company in storage: %w", err)
+		return storageError(err)
 	}
-	return nil
+	return c.recordChange(ctx, tx, domain.UpdateCompany, before, company)
 }
 
-func (c *companyPostgreRepo) Delete(ctx context.Context, name, code string) error {
-	query := "DELETE FROM companies WHERE name=$1 and code=$2 LIMIT 1"
+// Patch updates only the columns of the changed fields, a patch without changes keeps the version
+func (c *companyPostgreRepo) Patch(ctx context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	var before, company domain.Company
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if before, err = c.getForUpdate(ctx, tx, name, code, false); err != nil {
+			return err
+		}
+		company, err = c.patch(ctx, tx, &before, patch)
+		return err
+	})
+	if err != nil {
+		return before, company, fmt.Errorf("patch company in storage: %w", err)
+	}
+	return before, company, nil
+}
+
+// PatchByID patches the company locked by its id
+func (c *companyPostgreRepo) PatchByID(ctx context.Context, id string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	var before, company domain.Company
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if before, err = c.getByIDForUpdate(ctx, tx, id); err != nil {
+			return err
+		}
+		company, err = c.patch(ctx, tx, &before, patch)
+		return err
+	})
+	if err != nil {
+		return before, company, fmt.Errorf("patch company %s in storage: %w", id, err)
+	}
+	return before, company, nil
+}
+
+// patch applies the patch to the company locked in the transaction and returns the patched company
+func (c *companyPostgreRepo) patch(ctx context.Context, tx *sql.Tx, before *domain.Company, patch *domain.CompanyPatch) (domain.Company, error) {
+	if err := checkVersion(ctx, before); err != nil {
+		return *before, err
+	}
+	company := *before
+	if err := patch.Apply(&company); err != nil {
+		return company, err
+	}
+	changed := domain.ChangedFields(before, &company)
+	if len(changed) == 0 {
+		return company, nil
+	}
+	set := make([]string, 0, len(changed)+2)
+	args := make([]any, 0, len(changed)+2)
+	column := func(col string, value any) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s=$%d", col, len(args)))
+	}
+	for _, field := range changed {
+		switch field {
+		case "name":
+			column("name", company.Name)
+		case "code":
+			column("code", company.Code)
+		case "country":
+			column("country", company.Country)
+		case "countryCode":
+			column("country_code", company.CountryCode)
+		case "website":
+			column("website", company.Website)
+		case "phone":
+			column("phone", company.Phone.Original)
+			column("phone_e164", nullString(company.Phone.E164))
+		}
+	}
+	args = append(args, company.ID)
+	query := fmt.Sprintf("UPDATE companies SET %s, version=version+1 WHERE id=$%d RETURNING version",
+		strings.Join(set, ", "), len(args))
 	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
-	_, err := c.storage.ExecContext(ctx, query, name, code)
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&company.Version); err != nil {
+		return company, storageError(err)
+	}
+	return company, c.recordChange(ctx, tx, domain.UpdateCompany, before, &company)
+}
+
+// Delete marks the company deleted, it is removed permanently by Purge
+func (c *companyPostgreRepo) Delete(ctx context.Context, name, code string) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		before, err := c.getForUpdate(ctx, tx, name, code, false)
+		if err != nil {
+			return err
+		}
+		return c.delete(ctx, tx, &before)
+	})
+	if err != nil {
+		return fmt.Errorf("delete company from storage: %w", err)
+	}
+	return nil
+}
+
+// DeleteByID marks the company with the id deleted
+func (c *companyPostgreRepo) DeleteByID(ctx context.Context, id string) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		before, err := c.getByIDForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return c.delete(ctx, tx, &before)
+	})
 	if err != nil {
-		return fmt.Errorf("delete company vrom storage: %w", err)
+		return fmt.Errorf("delete company %s from storage: %w", id, err)
 	}
 	return nil
 }
+
+// delete marks the company locked in the transaction deleted
+func (c *companyPostgreRepo) delete(ctx context.Context, tx *sql.Tx, before *domain.Company) error {
+	query := "UPDATE companies SET deleted_at=now(), version=version+1 WHERE id=$1"
+	if err := checkVersion(ctx, before); err != nil {
+		return err
+	}
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := tx.ExecContext(ctx, query, before.ID)
+	if err == nil {
+		err = checkAffected(res)
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	return c.recordChange(ctx, tx, domain.DeleteCompany, before, nil)
+}
+
+// Batch applies all operations in one transaction, with ContinueOnError every operation has its own one
+func (c *companyPostgreRepo) Batch(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+	results := make([]domain.BatchResult, len(ops))
+	if options.ContinueOnError {
+		for i := range ops {
+			results[i].Err = c.withTx(ctx, func(tx *sql.Tx) error {
+				var err error
+				results[i].Company, results[i].Before, err = c.apply(ops[i].Context(ctx), tx, &ops[i])
+				return err
+			})
+		}
+		return results, nil
+	}
+	failed := -1
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		for i := range ops {
+			company, before, err := c.apply(ops[i].Context(ctx), tx, &ops[i])
+			if err != nil {
+				failed = i
+				return err
+			}
+			results[i].Company, results[i].Before = company, before
+		}
+		return nil
+	})
+	if err == nil {
+		return results, nil
+	}
+	if failed < 0 {
+		return nil, fmt.Errorf("apply batch in storage: %w", err)
+	}
+	for i := range results {
+		results[i] = domain.BatchResult{Err: domain.ErrRolledBack}
+	}
+	results[failed].Err = err
+	return results, nil
+}
+
+// apply returns the company of the batch result and the company locked before an update or a delete
+func (c *companyPostgreRepo) apply(ctx context.Context, tx *sql.Tx, op *domain.BatchOperation) (domain.Company, domain.Company, error) {
+	switch op.Action {
+	case domain.BatchCreate:
+		company := *op.Company
+		return company, domain.Company{}, c.create(ctx, tx, &company)
+	case domain.BatchUpdate:
+		company := *op.Company
+		before, err := c.getForUpdate(ctx, tx, op.Name, op.Code, false)
+		if err != nil {
+			return company, before, err
+		}
+		return company, before, c.update(ctx, tx, &before, &company)
+	case domain.BatchDelete:
+		before, err := c.getForUpdate(ctx, tx, op.Name, op.Code, false)
+		if err != nil {
+			return before, before, err
+		}
+		return before, before, c.delete(ctx, tx, &before)
+	}
+	return domain.Company{}, domain.Company{}, fmt.Errorf("%w: unknown batch action %q", domain.ErrValidation, op.Action)
+}
+
+// Restore fails with domain.ErrAlreadyExists if a company with the same name and code was created after the delete
+func (c *companyPostgreRepo) Restore(ctx context.Context, name, code string) (domain.Company, error) {
+	var company domain.Company
+	query := "UPDATE companies SET deleted_at=NULL, version=version+1 WHERE id=$1 RETURNING version"
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		before, err := c.getForUpdate(ctx, tx, name, code, true)
+		if err != nil {
+			return err
+		}
+		c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+		company = before
+		company.DeletedAt = nil
+		if err := tx.QueryRowContext(ctx, query, before.ID).Scan(&company.Version); err != nil {
+			return storageError(err)
+		}
+		return c.recordChange(ctx, tx, domain.RestoreCompany, &before, &company)
+	})
+	if err != nil {
+		return company, fmt.Errorf("restore company in storage: %w", err)
+	}
+	return company, nil
+}
+
+// NewCompanyPostgresPurger removes soft deleted companies of the repository
+func NewCompanyPostgresPurger(storage *sql.DB, l *log.Logger) domain.Purger {
+	return &companyPostgreRepo{storage: storage, l: l, logPrefix: "Repository"}
+}
+
+// Purge removes deleted companies and records it in their history
+func (c *companyPostgreRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := "WITH purged AS (DELETE FROM companies WHERE deleted_at < $1 RETURNING id) " +
+		"INSERT INTO company_audit (company_id, operation, actor) SELECT id, $2, 'system' FROM purged"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := c.storage.ExecContext(ctx, query, deletedBefore, string(domain.PurgeCompany))
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted companies: %w", storageError(err))
+	}
+	return res.RowsAffected()
+}
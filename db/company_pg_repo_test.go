@@ -2,46 +2,265 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
+	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 )
 
+var companyTestColumns = []string{"id", "name", "code", "country", "country_code", "website", "phone", "phone_e164", "deleted_at", "version"}
+
+const testID = "5f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f"
+
 func buildTestCases() map[string]*domain.FilterOptions {
 	limit := 0
 	return map[string]*domain.FilterOptions{
-		"true": nil,
-		" LIMIT $1": &domain.FilterOptions{
+		"deleted_at IS NULL ORDER BY id": nil,
+		"deleted_at IS NULL ORDER BY id LIMIT $1": &domain.FilterOptions{
 			Limit: &limit,
 		},
-		"name ILIKE $1 LIMIT $2": &domain.FilterOptions{
+		"deleted_at IS NULL AND name ILIKE $1 ORDER BY id LIMIT $2": &domain.FilterOptions{
 			Limit: &limit,
-			Params: map[string]string{
-				"name": "",
+			Where: domain.Condition{Field: "name", Op: domain.FilterContains, Values: []string{"a"}},
+		},
+		"deleted_at IS NULL AND (lower(code) = lower($1) AND country_code IN ($2, $3)) ORDER BY id": &domain.FilterOptions{
+			Where: domain.And{
+				domain.Condition{Field: "code", Op: domain.FilterEq, Values: []string{"c"}},
+				domain.Condition{Field: "countryCode", Op: domain.FilterIn, Values: []string{"ua", "cy"}},
 			},
 		},
-		"code ILIKE $1 LIMIT $2": &domain.FilterOptions{
-			Limit: &limit,
-			Params: map[string]string{
-				"code": "",
+		"deleted_at IS NULL AND ((website IS NULL OR website = '') OR NOT COALESCE(phone_e164 ILIKE $1, false)) ORDER BY id": &domain.FilterOptions{
+			Where: domain.Or{
+				domain.Condition{Field: "website", Op: domain.FilterEmpty},
+				domain.Condition{Field: "phone", Op: domain.FilterPrefix, Values: []string{"+380"}, Not: true},
 			},
 		},
+		"deleted_at IS NULL AND false ORDER BY id": &domain.FilterOptions{Where: domain.Or{}},
+		"true ORDER BY id":                         &domain.FilterOptions{IncludeDeleted: true},
+		"country IS NULL ORDER BY id": &domain.FilterOptions{
+			Where:          domain.Condition{Field: "country", Op: domain.FilterNull},
+			IncludeDeleted: true,
+		},
+		"deleted_at IS NULL ORDER BY name, COALESCE(country, '') DESC, id": &domain.FilterOptions{
+			Sort: []domain.SortField{{Field: "name"}, {Field: "country", Desc: true}},
+		},
+		"deleted_at IS NULL AND name ILIKE $1 AND ((name > $2) OR (name = $2 AND COALESCE(country, '') < $3) OR " +
+			"(name = $2 AND COALESCE(country, '') = $3 AND id > $4)) " +
+			"ORDER BY name, COALESCE(country, '') DESC, id LIMIT $5": &domain.FilterOptions{
+			Limit: &limit,
+			Where: domain.Condition{Field: "name", Op: domain.FilterPrefix, Values: []string{"a"}},
+			Sort:  []domain.SortField{{Field: "name"}, {Field: "country", Desc: true}},
+			After: &domain.Cursor{Values: []string{"n", "c"}, ID: testID},
+		},
 	}
 }
 
 func TestBuildPGRequest(t *testing.T) {
 	testCases := buildTestCases()
 	for want := range testCases {
-		got, _ := buildPGRequest(0, testCases[want])
+		got, _, err := buildPGRequest(0, testCases[want])
+		if err != nil {
+			t.Errorf("error was not expected while build %s: %s", want, err)
+		}
 		if !strings.EqualFold(got, want) {
 			t.Errorf("want: %s but get: %s", want, got)
 		}
 	}
 }
 
+func TestBuildPGWhereValues(t *testing.T) {
+	where, values, err := buildPGWhere(0, &domain.FilterOptions{Where: domain.And{
+		domain.Condition{Field: "name", Op: domain.FilterPrefix, Values: []string{"50%_off"}},
+		domain.Condition{Field: "phone", Op: domain.FilterEq, Values: []string{"+380 (44) 123-45-67"}},
+	}})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	want := []any{`50\%\_off%`, "+380441234567"}
+	if len(values) != len(want) || values[0] != want[0] || values[1] != want[1] {
+		t.Errorf("want values %v but got %v for %s", want, values, where)
+	}
+}
+
+func TestBuildPGWherePhone(t *testing.T) {
+	cases := map[string]domain.Condition{
+		"deleted_at IS NULL AND phone_e164 = $1": {Field: "phone", Op: domain.FilterEq, Values: []string{"00380 44 123 45 67"}},
+		"deleted_at IS NULL AND (country_code, phone_e164) IN (SELECT * FROM unnest($1::text[], $2::text[]))": {
+			Field: "phone", Op: domain.FilterEq, Values: []string{"044 123 45 67"},
+		},
+		"deleted_at IS NULL AND ((country_code, phone_e164) IN (SELECT * FROM unnest($1::text[], $2::text[])) OR " +
+			"phone_e164 ILIKE $3)": {Field: "phone", Op: domain.FilterContains, Values: []string{"044 123 45 67"}},
+		"deleted_at IS NULL AND (phone_e164 ILIKE $1 OR phone_e164 IN ($2, $3))": {
+			Field: "phone", Op: domain.FilterIn, Values: []string{"+380441234567", "ext 45", "+357 22 123456"},
+		},
+		"deleted_at IS NULL AND phone_e164 ILIKE $1": {Field: "phone", Op: domain.FilterPrefix, Values: []string{"044"}},
+	}
+	for want, cond := range cases {
+		where, values, err := buildPGWhere(0, &domain.FilterOptions{Where: cond})
+		if err != nil {
+			t.Errorf("error was not expected for %+v: %s", cond, err)
+			continue
+		}
+		if where != want {
+			t.Errorf("want %s but got %s", want, where)
+		}
+		if cond.Values[0] == "044 123 45 67" {
+			countries, ok := values[0].(interface{ Value() (driver.Value, error) })
+			if !ok {
+				t.Errorf("want countries array but got %T", values[0])
+				continue
+			}
+			v, _ := countries.Value()
+			if !strings.Contains(v.(string), "UA") {
+				t.Errorf("want the number parsed in Ukraine but got %v and %v", v, values[1])
+			}
+		}
+	}
+
+	for query, want := range map[string]string{"+380 44": "+38044%", "044": "%044%"} {
+		_, values, _ := buildPGWhere(0, &domain.FilterOptions{Where: domain.Condition{
+			Field: "phone", Op: domain.FilterPrefix, Values: []string{query},
+		}})
+		if len(values) != 1 || values[0] != want {
+			t.Errorf("prefix %q: want %s but got %v", query, want, values)
+		}
+	}
+}
+
+func TestBuildPGWhereRejectsUnknownFields(t *testing.T) {
+	for _, where := range []domain.Filter{
+		domain.Condition{Field: "name; DROP TABLE companies", Op: domain.FilterEq, Values: []string{"a"}},
+		domain.Condition{Field: "name", Op: "like", Values: []string{"a"}},
+		domain.Condition{Field: "name", Op: domain.FilterEq},
+	} {
+		_, _, err := buildPGWhere(0, &domain.FilterOptions{Where: where})
+		if !errors.Is(err, domain.ErrValidation) {
+			t.Errorf("want validation error for %+v but got %v", where, err)
+		}
+	}
+}
+
+func TestCompanyPostgreRepoGetMany(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	limit := 1
+	options := &domain.FilterOptions{Limit: &limit, Sort: []domain.SortField{{Field: "name"}}, WithTotal: true}
+	columns := companyTestColumns
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE deleted_at IS NULL ORDER BY name, id LIMIT").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(testID, "a", "a", "Ukraine", "UA", "", "", "", nil, 1).
+			AddRow("6f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f", "b", "b", "Ukraine", "UA", "", "", "", nil, 1))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	page, err := NewCompanyPostgresRepo(db, log.StandardLogger()).GetMany(context.Background(), options)
+	if err != nil {
+		t.Fatalf("error was not expected while get companies: %s", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Name != "a" {
+		t.Errorf("want only company a but got %v", page.Items)
+	}
+	if page.Total == nil || *page.Total != 2 {
+		t.Errorf("want total 2 but got %v", page.Total)
+	}
+	cursor, err := domain.DecodeCursor(page.NextCursor, options.Sort)
+	if err != nil {
+		t.Fatalf("next cursor should be valid: %s", err)
+	}
+	if cursor.ID != testID || cursor.Values[0] != "a" {
+		t.Errorf("next cursor should point after company a but got %+v", cursor)
+	}
+}
+
+func TestCompanyPostgreRepoSearch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	columns := append(companyTestColumns, "score")
+	mock.ExpectQuery(`SELECT (.+) AS score FROM companies WHERE (.+) AND country_code = \$3 ORDER BY score DESC, id LIMIT \$4`).
+		WithArgs("acme:* | corporation:*", "acme corporation", "UA", 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(testID, "Acme Corp", "a", "Ukraine", "UA", "", "", "", nil, 1, 0.42))
+
+	results, err := NewCompanyPostgresRepo(db, log.StandardLogger()).Search(context.Background(), &domain.SearchOptions{
+		Query: "Acme corporation",
+		Limit: 10,
+		Where: domain.Condition{Field: "countryCode", Op: domain.FilterEq, Values: []string{"ua"}},
+	})
+	if err != nil {
+		t.Fatalf("error was not expected while search companies: %s", err)
+	}
+	if len(results) != 1 || results[0].Score != 0.42 || results[0].Highlights["name"] != "<em>Acme</em> <em>Corp</em>" {
+		t.Errorf("want ranked and highlighted Acme Corp but got %+v", results)
+	}
+
+	// a failure in the middle of the result is not a shorter result
+	mock.ExpectQuery(`SELECT (.+) AS score FROM companies`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(testID, "Acme Corp", "a", "Ukraine", "UA", "", "", "", nil, 1, 0.42).
+			AddRow(testID, "Acme Group", "b", "Ukraine", "UA", "", "", "", nil, 1, 0.4).
+			RowError(1, errors.New("connection reset")))
+	results, err = NewCompanyPostgresRepo(db, log.StandardLogger()).Search(context.Background(), &domain.SearchOptions{
+		Query: "Acme",
+		Limit: 10,
+	})
+	if err == nil {
+		t.Errorf("want the error of the broken result but got %+v", results)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoExport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE deleted_at IS NULL AND country_code = \\$1 ORDER BY name, id$").
+		WithArgs("UA").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).
+			AddRow(testID, "a", "a", "Ukraine", "UA", "", "", "", nil, 1).
+			AddRow("6f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f", "b", "b", "Ukraine", "UA", "", "", "", nil, 1))
+	limit := 1
+	options := domain.FilterOptions{
+		Limit: &limit,
+		Where: domain.Condition{Field: "countryCode", Op: domain.FilterEq, Values: []string{"UA"}},
+		Sort:  []domain.SortField{{Field: "name"}},
+	}
+	var names []string
+	err = NewCompanyPostgresRepo(db, log.StandardLogger()).Export(context.Background(), &options, func(c *domain.Company) error {
+		names = append(names, c.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error was not expected while export companies: %s", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("want all companies exported without the limit but got %v", names)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestCompanyPostgreRepoCreate(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -49,15 +268,25 @@ func TestCompanyPostgreRepoCreate(t *testing.T) {
 	}
 	defer db.Close()
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO companies").
-		WithArgs("test", "test_code", "UA", "", "").
+		WithArgs(sqlmock.AnyArg(), "test", "test_code", "Ukraine", "UA", "", "", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(sqlmock.AnyArg(), "create", "alice", "10.0.0.1", "", nil, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 	company := NewCompanyPostgresRepo(db, log.StandardLogger())
 	// now we execute our method
-	err = company.Create(context.Background(), &domain.Company{Name: "test", Code: "test_code", Country: "UA"})
+	ctx := context.WithValue(context.Background(), domain.CtxUserSubjectKey, "alice")
+	ctx = context.WithValue(ctx, domain.CtxUserIPKey, netip.MustParseAddr("10.0.0.1"))
+	err = company.Create(ctx, &domain.Company{Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA"})
 	if err != nil {
 		t.Errorf("error was not expected while create company: %s", err)
 	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestCompanyPostgreRepoUpdate(t *testing.T) {
@@ -67,18 +296,150 @@ func TestCompanyPostgreRepoUpdate(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectExec("UPDATE companies SET").
-		WithArgs("test", "test_code", "UA", "example.com", "", "old_test", "old_test_code").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("old_test", "old_test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "old_test", "old_test_code", "Ukraine", "UA", "", "", "", nil, 1))
+	mock.ExpectQuery("UPDATE companies SET (.+) RETURNING version").
+		WithArgs("test", "test_code", "Ukraine", "UA", "example.com", "+380 44 123 4567", "+380441234567", testID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(testID, "update", "anonymous", "", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
 
 	company := NewCompanyPostgresRepo(db, log.StandardLogger())
 	// now we execute our method
-	err = company.Update(context.Background(), "old_test", "old_test_code",
-		&domain.Company{Name: "test", Code: "test_code", Country: "UA", Website: "example.com"},
-	)
+	updated := &domain.Company{
+		Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA", Website: "example.com",
+		Phone: domain.Phone{Original: "+380 44 123 4567", E164: "+380441234567"},
+	}
+	before, err := company.Update(context.Background(), "old_test", "old_test_code", updated)
 	if err != nil {
 		t.Errorf("error was not expected while update company: %s", err)
 	}
+	if before.Name != "old_test" || before.Version != 1 {
+		t.Errorf("want the locked company before the update but got %+v", before)
+	}
+	if updated.ID != testID || updated.Version != 2 {
+		t.Errorf("want id %s and version 2 of the updated company but got %s and %d", testID, updated.ID, updated.Version)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestCompanyPostgreRepoByID checks that v2 changes lock the row by its id, never by the name and code
+func TestCompanyPostgreRepoByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	repo := NewCompanyPostgresRepo(db, log.StandardLogger())
+	lock := "SELECT (.+) FROM companies WHERE id=\\$1 AND deleted_at IS NULL FOR UPDATE"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).
+		WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "renamed", "renamed_code", "Ukraine", "UA", "", "", "", nil, 3))
+	mock.ExpectQuery("UPDATE companies SET (.+) RETURNING version").
+		WithArgs("test", "test_code", "Ukraine", "UA", "", "", nil, testID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectExec("INSERT INTO company_audit").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	updated := &domain.Company{Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA"}
+	if before, err := repo.UpdateByID(context.Background(), testID, updated); err != nil || updated.Version != 4 || before.Name != "renamed" {
+		t.Errorf("want version 4 of the updated company and the renamed one before but got %d, %+v: %v", updated.Version, before, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).
+		WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 4))
+	mock.ExpectQuery("UPDATE companies SET website=\\$1, version=version\\+1 WHERE id=\\$2 RETURNING version").
+		WithArgs("https://example.com", testID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO company_audit").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	website := "https://example.com"
+	before, patched, err := repo.PatchByID(context.Background(), testID, &domain.CompanyPatch{Website: &website})
+	if err != nil || patched.Website != website || patched.Version != 5 || before.Version != 4 {
+		t.Errorf("want the patched company of version 5 and version 4 before but got %+v, %+v: %v", patched, before, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).
+		WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 5))
+	mock.ExpectExec("UPDATE companies SET deleted_at=now\\(\\), version=version\\+1 WHERE id=\\$1").
+		WithArgs(testID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(testID, "delete", "anonymous", "", "", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+	if err := repo.DeleteByID(context.Background(), testID); err != nil {
+		t.Errorf("error was not expected while delete company: %s", err)
+	}
+
+	// the company is deleted and other one is created with the same name and code
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs(testID).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	if err := repo.DeleteByID(context.Background(), testID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found error for a deleted id but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoPatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 4))
+	mock.ExpectQuery("UPDATE companies SET website=\\$1, phone=\\$2, phone_e164=\\$3, version=version\\+1 WHERE id=\\$4 RETURNING version").
+		WithArgs("https://example.com", "044 123 45 67", "+380441234567", testID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(testID, "update", "anonymous", "", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	repo := NewCompanyPostgresRepo(db, log.StandardLogger())
+	website, phone := "https://example.com", "044 123 45 67"
+	before, company, err := repo.Patch(context.Background(), "test", "test_code", &domain.CompanyPatch{Website: &website, Phone: &phone})
+	if err != nil {
+		t.Fatalf("error was not expected while patch company: %s", err)
+	}
+	if before.Website != "" || before.Version != 4 {
+		t.Errorf("want the locked company of version 4 before the patch but got %+v", before)
+	}
+	if company.Name != "test" || company.Website != website || company.Version != 5 {
+		t.Errorf("want patched company test of version 5 but got %+v", company)
+	}
+
+	// nothing to change, the version is kept
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 5))
+	mock.ExpectCommit()
+	name := "test"
+	if _, company, err = repo.Patch(context.Background(), "test", "test_code", &domain.CompanyPatch{Name: &name}); err != nil || company.Version != 5 {
+		t.Errorf("want unchanged company of version 5 but got %+v, %v", company, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestCompanyPostgreRepoDelete(t *testing.T) {
@@ -88,9 +449,17 @@ func TestCompanyPostgreRepoDelete(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectExec("DELETE FROM companies").
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
 		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 1))
+	mock.ExpectExec("UPDATE companies SET deleted_at=now\\(\\), version=version\\+1 WHERE id").
+		WithArgs(testID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(testID, "delete", "anonymous", "", "", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
 
 	company := NewCompanyPostgresRepo(db, log.StandardLogger())
 	// now we execute our method
@@ -98,4 +467,237 @@ func TestCompanyPostgreRepoDelete(t *testing.T) {
 	if err != nil {
 		t.Errorf("error was not expected while delete company: %s", err)
 	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 2))
+	mock.ExpectRollback()
+	ctx := context.WithValue(context.Background(), domain.CtxExpectedVersionKey, domain.ExpectedVersions{{ID: testID, Version: 1}})
+	if err = company.Delete(ctx, "test", "test_code"); !errors.Is(err, domain.ErrPreconditionFailed) {
+		t.Errorf("want precondition failed error for a stale version but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ops := []domain.BatchOperation{
+		{Action: domain.BatchCreate, Company: &domain.Company{Name: "new", Code: "new_code", Country: "Ukraine", CountryCode: "UA"}},
+		{Action: domain.BatchDelete, Name: "test", Code: "test_code", Version: 3},
+	}
+	repo := NewCompanyPostgresRepo(db, log.StandardLogger())
+
+	// the stale version of the deleted company rolls back the create
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO companies").
+		WithArgs(sqlmock.AnyArg(), "new", "new_code", "Ukraine", "UA", "", "", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 4))
+	mock.ExpectRollback()
+	results, err := repo.Batch(context.Background(), ops, &domain.BatchOptions{})
+	if err != nil {
+		t.Fatalf("error was not expected while apply batch: %s", err)
+	}
+	if !errors.Is(results[0].Err, domain.ErrRolledBack) || !errors.Is(results[1].Err, domain.ErrPreconditionFailed) {
+		t.Errorf("want the batch rolled back by the stale version but got %+v", results)
+	}
+
+	// every operation has its own transaction
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO companies").
+		WithArgs(sqlmock.AnyArg(), "new", "new_code", "Ukraine", "UA", "", "", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 4))
+	mock.ExpectRollback()
+	results, err = repo.Batch(context.Background(), ops, &domain.BatchOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("error was not expected while apply batch: %s", err)
+	}
+	if results[0].Err != nil || results[0].Company.ID == "" || results[0].Company.Version != 1 {
+		t.Errorf("want the company created but got %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, domain.ErrPreconditionFailed) {
+		t.Errorf("want precondition failed error for a stale version but got %v", results[1].Err)
+	}
+
+	// an update returns the company locked in the batch transaction before it
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", nil, 4))
+	mock.ExpectQuery("UPDATE companies SET (.+) RETURNING version").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	update := []domain.BatchOperation{{Action: domain.BatchUpdate, Name: "test", Code: "test_code",
+		Company: &domain.Company{Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA", Website: "example.com"}}}
+	results, err = repo.Batch(context.Background(), update, &domain.BatchOptions{})
+	if err != nil || results[0].Err != nil || results[0].Before.Version != 4 || results[0].Before.Website != "" ||
+		results[0].Company.Version != 5 {
+		t.Errorf("want the updated company and the company before it but got %+v: %v", results, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoRestore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NOT NULL").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", deletedAt, 2))
+	mock.ExpectQuery("UPDATE companies SET deleted_at=NULL, version=version\\+1 WHERE id").
+		WithArgs(testID).
+		WillReturnError(&pq.Error{Code: pqUniqueViolation})
+	mock.ExpectRollback()
+
+	repo := NewCompanyPostgresRepo(db, log.StandardLogger())
+	if _, err := repo.Restore(context.Background(), "test", "test_code"); !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("want already exists error when an active company exists but got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NOT NULL").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", deletedAt, 2))
+	mock.ExpectQuery("UPDATE companies SET deleted_at=NULL, version=version\\+1 WHERE id").
+		WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO company_audit").
+		WithArgs(testID, "restore", "anonymous", "", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	company, err := repo.Restore(context.Background(), "test", "test_code")
+	if err != nil {
+		t.Fatalf("error was not expected while restore company: %s", err)
+	}
+	if company.ID != testID || company.DeletedAt != nil || company.Version != 3 {
+		t.Errorf("want the restored company but got %+v", company)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoPurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	before := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM companies WHERE deleted_at < \\$1 RETURNING id(.+)INSERT INTO company_audit").
+		WithArgs(before, "purge").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := NewCompanyPostgresPurger(db, log.StandardLogger()).Purge(context.Background(), before)
+	if err != nil {
+		t.Fatalf("error was not expected while purge companies: %s", err)
+	}
+	if n != 3 {
+		t.Errorf("want 3 purged companies but got %d", n)
+	}
+}
+
+func TestCompanyPostgreRepoHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	before := `{"id":"` + testID + `","name":"a","code":"c","country":"Ukraine","countryCode":"UA",` +
+		`"phone":{"original":"044 123 45 67","e164":"+380441234567"}}`
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM company_audit").
+		WithArgs("a", "c").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "company_id", "operation", "actor", "ip", "request_id", "before", "after", "created_at"}).
+			AddRow(2, testID, "delete", "alice", "10.0.0.1", "r1", []byte(before), nil, created))
+
+	revisions, err := NewCompanyPostgresRepo(db, log.StandardLogger()).History(context.Background(), "a", "c")
+	if err != nil {
+		t.Fatalf("error was not expected while get history: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].Operation != domain.DeleteCompany || revisions[0].After != nil {
+		t.Fatalf("want one delete revision but got %+v", revisions)
+	}
+	if b := revisions[0].Before; b == nil || b.Name != "a" || b.Phone.National != "441234567" {
+		t.Errorf("want the snapshot before delete with a restored phone but got %+v", b)
+	}
+}
+
+func TestCompanyPostgreRepoErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	company := NewCompanyPostgresRepo(db, log.StandardLogger())
+
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name").
+		WithArgs("test", "test_code").
+		WillReturnError(sql.ErrNoRows)
+	if _, err = company.Get(context.Background(), "test", "test_code"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found error but got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO companies").
+		WillReturnError(&pq.Error{Code: pqUniqueViolation})
+	mock.ExpectRollback()
+	err = company.Create(context.Background(), &domain.Company{Name: "test", Code: "test_code"})
+	if !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("want already exists error but got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name").
+		WithArgs("test", "test_code").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	if err = company.Delete(context.Background(), "test", "test_code"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found error but got %v", err)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM company_audit").
+		WithArgs("test", "test_code").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name").
+		WithArgs("test", "test_code").
+		WillReturnError(sql.ErrNoRows)
+	if _, err = company.History(context.Background(), "test", "test_code"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found error but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
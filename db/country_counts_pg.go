@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+type countryCountsPostgreRepo struct {
+	storage   *sql.DB
+	l         *log.Logger
+	logPrefix string
+}
+
+// NewCountryCountsPostgresRepo keeps the country counts projection in company_country_counts
+func NewCountryCountsPostgresRepo(storage *sql.DB, l *log.Logger) domain.CountryCounts {
+	return &countryCountsPostgreRepo{storage: storage, l: l, logPrefix: "CountryCounts"}
+}
+
+// Apply records the event id and changes the counts in one transaction, so a redelivered event changes nothing
+func (c *countryCountsPostgreRepo) Apply(ctx context.Context, eventID string, deltas map[string]int64) (bool, error) {
+	tx, err := c.storage.BeginTx(ctx, nil)
+	if err != nil {
+		return false, storageError(err)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO projected_events (projection, event_id) VALUES ('country_counts', $1) ON CONFLICT DO NOTHING"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := tx.ExecContext(ctx, query, eventID)
+	if err != nil {
+		return false, fmt.Errorf("record projected event: %w", storageError(err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, fmt.Errorf("record projected event: %w", storageError(err))
+	} else if n == 0 {
+		return false, nil
+	}
+
+	codes := make([]string, 0, len(deltas))
+	for code := range deltas {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes) // the same lock order in concurrent transactions
+	query = "INSERT INTO company_country_counts (country_code, companies) VALUES ($1, $2) " +
+		"ON CONFLICT (country_code) DO UPDATE SET companies = company_country_counts.companies + EXCLUDED.companies, " +
+		"updated_at = now()"
+	for _, code := range codes {
+		c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+		if _, err := tx.ExecContext(ctx, query, code, deltas[code]); err != nil {
+			return false, fmt.Errorf("change count of %s: %w", code, storageError(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, storageError(err)
+	}
+	return true, nil
+}
+
+func (c *countryCountsPostgreRepo) Counts(ctx context.Context) (map[string]int64, error) {
+	query := "SELECT country_code, companies FROM company_country_counts WHERE companies <> 0"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("get country counts: %w", storageError(err))
+	}
+	defer rows.Close()
+	counts := map[string]int64{}
+	for rows.Next() {
+		var (
+			code string
+			n    int64
+		)
+		if err := rows.Scan(&code, &n); err != nil {
+			return nil, fmt.Errorf("get country counts: %w", storageError(err))
+		}
+		counts[code] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get country counts: %w", storageError(err))
+	}
+	return counts, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCountryCountsPostgreRepoApply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO projected_events (.+) ON CONFLICT DO NOTHING").
+		WithArgs("e1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO company_country_counts (.+) ON CONFLICT \\(country_code\\) DO UPDATE").
+		WithArgs("CY", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO company_country_counts").
+		WithArgs("UA", int64(-1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO projected_events").
+		WithArgs("e1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT country_code, companies FROM company_country_counts").
+		WillReturnRows(sqlmock.NewRows([]string{"country_code", "companies"}).AddRow("CY", 1).AddRow("UA", 4))
+
+	counts := NewCountryCountsPostgresRepo(db, log.StandardLogger())
+	deltas := map[string]int64{"UA": -1, "CY": 1}
+	if applied, err := counts.Apply(context.Background(), "e1", deltas); err != nil || !applied {
+		t.Errorf("want the event applied but got %v", err)
+	}
+	if applied, err := counts.Apply(context.Background(), "e1", deltas); err != nil || applied {
+		t.Errorf("a redelivered event should be skipped but got %v", err)
+	}
+	got, err := counts.Counts(context.Background())
+	if err != nil {
+		t.Fatalf("error was not expected while get counts: %s", err)
+	}
+	if !reflect.DeepEqual(got, map[string]int64{"CY": 1, "UA": 4}) {
+		t.Errorf("unexpected counts %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
+)
+
+// filterColumn - SQL of a filterable field
+type filterColumn struct {
+	name     string
+	nullable bool
+	exact    bool // compared as is, not case insensitive
+}
+
+// filterColumns - whitelist of filterable fields, column names are never taken from the filter itself
+var filterColumns = map[string]filterColumn{
+	"id":          {name: "id", exact: true},
+	"name":        {name: "name"},
+	"code":        {name: "code"},
+	"country":     {name: "country", nullable: true},
+	"countryCode": {name: "country_code", nullable: true, exact: true},
+	"website":     {name: "website", nullable: true},
+	"phone":       {name: "phone_e164", nullable: true, exact: true},
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// pgFilter compiles a filter tree into a parameterized SQL condition
+type pgFilter struct {
+	start  int
+	values []any
+}
+
+func (p *pgFilter) arg(v any) string {
+	p.values = append(p.values, v)
+	return fmt.Sprintf("$%d", p.start+len(p.values))
+}
+
+func (p *pgFilter) compile(f domain.Filter) (string, error) {
+	switch f := f.(type) {
+	case nil:
+		return "true", nil
+	case domain.And:
+		return p.join(f, " AND ", "true")
+	case domain.Or:
+		return p.join(f, " OR ", "false")
+	case domain.Condition:
+		return p.condition(f)
+	}
+	return "", fmt.Errorf("unknown filter node %T", f)
+}
+
+func (p *pgFilter) join(filters []domain.Filter, op, empty string) (string, error) {
+	if len(filters) == 0 {
+		return empty, nil
+	}
+	parts := make([]string, 0, len(filters))
+	for _, f := range filters {
+		part, err := p.compile(f)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	return "(" + strings.Join(parts, op) + ")", nil
+}
+
+func (p *pgFilter) condition(cond domain.Condition) (string, error) {
+	column, ok := filterColumns[cond.Field]
+	if !ok {
+		return "", fmt.Errorf("field %q can not be filtered", cond.Field)
+	}
+	values := make([]string, len(cond.Values))
+	for i, v := range cond.Values {
+		switch cond.Field {
+		case "countryCode":
+			values[i] = strings.ToUpper(v)
+		default:
+			values[i] = v
+		}
+	}
+	if len(values) == 0 && cond.Op != domain.FilterEmpty && cond.Op != domain.FilterNull {
+		return "", fmt.Errorf("%s filter of %s requires a value", cond.Op, cond.Field)
+	}
+
+	col := column.name
+	var expr string
+	switch cond.Op {
+	case domain.FilterEq, domain.FilterIn:
+		if cond.Op == domain.FilterEq && len(values) != 1 {
+			return "", fmt.Errorf("eq filter of %s requires one value", cond.Field)
+		}
+		if cond.Field == "phone" {
+			expr = p.phone(cond.Op, values)
+			break
+		}
+		args := make([]string, 0, len(values))
+		for _, v := range values {
+			switch {
+			case column.exact:
+				args = append(args, p.arg(v))
+			default:
+				args = append(args, "lower("+p.arg(v)+")")
+			}
+		}
+		if !column.exact {
+			col = "lower(" + col + ")"
+		}
+		if len(args) == 1 {
+			expr = col + " = " + args[0]
+		} else {
+			expr = col + " IN (" + strings.Join(args, ", ") + ")"
+		}
+	case domain.FilterPrefix, domain.FilterContains:
+		if len(values) != 1 {
+			return "", fmt.Errorf("%s filter of %s requires one value", cond.Op, cond.Field)
+		}
+		if cond.Field == "phone" {
+			expr = p.phone(cond.Op, values)
+			break
+		}
+		pattern := likeEscaper.Replace(values[0]) + "%"
+		if cond.Op == domain.FilterContains {
+			pattern = "%" + pattern
+		}
+		if cond.Field == "id" {
+			col += "::text"
+		}
+		expr = col + " ILIKE " + p.arg(pattern)
+	case domain.FilterEmpty:
+		switch {
+		case cond.Field == "id":
+			expr = "false" // uuid is never empty
+		case column.nullable:
+			expr = "(" + col + " IS NULL OR " + col + " = '')"
+		default:
+			expr = col + " = ''"
+		}
+	case domain.FilterNull:
+		expr = col + " IS NULL"
+	default:
+		return "", fmt.Errorf("unknown filter operation %q", cond.Op)
+	}
+	if cond.Not {
+		// a comparison with NULL is NULL, so NOT would hide companies without a value
+		return "NOT COALESCE(" + expr + ", false)", nil
+	}
+	return expr, nil
+}
+
+// phone compiles a phone condition with the semantic of domain.Matches: an international number is compared
+// with the E.164 number or its prefix, a national number with the E.164 number in the company country and
+// anything else, e.g. a part of a number, by digits
+func (p *pgFilter) phone(op domain.FilterOp, values []string) string {
+	var numbers, parts []string
+	for _, v := range values {
+		if op == domain.FilterPrefix {
+			if prefix, ok := domain.PhoneInternationalPrefix(v); ok {
+				parts = append(parts, "phone_e164 ILIKE "+p.arg(prefix+"%"))
+				continue
+			}
+		} else if phone, err := domain.ParsePhone(v, ""); err == nil {
+			numbers = append(numbers, phone.E164)
+			continue
+		} else if national := domain.NationalPhones(v); len(national) > 0 {
+			countries := domain.SortedCountries(national)
+			e164 := make([]string, len(countries))
+			for i := range countries {
+				e164[i] = national[countries[i]]
+			}
+			parts = append(parts, "(country_code, phone_e164) IN (SELECT * FROM unnest("+
+				p.arg(pq.Array(countries))+"::text[], "+p.arg(pq.Array(e164))+"::text[]))")
+			if op != domain.FilterContains {
+				continue
+			}
+		}
+		if digits := domain.PhoneDigits(v); digits != "" {
+			parts = append(parts, "phone_e164 ILIKE "+p.arg("%"+digits+"%"))
+		}
+	}
+	switch len(numbers) {
+	case 0:
+	case 1:
+		parts = append(parts, "phone_e164 = "+p.arg(numbers[0]))
+	default:
+		args := make([]string, len(numbers))
+		for i := range numbers {
+			args[i] = p.arg(numbers[i])
+		}
+		parts = append(parts, "phone_e164 IN ("+strings.Join(args, ", ")+")")
+	}
+	switch len(parts) {
+	case 0:
+		return "false"
+	case 1:
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+// buildPGWhere builds the filter condition of options.Where, deleted companies are excluded unless IncludeDeleted
+func buildPGWhere(start int, options *domain.FilterOptions) (string, []any, error) {
+	const notDeleted = "deleted_at IS NULL"
+	if options == nil {
+		return notDeleted, []any{}, nil
+	}
+	if options.Where == nil {
+		if options.IncludeDeleted {
+			return "true", []any{}, nil
+		}
+		return notDeleted, []any{}, nil
+	}
+	p := pgFilter{start: start, values: []any{}}
+	where, err := p.compile(options.Where)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", domain.ErrValidation, err.Error())
+	}
+	if !options.IncludeDeleted {
+		where = notDeleted + " AND " + where
+	}
+	return where, p.values, nil
+}
@@ -2,13 +2,28 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/OleksiiKhanin/companysvc/domain"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
+// dataMigration fills data with Go code right after the schema migration of the same version,
+// e.g. with the embedded datasets of domain which must not be copied into SQL
+type dataMigration struct {
+	version uint
+	name    string
+	run     func(*sql.DB) error
+}
+
+var dataMigrations = []dataMigration{
+	{version: 2, name: "resolve country codes", run: backfillCountryCodes},
+	{version: 3, name: "normalize phones", run: backfillPhones},
+}
+
 func MigrateSchema(storage *sql.DB, migrateFilesPath string) error {
 	driver, err := postgres.WithInstance(storage, &postgres.Config{})
 	if err != nil {
@@ -22,9 +37,109 @@ func MigrateSchema(storage *sql.DB, migrateFilesPath string) error {
 	if err != nil {
 		return fmt.Errorf("create migrator: %w", err)
 	}
+	version, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("get schema version: %w", err)
+	}
+	for _, dm := range dataMigrations {
+		// the data migration is repeated if the process stopped after the schema one, it changes only unfilled rows
+		if version > dm.version {
+			continue
+		}
+		if err := m.Migrate(dm.version); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+		if err := dm.run(storage); err != nil {
+			return fmt.Errorf("%s: %w", dm.name, err)
+		}
+		version = dm.version
+	}
 	err = m.Up()
 	if err != nil && err != migrate.ErrNoChange {
 		return err
 	}
 	return nil
 }
+
+// backfillCountryCodes resolves country names of the companies created before migration 2, typos included
+func backfillCountryCodes(storage *sql.DB) error {
+	tx, err := storage.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT name, code, country FROM companies WHERE country_code IS NULL AND country IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	type company struct{ name, code, country string }
+	var companies []company
+	for rows.Next() {
+		var c company
+		if err := rows.Scan(&c.name, &c.code, &c.country); err != nil {
+			rows.Close()
+			return err
+		}
+		companies = append(companies, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range companies {
+		found, ok := domain.MatchCountry(c.country)
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec("UPDATE companies SET country_code=$1, country=$2 WHERE name=$3 AND code=$4",
+			found.Alpha2, found.Name, c.name, c.code)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfillPhones stores E.164 numbers of the companies created before migration 3,
+// national numbers are parsed with the plan of the company country
+func backfillPhones(storage *sql.DB) error {
+	tx, err := storage.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT name, code, COALESCE(country_code, ''), phone FROM companies " +
+		"WHERE phone_e164 IS NULL AND phone IS NOT NULL AND phone <> ''")
+	if err != nil {
+		return err
+	}
+	type company struct{ name, code, countryCode, phone string }
+	var companies []company
+	for rows.Next() {
+		var c company
+		if err := rows.Scan(&c.name, &c.code, &c.countryCode, &c.phone); err != nil {
+			rows.Close()
+			return err
+		}
+		companies = append(companies, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range companies {
+		phone, err := domain.ParsePhone(c.phone, c.countryCode)
+		if err != nil {
+			continue // left as entered, Validate reports it on the next change
+		}
+		_, err = tx.Exec("UPDATE companies SET phone_e164=$1 WHERE name=$2 AND code=$3", phone.E164, c.name, c.code)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
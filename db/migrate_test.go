@@ -0,0 +1,61 @@
+package db
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBackfillCountryCodes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name, code, country FROM companies WHERE country_code IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "code", "country"}).
+			AddRow("a", "1", "ukraine").
+			AddRow("b", "2", "United Kindom").
+			AddRow("c", "3", "Atlantis"))
+	mock.ExpectExec("UPDATE companies SET country_code=\\$1, country=\\$2 WHERE name=\\$3 AND code=\\$4").
+		WithArgs("UA", "Ukraine", "a", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE companies SET country_code").
+		WithArgs("GB", "United Kingdom of Great Britain and Northern Ireland", "b", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := backfillCountryCodes(db); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBackfillPhones(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name, code, COALESCE\\(country_code, ''\\), phone FROM companies WHERE phone_e164 IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "code", "country_code", "phone"}).
+			AddRow("a", "1", "UA", "044 123 45 67").
+			AddRow("b", "2", "", "+1 (401) 555-0123").
+			AddRow("c", "3", "", "044 123 45 67"))
+	mock.ExpectExec("UPDATE companies SET phone_e164=\\$1 WHERE name=\\$2 AND code=\\$3").
+		WithArgs("+380441234567", "a", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE companies SET phone_e164").
+		WithArgs("+14015550123", "b", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := backfillPhones(db); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
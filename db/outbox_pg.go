@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxLease - claimed events are not given to other relays for this time
+const outboxLease = "1 minute"
+
+type outboxPostgreRepo struct {
+	storage   *sql.DB
+	l         *log.Logger
+	logPrefix string
+}
+
+// NewOutboxPostgresRepo reads events written by a company repository created WithOutbox
+func NewOutboxPostgresRepo(storage *sql.DB, l *log.Logger) domain.Outbox {
+	return &outboxPostgreRepo{storage: storage, l: l, logPrefix: "Outbox"}
+}
+
+func (o *outboxPostgreRepo) Claim(ctx context.Context, limit int) ([]domain.OutboxMessage, error) {
+	query := "UPDATE outbox SET next_attempt_at = now() + interval '" + outboxLease + "' WHERE id IN (" +
+		"SELECT id FROM outbox o WHERE sent_at IS NULL AND parked_at IS NULL AND next_attempt_at <= now() " +
+		"AND NOT EXISTS (SELECT 1 FROM outbox e WHERE e.company_id = o.company_id AND e.id < o.id " +
+		"AND e.sent_at IS NULL AND e.parked_at IS NULL AND e.next_attempt_at > now()) " +
+		"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED) " +
+		"RETURNING id, payload, created_at, attempts"
+	o.l.Tracef("%s:Try execute: %s", o.logPrefix, query)
+	rows, err := o.storage.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim pending events: %w", storageError(err))
+	}
+	defer rows.Close()
+	messages := make([]domain.OutboxMessage, 0, limit)
+	broken := make(map[int64]error)
+	for rows.Next() {
+		var (
+			m       domain.OutboxMessage
+			payload []byte
+		)
+		if err := rows.Scan(&m.ID, &payload, &m.CreatedAt, &m.Attempts); err != nil {
+			return nil, fmt.Errorf("claim pending events: %w", storageError(err))
+		}
+		if err := json.Unmarshal(payload, &m.Event); err != nil {
+			broken[m.ID] = err
+			continue
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claim pending events: %w", storageError(err))
+	}
+	rows.Close()
+	for id, err := range broken {
+		// it would never be published, later events of the company must not wait for it
+		o.l.Errorf("%s: park event %d: parse payload: %s", o.logPrefix, id, err.Error())
+		if err := o.MarkFailed(ctx, id, "parse payload: "+err.Error(), time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+func (o *outboxPostgreRepo) MarkSent(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := "UPDATE outbox SET sent_at=now(), attempts=attempts+1, last_error='' WHERE id = ANY($1)"
+	o.l.Tracef("%s:Try execute: %s", o.logPrefix, query)
+	if _, err := o.storage.ExecContext(ctx, query, pq.Array(ids)); err != nil {
+		return fmt.Errorf("mark events sent: %w", storageError(err))
+	}
+	return nil
+}
+
+func (o *outboxPostgreRepo) MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error {
+	retry := sql.NullTime{Time: next, Valid: !next.IsZero()}
+	query := "UPDATE outbox SET attempts=attempts+1, last_error=$1, next_attempt_at=COALESCE($2, next_attempt_at), " +
+		"parked_at=CASE WHEN $2::timestamptz IS NULL THEN now() END WHERE id=$3"
+	o.l.Tracef("%s:Try execute: %s", o.logPrefix, query)
+	if _, err := o.storage.ExecContext(ctx, query, reason, retry, id); err != nil {
+		return fmt.Errorf("mark event failed: %w", storageError(err))
+	}
+	return nil
+}
+
+func (o *outboxPostgreRepo) Stats(ctx context.Context) (domain.OutboxStats, error) {
+	var stats domain.OutboxStats
+	query := "SELECT COUNT(*) FILTER (WHERE parked_at IS NULL), COUNT(*) FILTER (WHERE parked_at IS NOT NULL), " +
+		"MIN(created_at) FILTER (WHERE parked_at IS NULL) FROM outbox WHERE sent_at IS NULL"
+	o.l.Tracef("%s:Try execute: %s", o.logPrefix, query)
+	var oldest sql.NullTime
+	if err := o.storage.QueryRowContext(ctx, query).Scan(&stats.Pending, &stats.Parked, &oldest); err != nil {
+		return stats, fmt.Errorf("get outbox stats: %w", storageError(err))
+	}
+	if oldest.Valid {
+		stats.Oldest = &oldest.Time
+	}
+	return stats, nil
+}
+
+func (o *outboxPostgreRepo) Prune(ctx context.Context, sentBefore time.Time) (int64, error) {
+	query := "DELETE FROM outbox WHERE sent_at < $1"
+	o.l.Tracef("%s:Try execute: %s", o.logPrefix, query)
+	res, err := o.storage.ExecContext(ctx, query, sentBefore)
+	if err != nil {
+		return 0, fmt.Errorf("prune sent events: %w", storageError(err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("prune sent events: %w", storageError(err))
+	}
+	return n, nil
+}
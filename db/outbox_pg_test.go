@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCompanyPostgreRepoCreateWithOutbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO companies").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs("create", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	company := NewCompanyPostgresRepo(db, log.StandardLogger(), WithOutbox())
+	err = company.Create(context.Background(), &domain.Company{Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA"})
+	if err != nil {
+		t.Errorf("error was not expected while create company: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// restoreEventPayload matches the outbox payload of a restore, it has no before like the directly published event
+type restoreEventPayload struct{}
+
+func (restoreEventPayload) Match(v driver.Value) bool {
+	payload, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	var e domain.Event
+	return json.Unmarshal(payload, &e) == nil && e.Type == domain.RestoreCompany && e.Before == nil && e.CompanyID == testID
+}
+
+func TestCompanyPostgreRepoRestoreWithOutbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM companies WHERE name=\\$1 AND code=\\$2 AND deleted_at IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows(companyTestColumns).AddRow(testID, "test", "test_code", "Ukraine", "UA", "", "", "", deletedAt, 2))
+	mock.ExpectQuery("UPDATE companies SET deleted_at=NULL").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO company_audit").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs("restore", testID, restoreEventPayload{}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	company := NewCompanyPostgresRepo(db, log.StandardLogger(), WithOutbox())
+	if _, err := company.Restore(context.Background(), "test", "test_code"); err != nil {
+		t.Errorf("error was not expected while restore company: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCompanyPostgreRepoOutboxFailureRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO companies").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO company_audit").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox").WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+
+	company := NewCompanyPostgresRepo(db, log.StandardLogger(), WithOutbox())
+	err = company.Create(context.Background(), &domain.Company{Name: "test", Code: "test_code", Country: "Ukraine", CountryCode: "UA"})
+	if err == nil {
+		t.Error("the company must not be created without its event")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOutboxPostgreRepo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("UPDATE outbox SET next_attempt_at = now\\(\\) \\+ interval '1 minute' WHERE id IN \\(" +
+		"SELECT id FROM outbox o WHERE sent_at IS NULL AND parked_at IS NULL AND next_attempt_at <= now\\(\\) (.+)" +
+		"ORDER BY id LIMIT \\$1 FOR UPDATE SKIP LOCKED\\) RETURNING id, payload, created_at, attempts").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "created_at", "attempts"}).
+			AddRow(int64(8), []byte(`{"type":"update","companyId":"`+testID+`"}`), created, 0).
+			AddRow(int64(7), []byte(`{"type":"create","companyId":"`+testID+`","subject":{"name":"test"}}`), created, 1).
+			AddRow(int64(9), []byte(`"broken"`), created, 0))
+	mock.ExpectExec("UPDATE outbox SET attempts=attempts\\+1, last_error=\\$1(.+)parked_at=CASE(.+)WHERE id=\\$3").
+		WithArgs(sqlmock.AnyArg(), sql.NullTime{}, int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET sent_at=now\\(\\)(.+)WHERE id = ANY\\(\\$1\\)").
+		WithArgs(pq.Array([]int64{7})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FILTER \\(WHERE parked_at IS NULL\\), (.+) FROM outbox WHERE sent_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"pending", "parked", "min"}).AddRow(int64(0), int64(1), nil))
+
+	outbox := NewOutboxPostgresRepo(db, log.StandardLogger())
+	messages, err := outbox.Claim(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("error was not expected while claim pending events: %s", err)
+	}
+	if len(messages) != 2 || messages[0].ID != 7 || messages[1].ID != 8 || messages[0].Event.Type != domain.CreateCompany ||
+		messages[0].Event.CompanyID != testID || messages[0].Event.Subject.Name != "test" {
+		t.Fatalf("unexpected pending events %+v", messages)
+	}
+	if err := outbox.MarkSent(context.Background(), messages[0].ID); err != nil {
+		t.Fatalf("error was not expected while mark events sent: %s", err)
+	}
+	stats, err := outbox.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("error was not expected while get outbox stats: %s", err)
+	}
+	if stats.Pending != 0 || stats.Parked != 1 || stats.Oldest != nil {
+		t.Errorf("want no pending events and a parked one but got %+v", stats)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
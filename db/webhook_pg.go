@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookLease - due deliveries are not given to other dispatchers for this time
+const webhookLease = "5 minutes"
+
+type webhookPostgreRepo struct {
+	storage   *sql.DB
+	l         *log.Logger
+	logPrefix string
+}
+
+func NewWebhookPostgresRepo(storage *sql.DB, l *log.Logger) domain.Webhooks {
+	return &webhookPostgreRepo{storage: storage, l: l, logPrefix: "Webhooks"}
+}
+
+func eventTypeStrings(types []domain.EventType) []string {
+	res := make([]string, 0, len(types))
+	for _, t := range types {
+		res = append(res, string(t))
+	}
+	return res
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (domain.Webhook, error) {
+	var (
+		w     domain.Webhook
+		types []string
+	)
+	if err := row.Scan(&w.ID, &w.URL, pq.Array(&types), &w.Secret, &w.CreatedAt); err != nil {
+		return w, err
+	}
+	w.EventTypes = make([]domain.EventType, 0, len(types))
+	for _, t := range types {
+		w.EventTypes = append(w.EventTypes, domain.EventType(t))
+	}
+	return w, nil
+}
+
+func (c *webhookPostgreRepo) CreateWebhook(ctx context.Context, w *domain.Webhook) error {
+	w.ID = domain.NewID()
+	query := "INSERT INTO webhooks (id, url, event_types, secret) VALUES ($1, $2, $3, $4) RETURNING created_at"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	err := c.storage.QueryRowContext(ctx, query, w.ID, w.URL, pq.Array(eventTypeStrings(w.EventTypes)), w.Secret).
+		Scan(&w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create webhook: %w", storageError(err))
+	}
+	return nil
+}
+
+func (c *webhookPostgreRepo) GetWebhook(ctx context.Context, id string) (domain.Webhook, error) {
+	if !domain.IsValidID(id) {
+		return domain.Webhook{}, domain.ErrNotFound
+	}
+	query := "SELECT id, url, event_types, secret, created_at FROM webhooks WHERE id=$1"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	w, err := scanWebhook(c.storage.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return w, fmt.Errorf("get webhook: %w", storageError(err))
+	}
+	return w, nil
+}
+
+func (c *webhookPostgreRepo) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	query := "SELECT id, url, event_types, secret, created_at FROM webhooks ORDER BY created_at, id"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", storageError(err))
+	}
+	defer rows.Close()
+	webhooks := []domain.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list webhooks: %w", storageError(err))
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", storageError(err))
+	}
+	return webhooks, nil
+}
+
+func (c *webhookPostgreRepo) UpdateWebhook(ctx context.Context, w *domain.Webhook) error {
+	if !domain.IsValidID(w.ID) {
+		return domain.ErrNotFound
+	}
+	query := "UPDATE webhooks SET url=$2, event_types=$3, secret=$4 WHERE id=$1 RETURNING created_at"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	err := c.storage.QueryRowContext(ctx, query, w.ID, w.URL, pq.Array(eventTypeStrings(w.EventTypes)), w.Secret).
+		Scan(&w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("update webhook: %w", storageError(err))
+	}
+	return nil
+}
+
+// DeleteWebhook removes the webhook with its deliveries
+func (c *webhookPostgreRepo) DeleteWebhook(ctx context.Context, id string) error {
+	if !domain.IsValidID(id) {
+		return domain.ErrNotFound
+	}
+	query := "DELETE FROM webhooks WHERE id=$1"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := c.storage.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("delete webhook: %w", storageError(err))
+	}
+	return checkAffected(res)
+}
+
+func (c *webhookPostgreRepo) Enqueue(ctx context.Context, eventID string, eventType domain.EventType, payload []byte) (int64, error) {
+	query := "INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload) " +
+		"SELECT id, $1, $2, $3 FROM webhooks WHERE cardinality(event_types) = 0 OR $4 = ANY(event_types) " +
+		"ON CONFLICT (webhook_id, event_id) DO NOTHING"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := c.storage.ExecContext(ctx, query, eventID, string(eventType), payload, string(eventType))
+	if err != nil {
+		return 0, fmt.Errorf("enqueue webhook deliveries: %w", storageError(err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("enqueue webhook deliveries: %w", storageError(err))
+	}
+	return n, nil
+}
+
+func (c *webhookPostgreRepo) Due(ctx context.Context, limit int) ([]domain.WebhookDelivery, error) {
+	query := "UPDATE webhook_deliveries d SET next_attempt_at = now() + interval '" + webhookLease + "' " +
+		"FROM webhooks w WHERE w.id = d.webhook_id AND d.id IN (" +
+		"SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now() " +
+		"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED) " +
+		"RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get due webhook deliveries: %w", storageError(err))
+	}
+	defer rows.Close()
+	deliveries := make([]domain.WebhookDelivery, 0, limit)
+	for rows.Next() {
+		d := domain.WebhookDelivery{Status: domain.DeliveryPending}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("get due webhook deliveries: %w", storageError(err))
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get due webhook deliveries: %w", storageError(err))
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (c *webhookPostgreRepo) RecordAttempt(ctx context.Context, id int64, attempt *domain.DeliveryAttempt) error {
+	next := sql.NullTime{Time: attempt.Next, Valid: !attempt.Next.IsZero()}
+	query := "UPDATE webhook_deliveries SET status=$2, attempts=attempts+1, response_status=$3, last_error=$4, " +
+		"next_attempt_at=COALESCE($5, next_attempt_at), " +
+		"delivered_at=CASE WHEN $6 THEN now() END WHERE id=$1"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := c.storage.ExecContext(ctx, query, id, string(attempt.Status), attempt.ResponseStatus, attempt.Error, next,
+		attempt.Status == domain.DeliveryDelivered)
+	if err != nil {
+		return fmt.Errorf("record webhook delivery: %w", storageError(err))
+	}
+	return checkAffected(res)
+}
+
+func (c *webhookPostgreRepo) Deliveries(
+	ctx context.Context,
+	webhookID string,
+	status domain.DeliveryStatus,
+	limit int,
+) ([]domain.WebhookDelivery, error) {
+	if !domain.IsValidID(webhookID) {
+		return nil, domain.ErrNotFound
+	}
+	query := "SELECT id, webhook_id, event_id, event_type, status, attempts, response_status, last_error, created_at, " +
+		"next_attempt_at, delivered_at FROM webhook_deliveries WHERE webhook_id=$1"
+	args := []any{webhookID, limit}
+	if status != "" {
+		query += " AND status=$3"
+		args = append(args, string(status))
+	}
+	query += " ORDER BY id DESC LIMIT $2"
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	rows, err := c.storage.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", storageError(err))
+	}
+	defer rows.Close()
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var (
+			d    domain.WebhookDelivery
+			next time.Time
+		)
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.ResponseStatus,
+			&d.LastError, &d.CreatedAt, &next, &d.DeliveredAt)
+		if err != nil {
+			return nil, fmt.Errorf("get webhook deliveries: %w", storageError(err))
+		}
+		if d.Status == domain.DeliveryPending {
+			d.NextAttemptAt = &next
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", storageError(err))
+	}
+	return deliveries, nil
+}
+
+func (c *webhookPostgreRepo) Replay(ctx context.Context, webhookID string, ids ...int64) (int64, error) {
+	if !domain.IsValidID(webhookID) {
+		return 0, domain.ErrNotFound
+	}
+	query := "UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=now() " +
+		"WHERE webhook_id=$1 AND status='failed'"
+	args := []any{webhookID}
+	if len(ids) > 0 {
+		query += " AND id = ANY($2)"
+		args = append(args, pq.Array(ids))
+	}
+	c.l.Tracef("%s:Try execute: %s", c.logPrefix, query)
+	res, err := c.storage.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("replay webhook deliveries: %w", storageError(err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("replay webhook deliveries: %w", storageError(err))
+	}
+	return n, nil
+}
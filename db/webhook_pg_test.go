@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestWebhookPostgreRepoDeliveries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	const webhookID = "8c7d2b7e-3f5a-4d0e-9b1a-2f6c8e4d1a00"
+	now := time.Now()
+	payload := []byte(`{"id":"e1"}`)
+	mock.ExpectExec("INSERT INTO webhook_deliveries (.+) SELECT (.+) FROM webhooks (.+) ON CONFLICT \\(webhook_id, event_id\\) DO NOTHING").
+		WithArgs("e1", "update", payload, "update").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("UPDATE webhook_deliveries d SET next_attempt_at (.+) FOR UPDATE SKIP LOCKED(.+) RETURNING").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "event_id", "event_type", "payload", "attempts", "created_at", "url", "secret"}).
+			AddRow(7, webhookID, "e1", "update", payload, 0, now, "http://partner/hook", "0123456789abcdef").
+			AddRow(3, webhookID, "e0", "create", payload, 2, now, "http://partner/hook", "0123456789abcdef"))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status=\\$2, attempts=attempts\\+1").
+		WithArgs(int64(3), "failed", 500, "response status 500", sqlmock.AnyArg(), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status='pending', attempts=0(.+) AND id = ANY\\(\\$2\\)").
+		WithArgs(webhookID, pq.Array([]int64{3})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	webhooks := NewWebhookPostgresRepo(db, log.StandardLogger())
+	if n, err := webhooks.Enqueue(context.Background(), "e1", domain.UpdateCompany, payload); err != nil || n != 2 {
+		t.Errorf("want 2 deliveries enqueued, got %d: %v", n, err)
+	}
+	due, err := webhooks.Due(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("error was not expected while get due deliveries: %s", err)
+	}
+	if len(due) != 2 || due[0].ID != 3 || due[1].ID != 7 || due[0].Attempts != 2 || due[0].URL != "http://partner/hook" ||
+		due[0].Status != domain.DeliveryPending {
+		t.Fatalf("unexpected due deliveries %+v", due)
+	}
+	attempt := domain.DeliveryAttempt{Status: domain.DeliveryFailed, ResponseStatus: 500, Error: "response status 500"}
+	if err := webhooks.RecordAttempt(context.Background(), 3, &attempt); err != nil {
+		t.Errorf("error was not expected while record attempt: %s", err)
+	}
+	if n, err := webhooks.Replay(context.Background(), webhookID, 3); err != nil || n != 1 {
+		t.Errorf("want 1 replayed delivery, got %d: %v", n, err)
+	}
+	if _, err := webhooks.Replay(context.Background(), "not-a-uuid"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found for an invalid webhook id, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
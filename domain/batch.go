@@ -0,0 +1,112 @@
+package domain
+
+import "context"
+
+// MaxBatchSize - operations of one batch
+const MaxBatchSize = 1000
+
+type BatchAction string
+
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchOperation - a change of a batch, Name and Code find the updated or deleted company
+type BatchOperation struct {
+	Action  BatchAction
+	Name    string
+	Code    string
+	Company *Company // of create and update
+	// Version - the operation fails with ErrPreconditionFailed if the company has another version, 0 skips the check
+	Version int64
+}
+
+// Validate checks that the operation is complete, its company is checked by Company.Validate
+func (o *BatchOperation) Validate() error {
+	var v ValidationError
+	switch o.Action {
+	case BatchCreate:
+	case BatchUpdate, BatchDelete:
+		checkRequired(&v, "name", o.Name, MaxNameLength)
+		checkRequired(&v, "code", o.Code, MaxCodeLength)
+	default:
+		v.Add("action", "must be one of create, update, delete")
+	}
+	if (o.Action == BatchCreate || o.Action == BatchUpdate) && o.Company == nil {
+		v.Add("company", "is required")
+	}
+	return v.OrNil()
+}
+
+// Context returns ctx expecting the version of the operation, see CtxExpectedVersionKey
+func (o *BatchOperation) Context(ctx context.Context) context.Context {
+	if o.Version == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, CtxExpectedVersionKey, ExpectedVersions{{Version: o.Version}})
+}
+
+// BatchOptions - ContinueOnError applies each operation on its own, otherwise the batch is applied all or nothing.
+// DryRun only checks the operations, the company service does not pass them to the storage.
+type BatchOptions struct {
+	ContinueOnError bool
+	DryRun          bool
+}
+
+// BatchResult - the outcome of an operation, Err is nil if it was applied.
+// Company is the created or updated company and the company before a delete.
+// Before is the company before an update or a delete, it is read under the lock of the operation.
+type BatchResult struct {
+	Company Company
+	Before  Company
+	Err     error
+}
+
+// CheckBatch checks every operation before the batch is applied. A failed operation gets the error,
+// without ContinueOnError the others get ErrRolledBack then. It returns indexes of operations to apply.
+func CheckBatch(ops []BatchOperation, options *BatchOptions, check func(*BatchOperation) error) ([]BatchResult, []int) {
+	results := make([]BatchResult, len(ops))
+	pending := make([]int, 0, len(ops))
+	for i := range ops {
+		if err := check(&ops[i]); err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) < len(ops) && !options.ContinueOnError {
+		for _, i := range pending {
+			results[i].Err = ErrRolledBack
+		}
+		return results, nil
+	}
+	return results, pending
+}
+
+// ApplyBatch applies the pending operations by apply and puts their results to results
+func ApplyBatch(
+	ctx context.Context,
+	ops []BatchOperation,
+	options *BatchOptions,
+	results []BatchResult,
+	pending []int,
+	apply func(context.Context, []BatchOperation, *BatchOptions) ([]BatchResult, error),
+) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	selected := make([]BatchOperation, 0, len(pending))
+	for _, i := range pending {
+		selected = append(selected, ops[i])
+	}
+	applied, err := apply(ctx, selected, options)
+	if err != nil {
+		return err
+	}
+	for j, i := range pending {
+		results[i] = applied[j]
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckAndApplyBatch(t *testing.T) {
+	ops := []BatchOperation{
+		{Action: BatchDelete, Name: "a", Code: "a"},
+		{Action: BatchDelete, Name: "b", Code: "b"},
+		{Action: BatchDelete, Name: "c", Code: "c"},
+	}
+	check := func(op *BatchOperation) error {
+		if op.Name == "b" {
+			return ErrForbidden
+		}
+		return nil
+	}
+
+	results, pending := CheckBatch(ops, &BatchOptions{}, check)
+	if len(pending) != 0 || !errors.Is(results[0].Err, ErrRolledBack) || !errors.Is(results[1].Err, ErrForbidden) {
+		t.Errorf("want the batch rolled back but got %+v, pending %v", results, pending)
+	}
+
+	options := &BatchOptions{ContinueOnError: true}
+	results, pending = CheckBatch(ops, options, check)
+	var applied []string
+	err := ApplyBatch(context.Background(), ops, options, results, pending,
+		func(_ context.Context, ops []BatchOperation, _ *BatchOptions) ([]BatchResult, error) {
+			res := make([]BatchResult, len(ops))
+			for i := range ops {
+				applied = append(applied, ops[i].Name)
+				res[i].Company = Company{Name: ops[i].Name}
+			}
+			return res, nil
+		})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(applied) != 2 || results[0].Company.Name != "a" || results[2].Company.Name != "c" || !errors.Is(results[1].Err, ErrForbidden) {
+		t.Errorf("want a and c applied but got %v, %+v", applied, results)
+	}
+}
@@ -0,0 +1,79 @@
+package domain
+
+import "strings"
+
+// Claims - verified token claims of the caller
+type Claims map[string]any
+
+// Subject returns the "sub" claim
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Scopes returns scopes from the space-delimited "scope" claim or from the "scp"/"scopes" array claims
+func (c Claims) Scopes() []string {
+	if scope, ok := c["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	for _, key := range []string{"scp", "scopes"} {
+		if scopes := c.stringList(key); scopes != nil {
+			return scopes
+		}
+	}
+	return nil
+}
+
+// HasScopes reports whether the caller was granted all given scopes
+func (c Claims) HasScopes(scopes ...string) bool {
+	granted := c.Scopes()
+	for _, scope := range scopes {
+		found := false
+		for i := range granted {
+			if granted[i] == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Roles returns the "roles" claim
+func (c Claims) Roles() []string {
+	return c.stringList("roles")
+}
+
+// HasAnyRole reports whether the caller has at least one of the given roles
+func (c Claims) HasAnyRole(roles ...string) bool {
+	granted := c.Roles()
+	for _, role := range roles {
+		for i := range granted {
+			if granted[i] == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Claims) stringList(key string) []string {
+	switch v := c[key].(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []any:
+		res := make([]string, 0, len(v))
+		for i := range v {
+			if s, ok := v[i].(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
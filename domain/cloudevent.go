@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	// EventSchemaVersion - version of CompanyEventData, it is increased by incompatible changes
+	EventSchemaVersion = 1
+	DefaultEventSource = "/companysvc"
+
+	cloudEventTypePrefix = "companysvc.company."
+	cloudEventsJSON      = "application/cloudevents+json"
+)
+
+// EventDataSchema identifies the schema of CompanyEventData of the version
+func EventDataSchema(version int) string {
+	return fmt.Sprintf("urn:companysvc:schema:company-event:v%d", version)
+}
+
+// CloudEventType returns the CloudEvents type of a company event, e.g. companysvc.company.create
+func CloudEventType(op EventType) string {
+	return cloudEventTypePrefix + string(op)
+}
+
+// CloudEvent - a company event in the CloudEvents 1.0 JSON format, actor and requestid are extension attributes
+type CloudEvent struct {
+	SpecVersion     string           `json:"specversion"`
+	ID              string           `json:"id"`
+	Source          string           `json:"source"`
+	Type            string           `json:"type"`
+	Subject         string           `json:"subject,omitempty"` // id of the company
+	Time            time.Time        `json:"time"`
+	DataContentType string           `json:"datacontenttype"`
+	DataSchema      string           `json:"dataschema"`
+	Actor           string           `json:"actor,omitempty"`
+	RequestID       string           `json:"requestid,omitempty"`
+	Data            CompanyEventData `json:"data"`
+}
+
+// CompanyEventData - the data of a company CloudEvent, Before is nil for create and restore, After is nil for delete
+type CompanyEventData struct {
+	SchemaVersion int      `json:"schemaVersion"`
+	CompanyID     string   `json:"companyId"`
+	Before        *Company `json:"before"`
+	After         *Company `json:"after"`
+	// ChangedFields - json names of the changed fields of an update
+	ChangedFields []string `json:"changedFields,omitempty"`
+}
+
+// CloudEvent returns the event as a CloudEvent of the source
+func (e *Event) CloudEvent(source string) CloudEvent {
+	if source == "" {
+		source = DefaultEventSource
+	}
+	data := CompanyEventData{
+		SchemaVersion: EventSchemaVersion,
+		CompanyID:     e.CompanyID,
+		Before:        e.Before,
+		ChangedFields: e.ChangedFields,
+	}
+	if e.Type != DeleteCompany {
+		after := e.Subject
+		data.After = &after
+	}
+	if e.Before == nil && e.Type == DeleteCompany {
+		before := e.Subject // events stored before the snapshots were added
+		data.Before = &before
+	}
+	eventTime := e.Time
+	if eventTime.IsZero() {
+		eventTime = time.Now().UTC()
+	}
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              e.ID,
+		Source:          source,
+		Type:            CloudEventType(e.Type),
+		Subject:         e.CompanyID,
+		Time:            eventTime,
+		DataContentType: "application/json",
+		DataSchema:      EventDataSchema(EventSchemaVersion),
+		Actor:           e.Actor,
+		RequestID:       e.RequestID,
+		Data:            data,
+	}
+}
+
+// EventFormat encodes events as CloudEvents of Source. The structured mode puts the whole CloudEvent in the message
+// data, the binary mode puts only its data there and the attributes in ce- headers.
+type EventFormat struct {
+	Source string
+	Binary bool
+}
+
+// Encode returns the message of the event, an event without id gets a new one
+func (f EventFormat) Encode(e *Event) (*Message, error) {
+	if e.ID == "" {
+		e.ID = NewID()
+	}
+	ce := e.CloudEvent(f.Source)
+	msg := &Message{ID: ce.ID, Type: e.Type, Key: e.CompanyID, Header: map[string]string{}}
+	var err error
+	if !f.Binary {
+		msg.Header["content-type"] = cloudEventsJSON
+		msg.Data, err = json.Marshal(ce)
+		return msg, err
+	}
+	msg.Header["content-type"] = ce.DataContentType
+	msg.Header["ce-specversion"] = ce.SpecVersion
+	msg.Header["ce-id"] = ce.ID
+	msg.Header["ce-source"] = ce.Source
+	msg.Header["ce-type"] = ce.Type
+	msg.Header["ce-subject"] = ce.Subject
+	msg.Header["ce-time"] = ce.Time.Format(time.RFC3339Nano)
+	msg.Header["ce-dataschema"] = ce.DataSchema
+	if ce.Actor != "" {
+		msg.Header["ce-actor"] = ce.Actor
+	}
+	if ce.RequestID != "" {
+		msg.Header["ce-requestid"] = ce.RequestID
+	}
+	msg.Data, err = json.Marshal(ce.Data)
+	return msg, err
+}
+
+// DecodeCloudEvent decodes a company event message in the structured or the binary mode
+func DecodeCloudEvent(msg *Message) (CloudEvent, error) {
+	var ce CloudEvent
+	if msg.Header["ce-specversion"] == "" {
+		if err := json.Unmarshal(msg.Data, &ce); err != nil {
+			return ce, fmt.Errorf("decode event: %w", err)
+		}
+	} else {
+		if err := json.Unmarshal(msg.Data, &ce.Data); err != nil {
+			return ce, fmt.Errorf("decode event data: %w", err)
+		}
+		ce.SpecVersion = msg.Header["ce-specversion"]
+		ce.ID = msg.Header["ce-id"]
+		ce.Source = msg.Header["ce-source"]
+		ce.Type = msg.Header["ce-type"]
+		ce.Subject = msg.Header["ce-subject"]
+		ce.DataContentType = msg.Header["content-type"]
+		ce.DataSchema = msg.Header["ce-dataschema"]
+		ce.Actor = msg.Header["ce-actor"]
+		ce.RequestID = msg.Header["ce-requestid"]
+		if t := msg.Header["ce-time"]; t != "" {
+			var err error
+			if ce.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+				return ce, fmt.Errorf("decode event time: %w", err)
+			}
+		}
+	}
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return ce, fmt.Errorf("unsupported CloudEvents version %q", ce.SpecVersion)
+	}
+	if ce.ID == "" || !strings.HasPrefix(ce.Type, cloudEventTypePrefix) {
+		return ce, fmt.Errorf("event %q of type %q is not a company event", ce.ID, ce.Type)
+	}
+	if ce.Data.SchemaVersion > EventSchemaVersion {
+		return ce, fmt.Errorf("unsupported event schema version %d", ce.Data.SchemaVersion)
+	}
+	return ce, nil
+}
+
+// EventType returns the type of the company change, e.g. update for companysvc.company.update
+func (ce *CloudEvent) EventType() EventType {
+	return EventType(strings.TrimPrefix(ce.Type, cloudEventTypePrefix))
+}
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEventFormatEncode(t *testing.T) {
+	before := &Company{ID: "1", Name: "old", Code: "c"}
+	e := Event{
+		ID: "42", Type: DeleteCompany, Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		CompanyID: "1", Subject: *before, Before: before, Actor: "alice", RequestID: "req-1",
+	}
+
+	msg, err := EventFormat{Source: "/test"}.Encode(&e)
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	var ce CloudEvent
+	if err := json.Unmarshal(msg.Data, &ce); err != nil {
+		t.Fatalf("structured event must be a JSON CloudEvent: %s", err)
+	}
+	if ce.SpecVersion != "1.0" || ce.ID != "42" || ce.Source != "/test" || ce.Type != "companysvc.company.delete" ||
+		ce.Subject != "1" || !ce.Time.Equal(e.Time) || ce.DataSchema != EventDataSchema(EventSchemaVersion) ||
+		ce.Actor != "alice" || ce.RequestID != "req-1" || msg.Header["content-type"] != "application/cloudevents+json" {
+		t.Errorf("unexpected structured event %+v", ce)
+	}
+	if ce.Data.SchemaVersion != EventSchemaVersion || ce.Data.Before == nil || ce.Data.Before.Name != "old" || ce.Data.After != nil {
+		t.Errorf("want the delete event with the company before it but got %+v", ce.Data)
+	}
+	if msg.ID != "42" || msg.Type != DeleteCompany || msg.Key != "1" {
+		t.Errorf("want message 42 of delete with key 1 but got %s %s %s", msg.ID, msg.Type, msg.Key)
+	}
+
+	msg, err = EventFormat{Binary: true}.Encode(&e)
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	var data CompanyEventData
+	if err := json.Unmarshal(msg.Data, &data); err != nil || data.CompanyID != "1" {
+		t.Errorf("binary event must contain only the data but got %s", msg.Data)
+	}
+	want := map[string]string{
+		"content-type":   "application/json",
+		"ce-specversion": "1.0",
+		"ce-id":          "42",
+		"ce-source":      DefaultEventSource,
+		"ce-type":        "companysvc.company.delete",
+		"ce-subject":     "1",
+		"ce-time":        "2024-01-02T03:04:05Z",
+		"ce-dataschema":  "urn:companysvc:schema:company-event:v1",
+		"ce-actor":       "alice",
+		"ce-requestid":   "req-1",
+	}
+	for k, v := range want {
+		if msg.Header[k] != v {
+			t.Errorf("header %s: want %q but got %q", k, v, msg.Header[k])
+		}
+	}
+}
+
+func TestDecodeCloudEvent(t *testing.T) {
+	e := Event{ID: "42", Type: UpdateCompany, Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), CompanyID: "1",
+		Subject: Company{ID: "1", Name: "new"}, Before: &Company{ID: "1", Name: "old"}, Actor: "alice"}
+	for _, format := range []EventFormat{{}, {Binary: true}} {
+		msg, err := format.Encode(&e)
+		if err != nil {
+			t.Fatalf("error was not expected: %s", err)
+		}
+		ce, err := DecodeCloudEvent(msg)
+		if err != nil {
+			t.Fatalf("binary %v: error was not expected: %s", format.Binary, err)
+		}
+		if ce.ID != "42" || ce.EventType() != UpdateCompany || !ce.Time.Equal(e.Time) || ce.Actor != "alice" ||
+			ce.Data.Before.Name != "old" || ce.Data.After.Name != "new" {
+			t.Errorf("binary %v: unexpected event %+v", format.Binary, ce)
+		}
+	}
+
+	for _, msg := range []*Message{
+		{Data: []byte(`{"specversion": "0.3", "id": "1", "type": "companysvc.company.create"}`)},
+		{Data: []byte(`{"specversion": "1.0", "id": "1", "type": "other.create"}`)},
+		{Data: []byte(`{"specversion": "1.0", "id": "1", "type": "companysvc.company.create", "data": {"schemaVersion": 2}}`)},
+		{Header: map[string]string{"ce-specversion": "1.0", "ce-id": "1", "ce-type": "companysvc.company.create"}, Data: []byte(`[]`)},
+	} {
+		if _, err := DecodeCloudEvent(msg); err == nil {
+			t.Errorf("want an error for %s %s", msg.Header, msg.Data)
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package domain
 
 const (
-	CreateCompany EventType = "create"
-	UpdateCompany EventType = "update"
-	DeleteCompany EventType = "delete"
+	CreateCompany  EventType = "create"
+	UpdateCompany  EventType = "update"
+	DeleteCompany  EventType = "delete"
+	RestoreCompany EventType = "restore"
+	PurgeCompany   EventType = "purge" // recorded in the history only, purged companies are not published
 )
 
 const (
-	CtxUserIPKey = "ip"
+	CtxUserIPKey      = "ip" // netip.Addr of the client
+	CtxUserSubjectKey = "subject"
+	CtxUserClaimsKey  = "claims"
+	CtxRequestIDKey   = "requestID"
+	// CtxExpectedVersionKey - ExpectedVersions of the changed company
+	CtxExpectedVersionKey = "expectedVersion"
 )
@@ -0,0 +1,161 @@
+package domain
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"strings"
+	"sync"
+)
+
+var (
+	//go:embed data/iso3166.csv
+	iso3166CSV string
+	//go:embed data/country_aliases.csv
+	countryAliasesCSV string
+)
+
+// Country - an ISO 3166-1 country
+type Country struct {
+	Alpha2 string
+	Alpha3 string
+	Name   string
+}
+
+var (
+	countriesOnce sync.Once
+	countries     []Country
+	countryIndex  map[string]int // normalized alpha-2, alpha-3, name and aliases -> index in countries
+)
+
+var countryKeyReplacer = strings.NewReplacer(
+	".", "", "'", "", "’", "",
+	",", " ", "-", " ", "(", " ", ")", " ",
+	"å", "a", "á", "a", "ã", "a", "é", "e", "è", "e", "í", "i", "ç", "c", "ô", "o", "ó", "o", "ü", "u",
+)
+
+// countryKey normalizes a country name for lookups: lower case, without diacritics and punctuation
+func countryKey(s string) string {
+	return strings.Join(strings.Fields(countryKeyReplacer.Replace(strings.ToLower(s))), " ")
+}
+
+func readCSV(data string) [][]string {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic("parse embedded country dataset: " + err.Error())
+	}
+	return records[1:]
+}
+
+func loadCountries() {
+	records := readCSV(iso3166CSV)
+	countryIndex = make(map[string]int, len(records)*4)
+	for _, r := range records {
+		countries = append(countries, Country{Alpha2: r[0], Alpha3: r[1], Name: r[2]})
+		for _, key := range r {
+			countryIndex[countryKey(key)] = len(countries) - 1
+		}
+	}
+	for _, r := range readCSV(countryAliasesCSV) {
+		i, ok := countryIndex[countryKey(r[1])]
+		if !ok {
+			panic("country alias " + r[0] + " refers to unknown code " + r[1])
+		}
+		countryIndex[countryKey(r[0])] = i
+	}
+}
+
+// Countries returns all ISO 3166-1 countries
+func Countries() []Country {
+	countriesOnce.Do(loadCountries)
+	return countries
+}
+
+// CountryAliases returns every known spelling (codes, names and aliases) in the normalized form
+// used by LookupCountry with the alpha-2 code of its country
+func CountryAliases() map[string]string {
+	countriesOnce.Do(loadCountries)
+	res := make(map[string]string, len(countryIndex))
+	for key, i := range countryIndex {
+		res[key] = countries[i].Alpha2
+	}
+	return res
+}
+
+// LookupCountry finds a country by its alpha-2 code, alpha-3 code, name or a known alias, case insensitive
+func LookupCountry(s string) (Country, bool) {
+	countriesOnce.Do(loadCountries)
+	i, ok := countryIndex[countryKey(s)]
+	if !ok {
+		return Country{}, false
+	}
+	return countries[i], true
+}
+
+// IsCountryCode reports whether s is an ISO 3166-1 alpha-2 code, case insensitive
+func IsCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	c, ok := LookupCountry(s)
+	return ok && strings.EqualFold(c.Alpha2, s)
+}
+
+// MatchCountry works like LookupCountry but also tolerates typos in names, e.g. "United Kindom".
+// A fuzzy match is accepted only if it is unambiguous.
+func MatchCountry(s string) (Country, bool) {
+	if c, ok := LookupCountry(s); ok {
+		return c, true
+	}
+	key := []rune(countryKey(s))
+	maxDistance := 2
+	switch {
+	case len(key) < 5:
+		return Country{}, false
+	case len(key) < 10:
+		maxDistance = 1
+	}
+	best, bestDistance := -1, maxDistance+1
+	for alias, i := range countryIndex {
+		d := levenshtein(key, []rune(alias))
+		switch {
+		case d < bestDistance:
+			best, bestDistance = i, d
+		case d == bestDistance && best != i:
+			best = -2 // ambiguous on this distance
+		}
+	}
+	if best < 0 {
+		return Country{}, false
+	}
+	return countries[best], true
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,55 @@
+package domain
+
+import "testing"
+
+func TestMatchCountry(t *testing.T) {
+	cases := map[string]string{
+		"UA":                "UA",
+		"ukr":               "UA",
+		"Ukraine":           "UA",
+		"united kingdom":    "GB",
+		"United Kindom":     "GB",
+		"U.K.":              "GB",
+		"Cote d'Ivoire":     "CI",
+		"Côte d’Ivoire":     "CI",
+		"Frence":            "FR",
+		"  germany ":        "DE",
+		"Russian Federaton": "RU",
+	}
+	for input, want := range cases {
+		got, ok := MatchCountry(input)
+		if !ok {
+			t.Errorf("%q should match %s", input, want)
+			continue
+		}
+		if got.Alpha2 != want {
+			t.Errorf("%q: want %s but got %s", input, want, got.Alpha2)
+		}
+	}
+	for _, input := range []string{"", "Atlantis", "XX", "Narnia"} {
+		if got, ok := MatchCountry(input); ok {
+			t.Errorf("%q should not match but got %s", input, got.Alpha2)
+		}
+	}
+}
+
+func TestCompanyNormalizeCountry(t *testing.T) {
+	c := Company{Name: " Acme ", Code: "ACM", Country: "United Kindom"}
+	c.Normalize()
+	if c.Name != "Acme" || c.CountryCode != "GB" || c.Country != "United Kingdom of Great Britain and Northern Ireland" {
+		t.Errorf("unexpected normalized company %+v", c)
+	}
+	byCode := Company{Name: "Acme", Code: "ACM", CountryCode: "cy"}
+	byCode.Normalize()
+	if byCode.Country != "Cyprus" || byCode.CountryCode != "CY" {
+		t.Errorf("unexpected normalized company %+v", byCode)
+	}
+}
+
+func TestIsCountryCode(t *testing.T) {
+	for code, want := range map[string]bool{"UA": true, "cy": true, "UK": false, "XX": false, "UKR": false, "": false} {
+		if got := IsCountryCode(code); got != want {
+			t.Errorf("IsCountryCode(%q): want %v but got %v", code, want, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound - the requested company does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists - a company with the same unique key already exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrForbidden - the caller is not allowed to perform the operation
+	ErrForbidden = errors.New("forbidden")
+	// ErrValidation - the request data is invalid
+	ErrValidation = errors.New("validation failed")
+	// ErrUnavailable - a dependency (storage, location service etc) is temporarily unavailable
+	ErrUnavailable = errors.New("unavailable")
+	// ErrPreconditionFailed - the company was changed since the version the caller expects
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrRolledBack - a batch operation was not applied because another operation of the batch failed
+	ErrRolledBack = errors.New("rolled back")
+)
+
+// AccessDeniedError - an authorization policy denied the operation
+type AccessDeniedError struct {
+	Operation string
+	Subject   string
+	Reason    string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("%s is not allowed to %s: %s", e.Subject, e.Operation, e.Reason)
+}
+
+func (e *AccessDeniedError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// FieldError - a violation of a single field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError - all field violations of a request
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msg := make([]string, 0, len(e.Fields))
+	for i := range e.Fields {
+		msg = append(msg, e.Fields[i].Field+": "+e.Fields[i].Message)
+	}
+	return "validation failed: " + strings.Join(msg, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Add appends a field violation
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// OrNil returns nil if there are no violations, so it can be returned as an error directly
+func (e *ValidationError) OrNil() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
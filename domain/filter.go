@@ -0,0 +1,140 @@
+package domain
+
+import "strings"
+
+// FilterOp - comparison of a filter condition
+type FilterOp string
+
+const (
+	FilterEq       FilterOp = "eq"       // equal, case insensitive
+	FilterContains FilterOp = "contains" // substring, case insensitive
+	FilterPrefix   FilterOp = "prefix"   // starts with, case insensitive
+	FilterIn       FilterOp = "in"       // equal to one of Values
+	FilterEmpty    FilterOp = "empty"    // NULL or empty string
+	FilterNull     FilterOp = "null"     // NULL only
+)
+
+// CompanyFilterFields - fields which can be used in filter conditions, json names of Company
+var CompanyFilterFields = []string{"id", "name", "code", "country", "countryCode", "website", "phone"}
+
+// Filter - a node of the filter tree: Condition, And or Or
+type Filter interface {
+	isFilter()
+}
+
+// Condition - a leaf of the filter tree
+type Condition struct {
+	Field  string
+	Op     FilterOp
+	Values []string // one value for eq, contains and prefix, several for in, none for empty and null
+	Not    bool
+}
+
+// And matches if all filters match, empty And matches everything
+type And []Filter
+
+// Or matches if any filter matches, empty Or matches nothing
+type Or []Filter
+
+func (Condition) isFilter() {}
+func (And) isFilter()       {}
+func (Or) isFilter()        {}
+
+// IsFilterField reports whether the field can be used in a condition
+func IsFilterField(field string) bool {
+	for i := range CompanyFilterFields {
+		if CompanyFilterFields[i] == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches evaluates the filter in memory with the same semantic as the storage does
+func Matches(f Filter, c *Company) bool {
+	switch f := f.(type) {
+	case nil:
+		return true
+	case And:
+		for i := range f {
+			if !Matches(f[i], c) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for i := range f {
+			if Matches(f[i], c) {
+				return true
+			}
+		}
+		return false
+	case Condition:
+		return f.matches(c) != f.Not
+	}
+	return false
+}
+
+func (cond Condition) matches(c *Company) bool {
+	value := c.SortValue(cond.Field) // phones are compared in E.164
+	set := value != ""
+	if cond.Field == "phone" && set && cond.Op != FilterEmpty && cond.Op != FilterNull {
+		return cond.matchesPhone(value, c.CountryCode)
+	}
+	value = strings.ToLower(value)
+	for _, v := range cond.Values {
+		v = strings.ToLower(v)
+		switch cond.Op {
+		case FilterEq, FilterIn:
+			if value == v {
+				return true
+			}
+		case FilterContains:
+			if strings.Contains(value, v) {
+				return true
+			}
+		case FilterPrefix:
+			if strings.HasPrefix(value, v) {
+				return true
+			}
+		}
+	}
+	switch cond.Op {
+	case FilterEmpty:
+		return !set
+	case FilterNull:
+		return !set && cond.Field != "id" && cond.Field != "name" && cond.Field != "code" // not nullable
+	}
+	return false
+}
+
+// matchesPhone compares phones as the storage does: an international number with the E.164 number or its prefix,
+// a national number with the E.164 number in the company country and anything else by a digit substring
+func (cond Condition) matchesPhone(e164, country string) bool {
+	for _, v := range cond.Values {
+		if cond.Op == FilterPrefix {
+			if prefix, ok := PhoneInternationalPrefix(v); ok {
+				if strings.HasPrefix(e164, prefix) {
+					return true
+				}
+				continue
+			}
+		} else if p, err := ParsePhone(v, ""); err == nil {
+			if p.E164 == e164 {
+				return true
+			}
+			continue
+		} else if national := NationalPhones(v); len(national) > 0 {
+			if national[country] == e164 {
+				return true
+			}
+			if cond.Op != FilterContains {
+				continue
+			}
+		}
+		if digits := PhoneDigits(v); digits != "" && strings.Contains(e164, digits) {
+			return true
+		}
+	}
+	return false
+}
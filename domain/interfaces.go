@@ -1,19 +1,47 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type CompanyWriter interface {
 	Create(ctx context.Context, company *Company) error
-	Update(ctx context.Context, oldName, oldCode string, company *Company) error
+	// Update replaces the company and returns it as it was before, read under the lock of the update
+	Update(ctx context.Context, oldName, oldCode string, company *Company) (Company, error)
+	// Patch changes only the fields of the patch and returns the company before and after the patch,
+	// both are read under the lock of the patch
+	Patch(ctx context.Context, name, code string, patch *CompanyPatch) (before, after Company, err error)
+	// UpdateByID and PatchByID change the company with the id even if it is renamed concurrently
+	UpdateByID(ctx context.Context, id string, company *Company) (Company, error)
+	PatchByID(ctx context.Context, id string, patch *CompanyPatch) (before, after Company, err error)
 }
 
 type CompanyReader interface {
 	Get(ctx context.Context, name, code string) (Company, error)
-	GetMany(ctx context.Context, filter *FilterOptions) ([]Company, error)
+	GetByID(ctx context.Context, id string) (Company, error)
+	GetMany(ctx context.Context, filter *FilterOptions) (CompanyPage, error)
+	// Export calls fn for every company matching the filter, ignoring its limit and cursor.
+	// Companies are streamed from the storage one by one, an error of fn stops the export.
+	Export(ctx context.Context, filter *FilterOptions, fn func(*Company) error) error
+	// Search returns companies ranked by relevance to a free text query, tolerating typos
+	Search(ctx context.Context, options *SearchOptions) ([]SearchResult, error)
+	// History returns revisions of a company newest first, deleted companies keep their history
+	History(ctx context.Context, name, code string) ([]Revision, error)
 }
 
 type CompanyDeleter interface {
+	// Delete hides the company, it can be restored until it is purged
 	Delete(ctx context.Context, name, code string) error
+	DeleteByID(ctx context.Context, id string) error
+	// Restore returns the last deleted company with the name and code back
+	Restore(ctx context.Context, name, code string) (Company, error)
+}
+
+// CompanyBatcher applies many creates, updates and deletes at once
+type CompanyBatcher interface {
+	// Batch returns a result per operation, an error means that nothing was applied
+	Batch(ctx context.Context, ops []BatchOperation, options *BatchOptions) ([]BatchResult, error)
 }
 
 // ICompany - main interface for Company data type for all CRUD operations
@@ -21,12 +49,83 @@ type ICompany interface {
 	CompanyReader
 	CompanyWriter
 	CompanyDeleter
+	CompanyBatcher
+}
+
+// Purger permanently removes companies deleted before the time and returns their number
+type Purger interface {
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+}
+
+// Outbox keeps events of company changes until they are published, see OutboxMessage
+type Outbox interface {
+	// Claim returns the oldest due messages in the order of changes and leases them, so other relays skip them.
+	// A message is not due while an older message of the same company is leased or waits for a retry.
+	Claim(ctx context.Context, limit int) ([]OutboxMessage, error)
+	MarkSent(ctx context.Context, ids ...int64) error
+	// MarkFailed counts a failed attempt to publish the message, it is retried at next or parked if next is zero
+	MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error
+	Stats(ctx context.Context) (OutboxStats, error)
+	// Prune removes messages published before the time and returns their number
+	Prune(ctx context.Context, sentBefore time.Time) (int64, error)
 }
 
 type Publisher interface {
 	Publish(subj string, data []byte) error
 }
 
+// Subscriber delivers messages of the subject to the handler, subscribers of the same group share the messages.
+// A message is redelivered, if the subscriber supports it, when the handler returns an error.
+type Subscriber interface {
+	Subscribe(subj, group string, handler func(msg *Message) error) (Subscription, error)
+}
+
+// Subscription - Drain stops the delivery and waits until the handler processes received messages
+type Subscription interface {
+	Drain() error
+}
+
+// Webhooks - webhook subscriptions and the log of their deliveries
+type Webhooks interface {
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	GetWebhook(ctx context.Context, id string) (Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	UpdateWebhook(ctx context.Context, w *Webhook) error
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// Enqueue adds a pending delivery of the event for every subscribed webhook once and returns their number
+	Enqueue(ctx context.Context, eventID string, eventType EventType, payload []byte) (int64, error)
+	// Due claims pending deliveries whose time has come, other dispatchers don't get them for a while
+	Due(ctx context.Context, limit int) ([]WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, id int64, attempt *DeliveryAttempt) error
+	// Deliveries returns the newest deliveries of the webhook, all of them with an empty status
+	Deliveries(ctx context.Context, webhookID string, status DeliveryStatus, limit int) ([]WebhookDelivery, error)
+	// Replay makes failed deliveries of the webhook pending again, all failed ones without ids
+	Replay(ctx context.Context, webhookID string, ids ...int64) (int64, error)
+}
+
+// CountryCounts - the projection of the number of companies per country code
+type CountryCounts interface {
+	// Apply adds deltas to the counts once per event id and reports whether the event was applied
+	Apply(ctx context.Context, eventID string, deltas map[string]int64) (bool, error)
+	Counts(ctx context.Context) (map[string]int64, error)
+}
+
+// MessagePublisher is implemented by publishers which route or deduplicate events, see PublishMessage
+type MessagePublisher interface {
+	PublishMessage(subj string, msg *Message) error
+}
+
+// BatchPublisher is implemented by publishers which send many messages at once, see PublishMessages
+type BatchPublisher interface {
+	PublishMessages(subj string, msgs []*Message) error
+}
+
 type CountryResolver interface {
 	Resolve(ip string) (string, error)
 }
+
+// TokenVerifier - checks a bearer token and returns its verified claims
+type TokenVerifier interface {
+	Verify(token string) (Claims, error)
+}
@@ -1,23 +1,76 @@
 package domain
 
+import "time"
+
 type Company struct {
-	Name    string `json:"name"`
-	Code    string `json:"code"`
-	Country string `json:"country"`
-	Website string `json:"website"`
-	Phone   string `json:"phone"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Code        string `json:"code"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"` // ISO 3166-1 alpha-2
+	Website     string `json:"website"`
+	Phone       Phone  `json:"phone"`
+	Version     int64  `json:"version"` // incremented on every change
+	// DeletedAt - time of the soft delete, only deleted companies listed with IncludeDeleted have it
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// ExpectedVersion - a version of a company from If-Match, without ID a version of any company is expected
+type ExpectedVersion struct {
+	ID      string
+	Version int64
+}
+
+// ExpectedVersions - versions of a company from If-Match, a change is applied only if one of them is current
+type ExpectedVersions []ExpectedVersion
+
+// Allows reports whether the version of the company is expected
+func (v ExpectedVersions) Allows(id string, version int64) bool {
+	for i := range v {
+		if v[i].Version == version && (v[i].ID == "" || v[i].ID == id) {
+			return true
+		}
+	}
+	return false
 }
 
 type FilterOptions struct {
-	Limit  *int
-	Params map[string]string
+	Limit     *int
+	Where     Filter // nil matches all companies
+	Sort      []SortField
+	After     *Cursor // keyset position, the page starts after it
+	WithTotal bool    // count all companies matching Where
+	// IncludeDeleted lists soft deleted companies too
+	IncludeDeleted bool
 }
 
 type EventType string
 
+// Event - a change of a company, it is published as a CloudEvent, see EventFormat
 type Event struct {
-	Type    EventType `json:"type"`
-	Subject Company   `json:"subject"`
-	OldName string    `json:"oldName"`
-	OldCode string    `json:"oldCode"`
+	ID        string    `json:"id"` // unique, it is kept when the event is published again
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	CompanyID string    `json:"companyId"`
+	Subject   Company   `json:"subject"` // the company after the change, or before it for delete
+	Before    *Company  `json:"before,omitempty"`
+	OldName   string    `json:"oldName"`
+	OldCode   string    `json:"oldCode"`
+	// ChangedFields - json names of the changed fields of a patch
+	ChangedFields []string `json:"changedFields,omitempty"`
+	Actor         string   `json:"actor,omitempty"`
+	RequestID     string   `json:"requestId,omitempty"`
+}
+
+// Revision - a recorded change of a company, Before is nil for create and After is nil for delete
+type Revision struct {
+	ID        int64     `json:"id"`
+	CompanyID string    `json:"companyId"`
+	Operation EventType `json:"operation"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip"`
+	RequestID string    `json:"requestId,omitempty"`
+	Before    *Company  `json:"before"`
+	After     *Company  `json:"after"`
+	CreatedAt time.Time `json:"createdAt"`
 }
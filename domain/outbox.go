@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// NewEvent describes a change of a company by the caller of ctx, before is nil for create and after is nil for delete.
+// A restored company appears again like a created one, so the event of a restore never has before.
+func NewEvent(ctx context.Context, op EventType, before, after *Company) Event {
+	if op == RestoreCompany {
+		before = nil
+	}
+	e := Event{ID: NewID(), Type: op, Time: time.Now().UTC(), Before: before}
+	e.Actor, _ = ctx.Value(CtxUserSubjectKey).(string)
+	if e.Actor == "" {
+		e.Actor = "anonymous"
+	}
+	e.RequestID, _ = ctx.Value(CtxRequestIDKey).(string)
+	if before != nil {
+		e.OldName, e.OldCode = before.Name, before.Code
+	}
+	switch {
+	case after != nil:
+		e.CompanyID, e.Subject = after.ID, *after
+	case before != nil:
+		e.CompanyID, e.Subject = before.ID, *before
+	}
+	if op == UpdateCompany && before != nil && after != nil {
+		e.ChangedFields = ChangedFields(before, after)
+	}
+	return e
+}
+
+// OutboxMessage - an event stored in the transaction of the company change
+type OutboxMessage struct {
+	ID        int64
+	Event     Event
+	CreatedAt time.Time
+	Attempts  int
+}
+
+// OutboxStats - unpublished events, Oldest is nil when all events are published. Parked events are not
+// published anymore, they are not pending.
+type OutboxStats struct {
+	Pending int64
+	Parked  int64
+	Oldest  *time.Time
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNewEvent(t *testing.T) {
+	before := &Company{ID: "1", Name: "old", Code: "c", Country: "Ukraine", CountryCode: "UA"}
+	after := &Company{ID: "1", Name: "new", Code: "c", Country: "Ukraine", CountryCode: "UA"}
+
+	ctx := context.WithValue(context.Background(), CtxUserSubjectKey, "alice")
+	ctx = context.WithValue(ctx, CtxRequestIDKey, "req-1")
+	e := NewEvent(ctx, UpdateCompany, before, after)
+	if e.CompanyID != "1" || e.Subject.Name != "new" || e.OldName != "old" || e.OldCode != "c" ||
+		!IsValidID(e.ID) || e.Time.IsZero() || e.Actor != "alice" || e.RequestID != "req-1" {
+		t.Errorf("unexpected update event %+v", e)
+	}
+	if !reflect.DeepEqual(e.ChangedFields, []string{"name"}) {
+		t.Errorf("want changed name but got %v", e.ChangedFields)
+	}
+
+	e = NewEvent(ctx, DeleteCompany, before, nil)
+	if e.CompanyID != "1" || e.Subject.Name != "old" || e.ChangedFields != nil {
+		t.Errorf("unexpected delete event %+v", e)
+	}
+
+	deleted := *before
+	deleted.DeletedAt = &e.Time
+	e = NewEvent(ctx, RestoreCompany, &deleted, after)
+	if e.CompanyID != "1" || e.Before != nil || e.OldName != "" || e.Subject.Name != "new" {
+		t.Errorf("restore event must be the same as without the deleted snapshot but got %+v", e)
+	}
+
+	e = NewEvent(ctx, CreateCompany, nil, after)
+	if e.CompanyID != "1" || e.Subject.Name != "new" || e.OldName != "" {
+		t.Errorf("unexpected create event %+v", e)
+	}
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CompanySortFields - fields which can be used in FilterOptions.Sort, json names of Company
+var CompanySortFields = []string{"id", "name", "code", "country", "countryCode", "website", "phone"}
+
+// SortField - one sort key, companies are always additionally sorted by id to make the order stable
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses "name,-country" into sort fields, "-" means descending order
+func ParseSort(s string) ([]SortField, error) {
+	var res []SortField
+	var v ValidationError
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field := SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !isSortField(field.Field) {
+			v.Add("sort", fmt.Sprintf("unknown field %q, use one of %s", field.Field, strings.Join(CompanySortFields, ", ")))
+			continue
+		}
+		res = append(res, field)
+	}
+	return res, v.OrNil()
+}
+
+func isSortField(field string) bool {
+	for i := range CompanySortFields {
+		if CompanySortFields[i] == field {
+			return true
+		}
+	}
+	return false
+}
+
+// SortString is the inverse of ParseSort
+func SortString(sort []SortField) string {
+	parts := make([]string, 0, len(sort))
+	for _, f := range sort {
+		if f.Desc {
+			parts = append(parts, "-"+f.Field)
+		} else {
+			parts = append(parts, f.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// SortValue returns the value of a sort field
+func (c *Company) SortValue(field string) string {
+	switch field {
+	case "id":
+		return c.ID
+	case "name":
+		return c.Name
+	case "code":
+		return c.Code
+	case "country":
+		return c.Country
+	case "countryCode":
+		return c.CountryCode
+	case "website":
+		return c.Website
+	case "phone":
+		return c.Phone.E164
+	}
+	return ""
+}
+
+// Cursor - position after the last company of a page: its sort values and id
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"id"`
+}
+
+// NewCursor returns a cursor pointing after the company in the given sort order
+func NewCursor(sort []SortField, last *Company) *Cursor {
+	c := Cursor{Sort: SortString(sort), Values: make([]string, 0, len(sort)), ID: last.ID}
+	for _, f := range sort {
+		c.Values = append(c.Values, last.SortValue(f.Field))
+	}
+	return &c
+}
+
+// Encode returns the opaque form of the cursor
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses an opaque cursor, it must be used with the same sort order it was issued for.
+// Ids in the cursor are checked, so a crafted cursor is a validation error and never reaches the database.
+func DecodeCursor(s string, sort []SortField) (*Cursor, error) {
+	invalid := &ValidationError{Fields: []FieldError{{Field: "cursor", Message: "is invalid or does not match the sort order"}}}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != SortString(sort) || len(c.Values) != len(sort) {
+		return nil, invalid
+	}
+	if !IsValidID(c.ID) {
+		return nil, invalid
+	}
+	for i, f := range sort {
+		if f.Field == "id" && !IsValidID(c.Values[i]) {
+			return nil, invalid
+		}
+	}
+	return &c, nil
+}
+
+// CompanyPage - one page of companies
+type CompanyPage struct {
+	Items      []Company `json:"items"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+	Total      *int      `json:"total,omitempty"`
+}
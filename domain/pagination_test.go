@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	sort := []SortField{{Field: "name"}, {Field: "id", Desc: true}}
+	last := &Company{ID: "5f0c6d1e-8a3b-4c2d-9e7f-1a2b3c4d5e6f", Name: "Acme"}
+	cursor, err := DecodeCursor(NewCursor(sort, last).Encode(), sort)
+	if err != nil || cursor.ID != last.ID || cursor.Values[0] != "Acme" || cursor.Values[1] != last.ID {
+		t.Fatalf("want the cursor of %s but got %+v: %v", last.ID, cursor, err)
+	}
+
+	invalid := map[string]string{
+		"not base64":        "%%%",
+		"not json":          base64.RawURLEncoding.EncodeToString([]byte("{")),
+		"other sort":        NewCursor([]SortField{{Field: "code"}}, last).Encode(),
+		"invalid id":        (&Cursor{Sort: "name,-id", Values: []string{"Acme", last.ID}, ID: "1' OR '1'='1"}).Encode(),
+		"invalid sort id":   (&Cursor{Sort: "name,-id", Values: []string{"Acme", "not-uuid"}, ID: last.ID}).Encode(),
+		"missing sort keys": (&Cursor{Sort: "name,-id", Values: []string{"Acme"}, ID: last.ID}).Encode(),
+	}
+	for name, s := range invalid {
+		var v *ValidationError
+		if _, err := DecodeCursor(s, sort); !errors.As(err, &v) {
+			t.Errorf("%s: want a validation error but got %v", name, err)
+		}
+	}
+}
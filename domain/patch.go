@@ -0,0 +1,79 @@
+package domain
+
+// CompanyPatch - fields of a company to change, nil fields are left as is.
+// An empty value clears an optional field and fails validation of a required one.
+type CompanyPatch struct {
+	Name    *string
+	Code    *string
+	Country *string
+	Website *string
+	Phone   *string
+}
+
+// PatchFields - json names of the company fields a patch can change
+var PatchFields = []string{"name", "code", "country", "website", "phone"}
+
+// Set changes the field with the json name, it returns false for fields which can't be patched
+func (p *CompanyPatch) Set(field string, value *string) bool {
+	switch field {
+	case "name":
+		p.Name = value
+	case "code":
+		p.Code = value
+	case "country":
+		p.Country = value
+	case "website":
+		p.Website = value
+	case "phone":
+		p.Phone = value
+	default:
+		return false
+	}
+	return true
+}
+
+// Apply changes the company by the patch, then normalizes and validates the result like a full update
+func (p *CompanyPatch) Apply(c *Company) error {
+	if p.Name != nil {
+		c.Name = *p.Name
+	}
+	if p.Code != nil {
+		c.Code = *p.Code
+	}
+	if p.Country != nil {
+		c.Country = *p.Country
+		c.CountryCode = "" // resolved again from the new country
+	}
+	if p.Website != nil {
+		c.Website = *p.Website
+	}
+	if p.Phone != nil {
+		c.Phone = Phone{Original: *p.Phone}
+	}
+	c.Normalize()
+	return c.Validate()
+}
+
+// ChangedFields returns json names of the fields which differ, a phone is compared by its normalized form
+func ChangedFields(before, after *Company) []string {
+	var changed []string
+	if before.Name != after.Name {
+		changed = append(changed, "name")
+	}
+	if before.Code != after.Code {
+		changed = append(changed, "code")
+	}
+	if before.Country != after.Country {
+		changed = append(changed, "country")
+	}
+	if before.CountryCode != after.CountryCode {
+		changed = append(changed, "countryCode")
+	}
+	if before.Website != after.Website {
+		changed = append(changed, "website")
+	}
+	if before.Phone.Original != after.Phone.Original || before.Phone.E164 != after.Phone.E164 {
+		changed = append(changed, "phone")
+	}
+	return changed
+}
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompanyPatchApply(t *testing.T) {
+	before := Company{Name: "Acme", Code: "ACM", Country: "Ukraine", Website: "https://acme.example", Phone: Phone{Original: "+380 44 123 45 67"}}
+	before.Normalize()
+
+	country, empty := "cyprus", ""
+	after := before
+	patch := CompanyPatch{Country: &country, Website: &empty}
+	if err := patch.Apply(&after); err != nil {
+		t.Fatalf("patch should be valid but got %s", err.Error())
+	}
+	if after.Name != "Acme" || after.Country != "Cyprus" || after.CountryCode != "CY" || after.Website != "" {
+		t.Errorf("want Acme in Cyprus without website but got %+v", after)
+	}
+	if after.Phone.Original != before.Phone.Original {
+		t.Errorf("phone should be left as is but got %+v", after.Phone)
+	}
+	want := []string{"country", "countryCode", "website"}
+	if changed := ChangedFields(&before, &after); !reflect.DeepEqual(changed, want) {
+		t.Errorf("want changed fields %v but got %v", want, changed)
+	}
+
+	cleared := before
+	patch = CompanyPatch{Name: &empty}
+	if err := patch.Apply(&cleared); !errors.Is(err, ErrValidation) {
+		t.Errorf("want validation error when the name is cleared but got %v", err)
+	}
+
+	national := "044 123 45 67"
+	moved := before
+	patch = CompanyPatch{Country: &country, Phone: &national}
+	if err := patch.Apply(&moved); !errors.Is(err, ErrValidation) {
+		t.Errorf("want validation error for a national phone of the old country but got %v", err)
+	}
+
+	same := before
+	name := " Acme "
+	patch = CompanyPatch{Name: &name}
+	if err := patch.Apply(&same); err != nil {
+		t.Fatalf("patch should be valid but got %s", err.Error())
+	}
+	if changed := ChangedFields(&before, &same); len(changed) != 0 {
+		t.Errorf("want no changes but got %v", changed)
+	}
+}
@@ -0,0 +1,218 @@
+package domain
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data/phone_plans.csv
+var phonePlansCSV string
+
+// Phone - a phone number as it was entered and its normalized forms
+type Phone struct {
+	Original           string `json:"original"`
+	E164               string `json:"e164"`
+	National           string `json:"national"` // national significant number, without the calling code and trunk prefix
+	CountryCallingCode string `json:"countryCallingCode"`
+}
+
+// UnmarshalJSON accepts a phone as a plain string or as an object
+func (p *Phone) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		*p = Phone{Original: raw}
+		return nil
+	}
+	type phone Phone
+	var obj phone
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("phone must be a string or an object: %w", err)
+	}
+	if obj.Original == "" {
+		obj.Original = obj.E164
+	}
+	*p = Phone{Original: obj.Original}
+	return nil
+}
+
+// IsEmpty reports whether no phone was entered
+func (p Phone) IsEmpty() bool {
+	return p.Original == "" && p.E164 == ""
+}
+
+// numberingPlan - the national numbering plan of a country
+type numberingPlan struct {
+	alpha2      string
+	callingCode string
+	trunk       string // national prefix dialed before the national significant number
+	minLength   int    // of the national significant number
+	maxLength   int
+}
+
+var (
+	plansOnce     sync.Once
+	plansByAlpha2 map[string]*numberingPlan
+	plansByCode   map[string][]*numberingPlan // several countries can share a calling code, e.g. +1
+)
+
+func loadPlans() {
+	records := readCSV(phonePlansCSV)
+	plansByAlpha2 = make(map[string]*numberingPlan, len(records))
+	plansByCode = make(map[string][]*numberingPlan)
+	for _, r := range records {
+		minLength, err1 := strconv.Atoi(r[3])
+		maxLength, err2 := strconv.Atoi(r[4])
+		if err1 != nil || err2 != nil {
+			panic("invalid numbering plan of " + r[0])
+		}
+		plan := &numberingPlan{alpha2: r[0], callingCode: r[1], trunk: r[2], minLength: minLength, maxLength: maxLength}
+		plansByAlpha2[plan.alpha2] = plan
+		plansByCode[plan.callingCode] = append(plansByCode[plan.callingCode], plan)
+	}
+}
+
+var phoneFormatReplacer = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "", " ", "")
+
+// ParsePhone parses a phone in international (+ or 00 prefix) or national format.
+// The country (ISO 3166 alpha-2) is required for national numbers and selects the plan for shared calling codes.
+func ParsePhone(raw, country string) (Phone, error) {
+	plansOnce.Do(loadPlans)
+	number := phoneFormatReplacer.Replace(strings.TrimSpace(raw))
+	if number == "" {
+		return Phone{}, errors.New("phone is empty")
+	}
+	international := false
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number, international = number[1:], true
+	case strings.HasPrefix(number, "00"):
+		number, international = number[2:], true
+	}
+	if !isDigits(number) {
+		return Phone{}, errors.New("phone may contain only digits and formatting characters")
+	}
+
+	plan := plansByAlpha2[strings.ToUpper(country)]
+	var nsn string
+	if international {
+		plan, nsn = splitCallingCode(number, plan)
+		if plan == nil {
+			return Phone{}, errors.New("unknown country calling code")
+		}
+	} else {
+		if plan == nil {
+			return Phone{}, errors.New("national number requires a known country, use the +<country code> format")
+		}
+		nsn = strings.TrimPrefix(number, plan.trunk)
+	}
+	if len(nsn) < plan.minLength || len(nsn) > plan.maxLength {
+		if plan.minLength == plan.maxLength {
+			return Phone{}, fmt.Errorf("number for +%s must have %d digits after the country code", plan.callingCode, plan.minLength)
+		}
+		return Phone{}, fmt.Errorf("number for +%s must have %d to %d digits after the country code",
+			plan.callingCode, plan.minLength, plan.maxLength)
+	}
+	return Phone{
+		Original:           strings.TrimSpace(raw),
+		E164:               "+" + plan.callingCode + nsn,
+		National:           nsn,
+		CountryCallingCode: plan.callingCode,
+	}, nil
+}
+
+// splitCallingCode finds the calling code prefix of an international number, preferred is the plan of the company country
+func splitCallingCode(number string, preferred *numberingPlan) (*numberingPlan, string) {
+	if preferred != nil && strings.HasPrefix(number, preferred.callingCode) {
+		return preferred, number[len(preferred.callingCode):]
+	}
+	for l := 1; l <= 3 && l < len(number); l++ {
+		if plans, ok := plansByCode[number[:l]]; ok {
+			return plans[0], number[l:]
+		}
+	}
+	return nil, ""
+}
+
+// RestorePhone builds a phone from stored values, national forms are derived from the E.164 number
+func RestorePhone(original, e164, country string) Phone {
+	if e164 != "" {
+		if p, err := ParsePhone(e164, country); err == nil {
+			p.Original = original
+			return p
+		}
+	}
+	return Phone{Original: original, E164: e164}
+}
+
+// NationalPhones parses a national number with the plan of every country which accepts it,
+// the result maps alpha-2 codes to E.164 numbers. International and partial numbers give nothing.
+func NationalPhones(raw string) map[string]string {
+	plansOnce.Do(loadPlans)
+	if _, ok := PhoneInternationalPrefix(raw); ok {
+		return nil
+	}
+	phones := make(map[string]string)
+	for alpha2 := range plansByAlpha2 {
+		if p, err := ParsePhone(raw, alpha2); err == nil {
+			phones[alpha2] = p.E164
+		}
+	}
+	return phones
+}
+
+// SortedCountries returns the alpha-2 codes of NationalPhones in order, so queries built from them are stable
+func SortedCountries(phones map[string]string) []string {
+	countries := make([]string, 0, len(phones))
+	for alpha2 := range phones {
+		countries = append(countries, alpha2)
+	}
+	sort.Strings(countries)
+	return countries
+}
+
+// PhoneInternationalPrefix returns "+" and digits of a (partial) number in international format, e.g. "00380 44"
+// gives "+38044". A national number or a query with other characters is not international.
+func PhoneInternationalPrefix(raw string) (string, bool) {
+	number := phoneFormatReplacer.Replace(strings.TrimSpace(raw))
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	default:
+		return "", false
+	}
+	if !isDigits(number) {
+		return "", false
+	}
+	return "+" + number, true
+}
+
+// PhoneDigits returns only digits of a phone search query, so "+380 (44) 123" and "38044123" are the same
+func PhoneDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePhone(t *testing.T) {
+	cases := []struct {
+		raw      string
+		country  string
+		e164     string
+		national string
+		code     string
+	}{
+		{raw: "+380 (44) 123-45-67", country: "UA", e164: "+380441234567", national: "441234567", code: "380"},
+		{raw: "044 123 45 67", country: "UA", e164: "+380441234567", national: "441234567", code: "380"},
+		{raw: "00357 22 123456", country: "", e164: "+35722123456", national: "22123456", code: "357"},
+		{raw: "(401) 555-0123", country: "US", e164: "+14015550123", national: "4015550123", code: "1"},
+		{raw: "+1 416 555 0123", country: "CA", e164: "+14165550123", national: "4165550123", code: "1"},
+		{raw: "020 7946 0018", country: "GB", e164: "+442079460018", national: "2079460018", code: "44"},
+		{raw: "06 1234 5678", country: "IT", e164: "+390612345678", national: "0612345678", code: "39"},
+	}
+	for _, c := range cases {
+		p, err := ParsePhone(c.raw, c.country)
+		if err != nil {
+			t.Errorf("%s: %s", c.raw, err.Error())
+			continue
+		}
+		if p.E164 != c.e164 || p.National != c.national || p.CountryCallingCode != c.code {
+			t.Errorf("%s: want %s, %s, %s but got %+v", c.raw, c.e164, c.national, c.code, p)
+		}
+	}
+	for _, raw := range []string{"", "+1401235566", "044 123", "0441234567", "+38044abc", "+999 123456"} {
+		if p, err := ParsePhone(raw, ""); err == nil {
+			t.Errorf("%q should not be parsed but got %+v", raw, p)
+		}
+	}
+}
+
+func TestPhoneJSON(t *testing.T) {
+	var c Company
+	if err := json.Unmarshal([]byte(`{"phone":"+380 44 123 45 67"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Phone.Original != "+380 44 123 45 67" {
+		t.Errorf("want phone from string but got %+v", c.Phone)
+	}
+	if err := json.Unmarshal([]byte(`{"phone":{"e164":"+380441234567"}}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Phone.Original != "+380441234567" {
+		t.Errorf("want phone from object but got %+v", c.Phone)
+	}
+}
+
+func TestMatchesPhone(t *testing.T) {
+	ua := &Company{CountryCode: "UA", Phone: Phone{Original: "044 123 45 67", E164: "+380441234567"}}
+	cy := &Company{CountryCode: "CY", Phone: Phone{Original: "+357 22 123456", E164: "+35722123456"}}
+	cases := []struct {
+		cond Condition
+		want []bool // ua, cy
+	}{
+		{Condition{Field: "phone", Op: FilterEq, Values: []string{"+380 (44) 123-45-67"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterEq, Values: []string{"044 123 45 67"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterIn, Values: []string{"0441234567", "00357 22 123456"}}, []bool{true, true}},
+		{Condition{Field: "phone", Op: FilterContains, Values: []string{"044 123 45 67"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterContains, Values: []string{"45 67"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterContains, Values: []string{"22 12"}}, []bool{false, true}},
+		{Condition{Field: "phone", Op: FilterPrefix, Values: []string{"+380 44"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterPrefix, Values: []string{"0035"}}, []bool{false, true}},
+		{Condition{Field: "phone", Op: FilterPrefix, Values: []string{"4567"}}, []bool{true, false}},
+		{Condition{Field: "phone", Op: FilterEq, Values: []string{"22 123456"}}, []bool{false, true}},
+		{Condition{Field: "phone", Op: FilterNull, Not: true}, []bool{true, true}},
+	}
+	for _, c := range cases {
+		for i, company := range []*Company{ua, cy} {
+			if got := Matches(c.cond, company); got != c.want[i] {
+				t.Errorf("%+v of %s: want %t but got %t", c.cond, company.Phone.E164, c.want[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package domain
+
+// Message - an encoded event, ID is unique for the event and is kept when the event is published again.
+// Key is the id of the company, brokers with partitions keep the order of messages with the same key.
+type Message struct {
+	ID     string
+	Type   EventType
+	Key    string
+	Header map[string]string
+	Data   []byte
+}
+
+// PublishMessage publishes msg with the message publisher if p is one, other publishers get only its data
+// without headers
+func PublishMessage(p Publisher, subj string, msg *Message) error {
+	if mp, ok := p.(MessagePublisher); ok {
+		return mp.PublishMessage(subj, msg)
+	}
+	return p.Publish(subj, msg.Data)
+}
+
+// PublishMessages publishes msgs at once with the batch publisher if p is one, other publishers get them
+// one by one and the first error is returned
+func PublishMessages(p Publisher, subj string, msgs []*Message) error {
+	if bp, ok := p.(BatchPublisher); ok {
+		return bp.PublishMessages(subj, msgs)
+	}
+	var first error
+	for _, msg := range msgs {
+		if err := PublishMessage(p, subj, msg); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
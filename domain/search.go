@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// MaxSearchTerms - words of a search query after this number are ignored
+const MaxSearchTerms = 10
+
+// SearchOptions - a full text search request
+type SearchOptions struct {
+	Query string
+	Limit int
+	Where Filter // nil matches all companies
+}
+
+// SearchResult - a found company and its relevance
+type SearchResult struct {
+	Company Company `json:"company"`
+	Score   float64 `json:"score"` // from 0 to 1, more relevant first
+	// Highlights - HTML escaped values of matched fields with <em> around matched words, by json field name
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// SearchTerms splits a query into lower case words of letters and digits
+func SearchTerms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	seen := make(map[string]bool, len(words))
+	for _, w := range words {
+		if !seen[w] && len(terms) < MaxSearchTerms {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// matchesTerm tolerates the same differences as the search: prefixes and one typo in longer words
+func matchesTerm(word, term string) bool {
+	w, t := []rune(word), []rune(term)
+	switch {
+	case strings.HasPrefix(word, term):
+		return true
+	case len(w) >= 3 && strings.HasPrefix(term, word): // "corp" for "corporation"
+		return true
+	case len(t) >= 4 && len(w) >= len(t)-1:
+		if len(w) > len(t) {
+			w = w[:len(t)] // a typo in the beginning of a longer word
+		}
+		return levenshtein(w, t) <= 1
+	}
+	return false
+}
+
+// Highlight wraps words of text matching any term in <em>, the rest of text is HTML escaped
+func Highlight(text string, terms []string) (string, bool) {
+	var b strings.Builder
+	found := false
+	runes := []rune(text)
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for i := 0; i < len(runes); {
+		j := i
+		word := isWord(runes[i])
+		for j < len(runes) && isWord(runes[j]) == word {
+			j++
+		}
+		part := string(runes[i:j])
+		matched := false
+		if word {
+			lower := strings.ToLower(part)
+			for _, t := range terms {
+				if matchesTerm(lower, t) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			found = true
+			b.WriteString("<em>" + html.EscapeString(part) + "</em>")
+		} else {
+			b.WriteString(html.EscapeString(part))
+		}
+		i = j
+	}
+	return b.String(), found
+}
+
+// HighlightCompany returns highlighted searchable fields which match any term
+func HighlightCompany(c *Company, terms []string) map[string]string {
+	highlights := make(map[string]string)
+	for field, value := range map[string]string{"name": c.Name, "code": c.Code, "website": c.Website, "country": c.Country} {
+		if h, ok := Highlight(value, terms); ok {
+			highlights[field] = h
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	got := SearchTerms("  Acme, acme-Corporation! Київ ")
+	want := []string{"acme", "corporation", "київ"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v but got %v", want, got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	testCases := []struct {
+		text  string
+		terms []string
+		want  string
+		found bool
+	}{
+		{"Acme Corp", []string{"acme", "corporation"}, "<em>Acme</em> <em>Corp</em>", true},
+		{"https://acme.example.com", []string{"acme"}, "https://<em>acme</em>.example.com", true},
+		{"Acme <Corp>", []string{"acmee"}, "<em>Acme</em> &lt;Corp&gt;", true},
+		{"Globex", []string{"acme"}, "Globex", false},
+		{"Co", []string{"corporation"}, "Co", false},
+	}
+	for _, c := range testCases {
+		got, found := Highlight(c.text, c.terms)
+		if got != c.want || found != c.found {
+			t.Errorf("%s: want %q, %v but got %q, %v", c.text, c.want, c.found, got, found)
+		}
+	}
+}
+
+func TestHighlightCompany(t *testing.T) {
+	c := &Company{Name: "Acme Corp", Code: "AC1", Country: "Ukraine", Website: "https://globex.com"}
+	got := HighlightCompany(c, SearchTerms("acme ukraine"))
+	want := map[string]string{"name": "<em>Acme</em> Corp", "country": "<em>Ukraine</em>"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field limits match the companies table
+const (
+	MaxNameLength    = 100
+	MaxCodeLength    = 100
+	MaxCountryLength = 100
+	MaxWebsiteLength = 100
+	MaxPhoneLength   = 32
+)
+
+// Normalize trims all fields, resolves the country (a name with possible typos or a code)
+// to its ISO 3166 alpha-2 code and display name and parses the phone against the country numbering plan.
+// Unknown countries and invalid phones are left as is for Validate.
+func (c *Company) Normalize() {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Code = strings.TrimSpace(c.Code)
+	c.Country = strings.TrimSpace(c.Country)
+	c.CountryCode = strings.ToUpper(strings.TrimSpace(c.CountryCode))
+	c.Website = strings.TrimSpace(c.Website)
+
+	country := c.Country
+	if country == "" {
+		country = c.CountryCode
+	}
+	if found, ok := MatchCountry(country); ok {
+		c.Country = found.Name
+		c.CountryCode = found.Alpha2
+	}
+
+	c.Phone.Original = strings.TrimSpace(c.Phone.Original)
+	if c.Phone.Original == "" {
+		c.Phone = Phone{}
+	} else if phone, err := ParsePhone(c.Phone.Original, c.CountryCode); err == nil {
+		c.Phone = phone
+	}
+}
+
+// Validate checks all fields of the company and returns *ValidationError with every violation
+func (c *Company) Validate() error {
+	var v ValidationError
+	checkRequired(&v, "name", c.Name, MaxNameLength)
+	checkRequired(&v, "code", c.Code, MaxCodeLength)
+	if checkRequired(&v, "country", c.Country, MaxCountryLength) {
+		if found, ok := LookupCountry(c.Country); !ok {
+			v.Add("country", "must be an ISO 3166 country name or code")
+		} else if c.CountryCode != "" && c.CountryCode != found.Alpha2 {
+			v.Add("countryCode", "does not match country "+found.Name)
+		}
+	}
+	if checkLength(&v, "website", c.Website, MaxWebsiteLength) && c.Website != "" {
+		if u, err := url.Parse(c.Website); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.Add("website", "must be an absolute http or https URL")
+		}
+	}
+	if checkLength(&v, "phone", c.Phone.Original, MaxPhoneLength) && c.Phone.Original != "" {
+		if _, err := ParsePhone(c.Phone.Original, c.CountryCode); err != nil {
+			v.Add("phone", err.Error())
+		}
+	}
+	return v.OrNil()
+}
+
+func checkRequired(v *ValidationError, field, value string, max int) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "is required")
+		return false
+	}
+	return checkLength(v, field, value, max)
+}
+
+func checkLength(v *ValidationError, field, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		v.Add(field, "must be at most "+strconv.Itoa(max)+" characters")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompanyValidate(t *testing.T) {
+	valid := Company{Name: "Acme", Code: "ACM", Country: "UA", Website: "https://acme.example", Phone: Phone{Original: "+380441234567"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("company should be valid but got %s", err.Error())
+	}
+	byName := Company{Name: "Acme", Code: "ACM", Country: "france"}
+	if err := byName.Validate(); err != nil {
+		t.Errorf("country name should be accepted but got %s", err.Error())
+	}
+
+	invalid := Company{
+		Code:    strings.Repeat("c", MaxCodeLength+1),
+		Country: "Atlantis",
+		Website: "acme",
+		Phone:   Phone{Original: "+380 44 123"},
+	}
+	err := invalid.Validate()
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("want validation error but got %v", err)
+	}
+	var v *ValidationError
+	errors.As(err, &v)
+	want := []string{"name", "code", "country", "website", "phone"}
+	if len(v.Fields) != len(want) {
+		t.Fatalf("want violations of %v but got %v", want, v.Fields)
+	}
+	for i := range want {
+		if v.Fields[i].Field != want[i] {
+			t.Errorf("want violation of %s but got %s", want[i], v.Fields[i].Field)
+		}
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	valid := Webhook{URL: " https://partner.example/hook ", EventTypes: []EventType{CreateCompany}, Secret: "0123456789abcdef"}
+	if err := valid.Validate(); err != nil || valid.URL != "https://partner.example/hook" {
+		t.Errorf("webhook should be valid but got %v", err)
+	}
+	if !valid.Accepts(CreateCompany) || valid.Accepts(DeleteCompany) {
+		t.Errorf("webhook should accept create events only")
+	}
+
+	invalid := Webhook{URL: "partner.example", EventTypes: []EventType{"rename"}, Secret: "short"}
+	var v *ValidationError
+	if err := invalid.Validate(); !errors.As(err, &v) {
+		t.Fatalf("want validation error but got %v", err)
+	}
+	want := []string{"url", "eventTypes", "secret"}
+	if len(v.Fields) != len(want) {
+		t.Fatalf("want violations of %v but got %v", want, v.Fields)
+	}
+	for i := range want {
+		if v.Fields[i].Field != want[i] {
+			t.Errorf("want violation of %s but got %s", want[i], v.Fields[i].Field)
+		}
+	}
+}
+
+func TestWebhookValidateRejectsPrivateTargets(t *testing.T) {
+	urls := map[string]bool{
+		"https://partner.example/hook":           true,
+		"https://93.184.216.34/hook":             true,
+		"http://[2606:4700::1111]/hook":          true,
+		"http://localhost:8080/hook":             false,
+		"http://api.localhost./hook":             false,
+		"http://127.0.0.1/hook":                  false,
+		"http://169.254.169.254/latest":          false,
+		"http://10.1.2.3/hook":                   false,
+		"http://172.16.0.1/hook":                 false,
+		"http://192.168.1.1/hook":                false,
+		"http://100.64.0.1/hook":                 false,
+		"http://0.0.0.0/hook":                    false,
+		"http://[::1]/hook":                      false,
+		"http://[fe80::1]/hook":                  false,
+		"http://[fd00::1]/hook":                  false,
+		"http://[::ffff:127.0.0.1]/hook":         false,
+		"http://2130706433/hook":                 false,
+		"http://0x7f.1/hook":                     false,
+		"https://hooks.partner.example:8443/abc": true,
+	}
+	for u, valid := range urls {
+		w := Webhook{URL: u, Secret: "0123456789abcdef"}
+		if err := w.Validate(); (err == nil) != valid {
+			t.Errorf("%s: want valid %v but got %v", u, valid, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MaxWebhookURLLength = 2048
+	MinWebhookSecret    = 16
+)
+
+// WebhookEventTypes - event types a webhook can subscribe to
+var WebhookEventTypes = []EventType{CreateCompany, UpdateCompany, DeleteCompany, RestoreCompany}
+
+// Webhook - a subscription of a partner URL to company events, no EventTypes means all of them.
+// Secret signs the deliveries, it is never returned after the webhook is created.
+type Webhook struct {
+	ID         string      `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"eventTypes"`
+	Secret     string      `json:"secret,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+func (w *Webhook) Validate() error {
+	var v ValidationError
+	w.URL = strings.TrimSpace(w.URL)
+	if checkRequired(&v, "url", w.URL, MaxWebhookURLLength) {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.Add("url", "must be an absolute http or https URL")
+		} else if !IsPublicHost(u.Hostname()) {
+			v.Add("url", "must not point to a private, loopback or link-local address")
+		}
+	}
+	for _, t := range w.EventTypes {
+		if !IsWebhookEventType(t) {
+			v.Add("eventTypes", "unknown event type "+string(t))
+		}
+	}
+	if len(w.Secret) < MinWebhookSecret {
+		v.Add("secret", "must contain at least 16 characters")
+	}
+	return v.OrNil()
+}
+
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // this network
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64 may reach private IPv4 addresses
+}
+
+// IsPublicAddr reports whether webhooks may be delivered to the address. Loopback, private,
+// link-local (169.254.169.254 included), multicast and reserved addresses are not public.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPublicHost rejects literal non-public addresses and localhost names. Other names are checked
+// when the deliveries are dialed because they can resolve to anything.
+func IsPublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return IsPublicAddr(addr)
+	}
+	// top level domains are never numeric, names like 2130706433 or 0x7f.1 are IPv4 addresses
+	// for some resolvers
+	tld := host[strings.LastIndex(host, ".")+1:]
+	return strings.Trim(tld, "0123456789") != "" && !strings.HasPrefix(tld, "0x")
+}
+
+func IsWebhookEventType(t EventType) bool {
+	for _, known := range WebhookEventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports whether the webhook is subscribed to the event type
+func (w *Webhook) Accepts(t EventType) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, accepted := range w.EventTypes {
+		if accepted == t {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed" // all attempts failed, it can be replayed
+)
+
+// WebhookDelivery - an event sent to a webhook, URL and Secret are set for due deliveries only
+type WebhookDelivery struct {
+	ID             int64          `json:"id"`
+	WebhookID      string         `json:"webhookId"`
+	EventID        string         `json:"eventId"`
+	EventType      EventType      `json:"eventType"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	ResponseStatus int            `json:"responseStatus,omitempty"`
+	LastError      string         `json:"lastError,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	NextAttemptAt  *time.Time     `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time     `json:"deliveredAt,omitempty"`
+	Payload        []byte         `json:"-"`
+	URL            string         `json:"-"`
+	Secret         string         `json:"-"`
+}
+
+// DeliveryAttempt - the result of sending a delivery, Next is zero if it must not be retried
+type DeliveryAttempt struct {
+	Status         DeliveryStatus
+	ResponseStatus int
+	Error          string
+	Next           time.Time
+}
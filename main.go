@@ -3,14 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/OleksiiKhanin/companysvc/api"
 	"github.com/OleksiiKhanin/companysvc/config"
+	"github.com/OleksiiKhanin/companysvc/consumer"
 	"github.com/OleksiiKhanin/companysvc/db"
 	"github.com/OleksiiKhanin/companysvc/domain"
 	"github.com/OleksiiKhanin/companysvc/service"
@@ -78,25 +84,233 @@ func initQueue(c *config.QueueConfig) (*nats.Conn, error) {
 	return conn, nil
 }
 
-func startServer(c *config.ServerConfig, iCompany domain.ICompany) {
+// initPublisher creates the publisher of event.driver and returns the resolved driver, the NATS connection
+// is returned by nats and jetstream drivers
+func initPublisher(c *config.QueueConfig) (domain.Publisher, *nats.Conn, string, error) {
+	driver := strings.ToLower(c.Driver)
+	if driver == "" {
+		driver = "nats"
+		if c.JetStream {
+			driver = "jetstream"
+		}
+	}
+	switch driver {
+	case "nats", "jetstream":
+		queue, err := initQueue(c)
+		if err != nil {
+			return nil, nil, driver, err
+		}
+		if driver == "nats" {
+			return service.NewNATSPublisher(queue), queue, driver, nil
+		}
+		publisher, err := service.NewJetStreamPublisher(
+			queue, c.Stream, c.EventChannel, c.AckTimeout, c.PublishRetries, log.StandardLogger(),
+		)
+		return publisher, queue, driver, err
+	case "rest-proxy":
+		if c.RESTProxy.URL == "" {
+			return nil, nil, driver, fmt.Errorf("event.restProxy.url must be defined for the rest-proxy driver")
+		}
+		return service.NewRESTProxyPublisher(c.RESTProxy.URL, c.AckTimeout, c.PublishRetries, log.StandardLogger()), nil, driver, nil
+	case "redis":
+		publisher, err := service.NewRedisPublisher(c.Redis.URL, c.Redis.MaxLen, c.AckTimeout, log.StandardLogger())
+		return publisher, nil, driver, err
+	case "file":
+		publisher, err := service.NewFilePublisher(c.File)
+		return publisher, nil, driver, err
+	case "memory":
+		return service.NewMemoryBus(0), nil, driver, nil
+	}
+	return nil, nil, driver, fmt.Errorf("unknown event driver %q, use nats, jetstream, rest-proxy, redis, file or memory", c.Driver)
+}
+
+// initTrustedProxies parses CIDRs of server.trustedProxies, a single address is a network of itself
+func initTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", proxy, err)
+			}
+			proxy = netip.PrefixFrom(ip, ip.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", proxy, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// initResolver chains the resolvers of loc.resolvers, ipapi only by default, and caches their results
+func initResolver(c *config.LocatorConfig) (domain.CountryResolver, error) {
+	names := c.Resolvers
+	if len(names) == 0 {
+		names = []string{"ipapi"}
+	}
+	resolvers := make([]domain.CountryResolver, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "ipapi":
+			resolvers = append(resolvers, service.GetResolverIPAPI(c.URL, c.RetryAttempt, c.Timeout, log.StandardLogger()))
+		case "mmdb":
+			resolver, err := service.NewMMDBResolver(c.MMDB)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, resolver)
+		case "static":
+			resolver, err := service.NewStaticResolver(c.Country)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, resolver)
+		default:
+			return nil, fmt.Errorf("unknown country resolver %q, use ipapi, mmdb or static", name)
+		}
+	}
+	resolver := resolvers[0]
+	if len(resolvers) > 1 {
+		resolver = service.NewChainResolver(names, resolvers, log.StandardLogger())
+	}
+	if c.CacheTTL > 0 {
+		resolver = service.NewCachedResolver(resolver, c.CacheSize, c.CacheTTL)
+	}
+	return resolver, nil
+}
+
+func initVerifier(c *config.AuthConfig) (domain.TokenVerifier, error) {
+	keys := make([]service.JWTKey, 0, len(c.Keys))
+	for _, k := range c.Keys {
+		key := service.JWTKey{ID: k.ID, Algorithm: strings.ToUpper(k.Algorithm)}
+		switch key.Algorithm {
+		case service.AlgHS256:
+			if k.Secret == "" {
+				return nil, fmt.Errorf("auth key %s: secret must be defined", k.ID)
+			}
+			key.Secret = []byte(k.Secret)
+		case service.AlgRS256:
+			publicKey, err := service.LoadRSAPublicKey(k.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("auth key %s: %w", k.ID, err)
+			}
+			key.PublicKey = publicKey
+		default:
+			return nil, fmt.Errorf("auth key %s: unsupported algorithm %q", k.ID, k.Algorithm)
+		}
+		keys = append(keys, key)
+	}
+	if c.JWKSFile != "" {
+		jwks, err := service.LoadJWKS(c.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwks...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth is enabled but no keys are configured")
+	}
+	return service.NewJWTVerifier(keys, c.Issuer, c.Audience, c.Leeway, !c.AllowNoExpiry, log.StandardLogger()), nil
+}
+
+// initPolicy returns the rules of authz, operations without rules are denied
+func initPolicy(c *config.AuthzConfig) (service.Policy, error) {
+	rules := func(configs []config.PolicyRuleConfig) []service.PolicyRule {
+		res := make([]service.PolicyRule, 0, len(configs))
+		for _, r := range configs {
+			res = append(res, service.PolicyRule{Scopes: r.Scopes, Roles: r.Roles, Countries: r.Countries})
+		}
+		return res
+	}
+	policy := service.Policy{
+		service.OpGet:            rules(c.Get),
+		service.OpGetMany:        rules(c.GetMany),
+		service.OpCreate:         rules(c.Create),
+		service.OpUpdate:         rules(c.Update),
+		service.OpDelete:         rules(c.Delete),
+		service.OpRestore:        rules(c.Restore),
+		service.OpIncludeDeleted: rules(c.IncludeDeleted),
+	}
+	return policy, policy.Validate()
+}
+
+// startConsumer subscribes with the event driver resolved by initPublisher, nats or jetstream
+func startConsumer(c *config.Config, driver string, queue *nats.Conn, storage *sql.DB) (*consumer.Consumer, error) {
+	subject, group := c.Consumer.Subject, c.Consumer.Group
+	var (
+		subscriber domain.Subscriber
+		err        error
+	)
+	if driver == "jetstream" {
+		if subject == "" {
+			subject = c.Event.EventChannel + ".>"
+		}
+		if subscriber, err = service.NewJetStreamSubscriber(queue); err != nil {
+			return nil, err
+		}
+	} else {
+		if subject == "" {
+			subject = c.Event.EventChannel
+		}
+		subscriber = service.NewNATSSubscriber(queue)
+	}
+	if group == "" {
+		group = "country-counts"
+	}
+	opts := []consumer.Option{consumer.WithRetry(c.Consumer.Attempts, c.Consumer.Backoff)}
+	if c.Consumer.DeadLetter != "" {
+		opts = append(opts, consumer.WithDeadLetter(service.NewNATSPublisher(queue), c.Consumer.DeadLetter))
+	}
+	cons := consumer.New(subscriber, subject, group, log.StandardLogger(), opts...)
+	consumer.CountryCounts(cons, db.NewCountryCountsPostgresRepo(storage, log.StandardLogger()))
+	if err := cons.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	return cons, nil
+}
+
+// startAdminServer serves metrics on /debug/vars, they expose the command line and memory stats,
+// so the listener is separate from the API and is not behind its authentication
+func startAdminServer(addr string) {
+	handler := http.NewServeMux()
+	handler.Handle("/debug/vars", expvar.Handler())
+	log.StandardLogger().Infof("Start admin listening at %s", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Errorf("admin server: %s", err.Error())
+	}
+}
+
+// startServer serves the API until ctx is cancelled, then shuts the server down gracefully.
+// It returns an error if the server has failed.
+func startServer(ctx context.Context, c *config.ServerConfig, iCompany domain.ICompany, opts ...api.Option) error {
 	r := mux.NewRouter().UseEncodedPath()
 	api.InitAPI(
 		r.PathPrefix(c.PrefixAPI).Subrouter(),
 		iCompany,
 		log.StandardLogger(),
+		opts...,
 	)
 	server := http.Server{
 		Handler: r,
 		Addr:    c.URL,
 	}
 	log.StandardLogger().Infof("Start listening at %s", c.URL)
-	err := server.ListenAndServe()
-	if err != nil {
-		log.Error(err.Error())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		log.StandardLogger().Infof("Shutdown listening at %s", c.URL)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	log.Fatalln(server.Shutdown(ctx))
+	return server.Shutdown(shutdownCtx)
 }
 
 func main() {
@@ -110,33 +324,172 @@ func main() {
 		log.SetLevel(logLevel)
 	}
 
+	// failed is checked after all other deferred calls, so they run before the exit
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
 	storage, err := initDB(&c.Db)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
 	defer storage.Close()
 
+	// ctx is cancelled on SIGINT or SIGTERM, the server and background loops are stopped before
+	// the deferred closes of the database and nats connections
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+	run := func(fn func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			fn()
+		}()
+	}
+
 	if c.Db.Migrations != "" {
 		if err := db.MigrateSchema(storage, c.Db.Migrations); err != nil {
 			log.Fatalln(err.Error())
 		}
 	}
 
-	queue, err := initQueue(&c.Event)
+	if c.Db.Retention > 0 {
+		interval := c.Db.PurgeInterval
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		purger := db.NewCompanyPostgresPurger(storage, log.StandardLogger())
+		run(func() { service.RunPurge(ctx, purger, c.Db.Retention, interval, log.StandardLogger()) })
+	}
+
+	publisher, queue, driver, err := initPublisher(&c.Event)
 	if err != nil {
-		log.Error(err.Error()) // log error but continue
-	} else {
+		log.Fatalln(err.Error())
+	}
+	if queue != nil {
 		defer queue.Close()
 	}
 
+	format := domain.EventFormat{Source: c.Event.Source}
+	switch strings.ToLower(c.Event.Mode) {
+	case "", "structured":
+	case "binary":
+		if driver == "rest-proxy" {
+			log.Fatalln("binary event mode needs message headers, the rest-proxy driver doesn't support them")
+		}
+		format.Binary = true
+	default:
+		log.Fatalf("unknown event mode %q, use structured or binary", c.Event.Mode)
+	}
+	var webhooks domain.Webhooks
+	if c.Webhooks.Enabled {
+		webhooks = db.NewWebhookPostgresRepo(storage, log.StandardLogger())
+		publisher = service.NewFanoutPublisher(publisher, service.NewWebhookPublisher(webhooks))
+		interval := c.Webhooks.Interval
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		dispatcher := service.NewWebhookDispatcher(
+			webhooks, c.Webhooks.Timeout, c.Webhooks.MaxAttempts, c.Webhooks.RetryWait, log.StandardLogger(),
+		)
+		run(func() { dispatcher.Run(ctx, interval) })
+	}
+	var repoOpts []db.Option
+	if c.Event.Outbox {
+		// events are published by the relay only, the service doesn't publish them directly
+		repoOpts = append(repoOpts, db.WithOutbox())
+		if publisher != nil {
+			interval := c.Event.OutboxInterval
+			if interval <= 0 {
+				interval = time.Second
+			}
+			outbox := db.NewOutboxPostgresRepo(storage, log.StandardLogger())
+			relayPublisher := publisher
+			run(func() {
+				service.RunOutboxRelay(
+					ctx, outbox, relayPublisher, c.Event.EventChannel, format,
+					interval, c.Event.OutboxRetention, c.Event.OutboxMaxAttempts, log.StandardLogger(),
+				)
+			})
+		}
+		publisher = nil
+	}
+
+	resolver, err := initResolver(&c.Loc)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
 	iCompany := service.NewCompanyService(
-		db.NewCompanyPostgresRepo(storage, log.StandardLogger()),
-		queue,
-		service.GetResolverIPAPI(c.Loc.URL, c.Loc.RetryAttempt, log.StandardLogger()),
+		db.NewCompanyPostgresRepo(storage, log.StandardLogger(), repoOpts...),
+		publisher,
+		resolver,
 		c.Event.EventChannel,
+		format,
 		log.StandardLogger(),
 		c.Loc.AllowedCountiesCodes...,
 	)
 
-	startServer(&c.Server, iCompany)
+	if c.Authz.Enabled {
+		policy, err := initPolicy(&c.Authz)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		iCompany = service.NewCompanyAuthorizer(iCompany, policy, log.StandardLogger())
+	}
+
+	opts := []api.Option{
+		api.WithPageSize(c.Server.DefaultPageSize, c.Server.MaxPageSize),
+		api.WithIfMatchRequired(c.Server.RequireIfMatch),
+		api.WithMaxImportSize(c.Server.MaxImportSize),
+	}
+	if c.Authz.Enabled {
+		// only the includeDeleted rules of the authorizer decide who lists deleted companies
+		opts = append(opts, api.WithIncludeDeleted())
+	}
+	if len(c.Server.TrustedProxies) > 0 {
+		proxies, err := initTrustedProxies(c.Server.TrustedProxies)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		opts = append(opts, api.WithTrustedProxies(proxies))
+	}
+	if c.Auth.Enabled {
+		verifier, err := initVerifier(&c.Auth)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		opts = append(opts, api.WithAuth(verifier, c.Auth.ReadScopes, c.Auth.WriteScopes))
+	}
+	if webhooks != nil {
+		opts = append(opts, api.WithWebhooks(webhooks, c.Webhooks.ManageScopes))
+	}
+
+	var cons *consumer.Consumer
+	if c.Consumer.Enabled && queue == nil {
+		log.Warnf("consumer is disabled, it needs the nats or jetstream event driver")
+	} else if c.Consumer.Enabled {
+		if cons, err = startConsumer(c, driver, queue, storage); err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	if c.Server.AdminURL != "" {
+		go startAdminServer(c.Server.AdminURL)
+	}
+	if err := startServer(ctx, &c.Server, iCompany, opts...); err != nil {
+		log.Error(err.Error())
+		failed = true
+	}
+	// the server is stopped by a signal or has failed, stop the rest too
+	stop()
+	if cons != nil {
+		if err := cons.Drain(); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	background.Wait()
 }
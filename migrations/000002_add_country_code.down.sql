@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS companies_country_code_idx;
+ALTER TABLE companies DROP COLUMN IF EXISTS country_code;
@@ -0,0 +1,5 @@
+-- country_code of existing companies is resolved by db.MigrateSchema right after this migration
+-- with the country datasets of domain, so they are not duplicated here
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS country_code CHAR(2);
+
+CREATE INDEX IF NOT EXISTS companies_country_code_idx ON companies (country_code);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS companies_phone_e164_idx;
+ALTER TABLE companies DROP COLUMN IF EXISTS phone_e164;
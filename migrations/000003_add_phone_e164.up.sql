@@ -0,0 +1,6 @@
+-- phone keeps the number as it was entered, phone_e164 the normalized one (see domain.ParsePhone).
+-- phone_e164 of existing companies is filled by db.MigrateSchema right after this migration
+-- with the numbering plans of domain, so they are not duplicated here
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS phone_e164 VARCHAR(16);
+
+CREATE INDEX IF NOT EXISTS companies_phone_e164_idx ON companies (phone_e164);
@@ -0,0 +1,4 @@
+ALTER TABLE companies DROP CONSTRAINT IF EXISTS companies_name_code_key;
+ALTER TABLE companies DROP CONSTRAINT IF EXISTS companies_pkey;
+ALTER TABLE companies DROP COLUMN IF EXISTS id;
+ALTER TABLE companies ADD CONSTRAINT companies_pkey PRIMARY KEY (name, code);
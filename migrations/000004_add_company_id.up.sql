@@ -0,0 +1,11 @@
+-- gen_random_uuid is built in since PostgreSQL 13, pgcrypto provides it for older versions
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS id UUID;
+UPDATE companies SET id = gen_random_uuid() WHERE id IS NULL;
+ALTER TABLE companies ALTER COLUMN id SET NOT NULL;
+ALTER TABLE companies ALTER COLUMN id SET DEFAULT gen_random_uuid();
+
+ALTER TABLE companies DROP CONSTRAINT IF EXISTS companies_pkey;
+ALTER TABLE companies ADD CONSTRAINT companies_pkey PRIMARY KEY (id);
+ALTER TABLE companies ADD CONSTRAINT companies_name_code_key UNIQUE (name, code);
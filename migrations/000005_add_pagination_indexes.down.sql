@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS companies_country_id_idx;
+DROP INDEX IF EXISTS companies_code_id_idx;
+DROP INDEX IF EXISTS companies_name_id_idx;
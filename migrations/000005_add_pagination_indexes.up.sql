@@ -0,0 +1,4 @@
+-- keyset pagination sorts by a company field and then by id
+CREATE INDEX IF NOT EXISTS companies_name_id_idx ON companies (name, id);
+CREATE INDEX IF NOT EXISTS companies_code_id_idx ON companies (code, id);
+CREATE INDEX IF NOT EXISTS companies_country_id_idx ON companies ((COALESCE(country, '')), id);
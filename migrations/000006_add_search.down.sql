@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS companies_search_text_idx;
+DROP INDEX IF EXISTS companies_search_vector_idx;
+ALTER TABLE companies DROP COLUMN IF EXISTS search_text;
+ALTER TABLE companies DROP COLUMN IF EXISTS search_vector;
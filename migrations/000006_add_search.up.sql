@@ -0,0 +1,17 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- 'simple' does not stem, company names are in many languages
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', name), 'A') ||
+    setweight(to_tsvector('simple', code), 'A') ||
+    setweight(to_tsvector('simple', COALESCE(website, '')), 'B') ||
+    setweight(to_tsvector('simple', COALESCE(country, '')), 'C')
+) STORED;
+
+-- trigrams of all searchable fields find companies by typos and parts of words
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS search_text text GENERATED ALWAYS AS (
+    lower(name || ' ' || code || ' ' || COALESCE(website, '') || ' ' || COALESCE(country, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS companies_search_vector_idx ON companies USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS companies_search_text_idx ON companies USING GIN (search_text gin_trgm_ops);
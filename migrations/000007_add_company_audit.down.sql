@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS company_audit;
+DROP FUNCTION IF EXISTS company_audit_append_only();
@@ -0,0 +1,5 @@
+DELETE FROM companies WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS companies_deleted_at_idx;
+DROP INDEX IF EXISTS companies_name_code_key;
+ALTER TABLE companies ADD CONSTRAINT companies_name_code_key UNIQUE (name, code);
+ALTER TABLE companies DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- a deleted company does not block creating a new one with the same name and code
+ALTER TABLE companies DROP CONSTRAINT IF EXISTS companies_name_code_key;
+CREATE UNIQUE INDEX IF NOT EXISTS companies_name_code_key ON companies (name, code) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS companies_deleted_at_idx ON companies (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- incremented on every write, clients send it back in If-Match to detect concurrent changes
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,19 @@
+-- events written in the transaction of a company change and published by the outbox relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id              BIGSERIAL PRIMARY KEY,
+    event_type      VARCHAR(16) NOT NULL,
+    company_id      UUID        NOT NULL,
+    payload         JSONB       NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at         TIMESTAMPTZ,
+    attempts        INT         NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    -- a relay claims the event until then, a failed one is retried after it
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- the event is not published anymore, e.g. it can't be encoded or failed too many times
+    parked_at       TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL AND parked_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_company_pending_idx ON outbox (company_id, id) WHERE sent_at IS NULL AND parked_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_sent_at_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS projected_events;
+DROP TABLE IF EXISTS company_country_counts;
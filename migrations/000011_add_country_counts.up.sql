@@ -0,0 +1,21 @@
+-- projection of the number of companies per country maintained by the country counts consumer
+CREATE TABLE IF NOT EXISTS company_country_counts (
+    country_code CHAR(2)     PRIMARY KEY,
+    companies    BIGINT      NOT NULL DEFAULT 0,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- events applied to projections, redelivered events are skipped
+CREATE TABLE IF NOT EXISTS projected_events (
+    projection   VARCHAR(64) NOT NULL,
+    event_id     TEXT        NOT NULL,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (projection, event_id)
+);
+
+-- the consumer receives only new events, existing companies are counted here
+INSERT INTO company_country_counts (country_code, companies)
+SELECT country_code, COUNT(*) FROM companies
+WHERE deleted_at IS NULL AND country_code IS NOT NULL AND country_code <> ''
+GROUP BY country_code
+ON CONFLICT (country_code) DO NOTHING;
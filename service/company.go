@@ -2,10 +2,10 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/OleksiiKhanin/companysvc/domain"
 	log "github.com/sirupsen/logrus"
+	"net/netip"
 	"strings"
 )
 
@@ -18,6 +18,7 @@ type companyService struct {
 	allowedCountriesCode []string
 	logPrefix            string
 	channel              string
+	format               domain.EventFormat
 }
 
 func NewCompanyService(
@@ -25,6 +26,7 @@ func NewCompanyService(
 	publisher domain.Publisher,
 	loc domain.CountryResolver,
 	channel string,
+	format domain.EventFormat,
 	l *log.Logger,
 	countryCodes ...string,
 ) domain.ICompany {
@@ -36,18 +38,20 @@ func NewCompanyService(
 		allowedCountriesCode: countryCodes,
 		logPrefix:            "companyService",
 		channel:              channel,
+		format:               format,
 	}
 	return &c
 }
 
-func (c *companyService) checkUserIP(ctx context.Context) error {
-	ip, ok := ctx.Value(domain.CtxUserIPKey).(string)
-	if !ok {
-		return fmt.Errorf("ip address must be defined")
+func (c *companyService) checkUserIP(ctx context.Context, op string) error {
+	addr, ok := ctx.Value(domain.CtxUserIPKey).(netip.Addr)
+	if !ok || !addr.IsValid() {
+		return &domain.AccessDeniedError{Operation: op, Subject: "unknown client", Reason: "ip address must be defined"}
 	}
-	code, err := c.locationClient.Resolve(strings.TrimSpace(ip))
+	ip := addr.String()
+	code, err := c.locationClient.Resolve(ip)
 	if err != nil {
-		return fmt.Errorf("resolve ip %s: %w", ip, err)
+		return fmt.Errorf("resolve ip %s: %w: %s", ip, domain.ErrUnavailable, err.Error())
 	}
 	code = strings.ToUpper(code)
 	for i := range c.allowedCountriesCode {
@@ -55,68 +59,206 @@ func (c *companyService) checkUserIP(ctx context.Context) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("request not allowed")
+	return &domain.AccessDeniedError{Operation: op, Subject: ip, Reason: "requests from " + code + " are not allowed"}
 }
 
 func (c *companyService) Create(ctx context.Context, company *domain.Company) error {
-	if err := c.checkUserIP(ctx); err != nil {
+	company.Normalize()
+	if err := company.Validate(); err != nil {
 		return err
 	}
-	if err := c.ICompany.Create(ctx, company); err != nil {
+	if err := c.checkUserIP(ctx, OpCreate); err != nil {
 		return err
 	}
-	if c.event != nil {
-		data, err := json.Marshal(domain.Event{
-			Type:    domain.CreateCompany,
-			Subject: *company,
-		})
-		if err != nil {
-			c.l.Infof("%s: create an insert event: %s", c.logPrefix, err.Error())
-		} else if err := c.event.Publish(c.channel, data); err != nil {
-			c.l.Infof("%s: publish an insert event: %s", c.logPrefix, err.Error())
-		}
+	if err := c.ICompany.Create(ctx, company); err != nil {
+		return err
 	}
+	c.publish(ctx, domain.CreateCompany, nil, company)
 	return nil
 }
 
 func (c *companyService) Delete(ctx context.Context, name, code string) error {
-	if err := c.checkUserIP(ctx); err != nil {
+	if err := c.checkUserIP(ctx, OpDelete); err != nil {
 		return err
 	}
-	if err := c.ICompany.Delete(ctx, name, code); err != nil {
+	old, err := c.ICompany.Get(ctx, name, code)
+	if err != nil {
 		return err
 	}
-	if c.event != nil {
-		data, err := json.Marshal(domain.Event{
-			Type:    domain.DeleteCompany,
-			OldName: name,
-			OldCode: code,
-		})
-		if err != nil {
-			c.l.Infof("%s: create a delete event: %s", c.logPrefix, err.Error())
-		} else if err := c.event.Publish(c.channel, data); err != nil {
-			c.l.Infof("%s: publish a delete event: %s", c.logPrefix, err.Error())
-		}
+	if err := c.ICompany.Delete(ctx, name, code); err != nil {
+		return err
 	}
+	c.publish(ctx, domain.DeleteCompany, &old, nil)
 	return nil
 }
 
-func (c *companyService) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) error {
-	if err := c.ICompany.Update(ctx, oldName, oldCode, company); err != nil {
+func (c *companyService) DeleteByID(ctx context.Context, id string) error {
+	if err := c.checkUserIP(ctx, OpDelete); err != nil {
 		return err
 	}
-	if c.event != nil {
-		data, err := json.Marshal(domain.Event{
-			Type:    domain.UpdateCompany,
-			Subject: *company,
-			OldName: oldName,
-			OldCode: oldCode,
-		})
-		if err != nil {
-			c.l.Infof("%s: create an update event: %s", c.logPrefix, err.Error())
-		} else if err := c.event.Publish(c.channel, data); err != nil {
-			c.l.Infof("%s: publish an update event: %s", c.logPrefix, err.Error())
-		}
+	old, err := c.ICompany.GetByID(ctx, id)
+	if err != nil {
+		return err
 	}
+	if err := c.ICompany.DeleteByID(ctx, id); err != nil {
+		return err
+	}
+	c.publish(ctx, domain.DeleteCompany, &old, nil)
 	return nil
 }
+
+func (c *companyService) Restore(ctx context.Context, name, code string) (domain.Company, error) {
+	if err := c.checkUserIP(ctx, OpRestore); err != nil {
+		return domain.Company{}, err
+	}
+	company, err := c.ICompany.Restore(ctx, name, code)
+	if err != nil {
+		return company, err
+	}
+	c.publish(ctx, domain.RestoreCompany, nil, &company)
+	return company, nil
+}
+
+func (c *companyService) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) (domain.Company, error) {
+	company.Normalize()
+	if err := company.Validate(); err != nil {
+		return domain.Company{}, err
+	}
+	old, err := c.ICompany.Update(ctx, oldName, oldCode, company)
+	if err != nil {
+		return old, err
+	}
+	c.publish(ctx, domain.UpdateCompany, &old, company)
+	return old, nil
+}
+
+func (c *companyService) UpdateByID(ctx context.Context, id string, company *domain.Company) (domain.Company, error) {
+	company.Normalize()
+	if err := company.Validate(); err != nil {
+		return domain.Company{}, err
+	}
+	old, err := c.ICompany.UpdateByID(ctx, id, company)
+	if err != nil {
+		return old, err
+	}
+	c.publish(ctx, domain.UpdateCompany, &old, company)
+	return old, nil
+}
+
+// Patch publishes an update event with the changed fields, a patch without changes is not published
+func (c *companyService) Patch(ctx context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	old, company, err := c.ICompany.Patch(ctx, name, code, patch)
+	if err != nil {
+		return old, company, err
+	}
+	if len(domain.ChangedFields(&old, &company)) > 0 {
+		c.publish(ctx, domain.UpdateCompany, &old, &company)
+	}
+	return old, company, nil
+}
+
+func (c *companyService) PatchByID(ctx context.Context, id string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	old, company, err := c.ICompany.PatchByID(ctx, id, patch)
+	if err != nil {
+		return old, company, err
+	}
+	if len(domain.ChangedFields(&old, &company)) > 0 {
+		c.publish(ctx, domain.UpdateCompany, &old, &company)
+	}
+	return old, company, nil
+}
+
+// publish sends the event of the change if the service has a publisher,
+// failures are only logged because the change is saved
+func (c *companyService) publish(ctx context.Context, op domain.EventType, before, after *domain.Company) {
+	if c.event == nil {
+		return
+	}
+	msg, ok := c.encode(ctx, op, before, after)
+	if !ok {
+		return
+	}
+	if err := domain.PublishMessage(c.event, c.channel, msg); err != nil {
+		c.l.Infof("%s: publish %s event: %s", c.logPrefix, op, err.Error())
+	}
+}
+
+func (c *companyService) encode(ctx context.Context, op domain.EventType, before, after *domain.Company) (*domain.Message, bool) {
+	e := domain.NewEvent(ctx, op, before, after)
+	msg, err := c.format.Encode(&e)
+	if err != nil {
+		c.l.Infof("%s: create %s event: %s", c.logPrefix, op, err.Error())
+		return nil, false
+	}
+	return msg, true
+}
+
+// flusher is implemented by publishers buffering messages, e.g. *nats.Conn
+type flusher interface {
+	Flush() error
+}
+
+// Batch resolves the client location once and publishes events of the applied operations together,
+// the companies before updates and deletes are read by the storage in the batch transaction
+func (c *companyService) Batch(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+	if err := c.checkUserIP(ctx, OpBatch); err != nil {
+		return nil, err
+	}
+	results, pending := domain.CheckBatch(ops, options, func(op *domain.BatchOperation) error {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		if op.Action == domain.BatchDelete {
+			return nil
+		}
+		op.Company.Normalize()
+		return op.Company.Validate()
+	})
+	if options.DryRun {
+		for _, i := range pending {
+			if ops[i].Company != nil {
+				results[i].Company = *ops[i].Company
+			}
+		}
+		return results, nil
+	}
+	if err := domain.ApplyBatch(ctx, ops, options, results, pending, c.ICompany.Batch); err != nil {
+		return nil, err
+	}
+	if c.event == nil {
+		return results, nil
+	}
+	msgs := make([]*domain.Message, 0, len(results))
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		var (
+			msg *domain.Message
+			ok  bool
+		)
+		switch ops[i].Action {
+		case domain.BatchCreate:
+			msg, ok = c.encode(ctx, domain.CreateCompany, nil, &results[i].Company)
+		case domain.BatchUpdate:
+			msg, ok = c.encode(ctx, domain.UpdateCompany, &results[i].Before, &results[i].Company)
+		case domain.BatchDelete:
+			msg, ok = c.encode(ctx, domain.DeleteCompany, &results[i].Before, nil)
+		}
+		if ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return results, nil
+	}
+	if err := domain.PublishMessages(c.event, c.channel, msgs); err != nil {
+		c.l.Infof("%s: publish %d batch events: %s", c.logPrefix, len(msgs), err.Error())
+	}
+	if f, ok := c.event.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			c.l.Infof("%s: flush batch events: %s", c.logPrefix, err.Error())
+		}
+	}
+	return results, nil
+}
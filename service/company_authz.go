@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OpGet     = "get"
+	OpGetMany = "getMany"
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	// OpBatch only names the client location check of a batch, its operations are authorized one by one
+	OpBatch = "batch"
+	// OpIncludeDeleted is checked in addition to getMany when deleted companies are listed
+	OpIncludeDeleted = "includeDeleted"
+)
+
+// PolicyRule grants an operation when the caller has all Scopes and at least one of Roles (if any).
+// Non empty Countries restrict the rule to companies of the listed countries. A rule needs a scope or a role,
+// it never grants an operation to anonymous callers.
+type PolicyRule struct {
+	Scopes    []string
+	Roles     []string
+	Countries []string
+}
+
+// Policy - rules per operation. An operation is allowed if any of its rules matches,
+// operations without rules are denied for everyone.
+type Policy map[string][]PolicyRule
+
+// Validate checks that every rule requires a scope or a role
+func (p Policy) Validate() error {
+	for op, rules := range p {
+		for i, rule := range rules {
+			if len(rule.Scopes) == 0 && len(rule.Roles) == 0 {
+				return fmt.Errorf("authz rule %d of %s: scopes or roles must be defined", i+1, op)
+			}
+		}
+	}
+	return nil
+}
+
+type companyAuthorizer struct {
+	domain.ICompany
+
+	policy    Policy
+	l         *log.Logger
+	logPrefix string
+}
+
+// NewCompanyAuthorizer checks the caller claims against the policy before every operation
+func NewCompanyAuthorizer(company domain.ICompany, policy Policy, l *log.Logger) domain.ICompany {
+	return &companyAuthorizer{
+		ICompany:  company,
+		policy:    policy,
+		l:         l,
+		logPrefix: "companyAuthorizer",
+	}
+}
+
+// rules returns caller rules of the operation which match by scopes and roles
+func (c *companyAuthorizer) rules(ctx context.Context, op string) ([]PolicyRule, error) {
+	rules := c.policy[op]
+	if len(rules) == 0 {
+		return nil, c.deny(ctx, op, "the operation has no rules")
+	}
+	claims, _ := ctx.Value(domain.CtxUserClaimsKey).(domain.Claims)
+	matched := make([]PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Scopes) == 0 && len(rule.Roles) == 0 {
+			continue
+		}
+		if !claims.HasScopes(rule.Scopes...) {
+			continue
+		}
+		if len(rule.Roles) > 0 && !claims.HasAnyRole(rule.Roles...) {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	if len(matched) == 0 {
+		return nil, c.deny(ctx, op, "missing required scopes or roles")
+	}
+	return matched, nil
+}
+
+func (c *companyAuthorizer) deny(ctx context.Context, op, reason string) error {
+	subject, _ := ctx.Value(domain.CtxUserSubjectKey).(string)
+	if subject == "" {
+		subject = "anonymous"
+	}
+	c.l.Infof("%s: deny %s for %s: %s", c.logPrefix, op, subject, reason)
+	return &domain.AccessDeniedError{Operation: op, Subject: subject, Reason: reason}
+}
+
+// countryCode returns ISO 3166 alpha-2 code of the company country, the company may be not normalized yet
+func countryCode(company *domain.Company) string {
+	if company.CountryCode != "" {
+		return company.CountryCode
+	}
+	if found, ok := domain.MatchCountry(company.Country); ok {
+		return found.Alpha2
+	}
+	return strings.TrimSpace(company.Country)
+}
+
+func allowsCountry(rules []PolicyRule, company *domain.Company) bool {
+	code := countryCode(company)
+	for _, rule := range rules {
+		if len(rule.Countries) == 0 {
+			return true
+		}
+		for i := range rule.Countries {
+			if strings.EqualFold(code, rule.Countries[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *companyAuthorizer) authorize(ctx context.Context, op string, companies ...*domain.Company) error {
+	rules, err := c.rules(ctx, op)
+	if err != nil {
+		return err
+	}
+	for _, company := range companies {
+		if !allowsCountry(rules, company) {
+			return c.deny(ctx, op, "country "+countryCode(company)+" is not allowed")
+		}
+	}
+	return nil
+}
+
+func (c *companyAuthorizer) Get(ctx context.Context, name, code string) (domain.Company, error) {
+	if _, err := c.rules(ctx, OpGet); err != nil {
+		return domain.Company{}, err
+	}
+	company, err := c.ICompany.Get(ctx, name, code)
+	if err != nil {
+		return company, err
+	}
+	if err := c.authorize(ctx, OpGet, &company); err != nil {
+		return domain.Company{}, err
+	}
+	return company, nil
+}
+
+func (c *companyAuthorizer) GetByID(ctx context.Context, id string) (domain.Company, error) {
+	if _, err := c.rules(ctx, OpGet); err != nil {
+		return domain.Company{}, err
+	}
+	company, err := c.ICompany.GetByID(ctx, id)
+	if err != nil {
+		return company, err
+	}
+	if err := c.authorize(ctx, OpGet, &company); err != nil {
+		return domain.Company{}, err
+	}
+	return company, nil
+}
+
+// restrictCountries adds countries the rules are restricted to into the filter
+func restrictCountries(rules []PolicyRule, where domain.Filter) domain.Filter {
+	var countries []string
+	for _, rule := range rules {
+		if len(rule.Countries) == 0 {
+			return where
+		}
+		countries = append(countries, rule.Countries...)
+	}
+	restricted := domain.And{domain.Condition{Field: "countryCode", Op: domain.FilterIn, Values: countries}}
+	if where != nil {
+		restricted = append(restricted, where)
+	}
+	return restricted
+}
+
+// GetMany adds allowed countries to the filter, so pages and totals contain only visible companies
+func (c *companyAuthorizer) GetMany(ctx context.Context, filter *domain.FilterOptions) (domain.CompanyPage, error) {
+	rules, err := c.rules(ctx, OpGetMany)
+	if err != nil {
+		return domain.CompanyPage{}, err
+	}
+	restricted := domain.FilterOptions{}
+	if filter != nil {
+		restricted = *filter
+	}
+	if restricted.IncludeDeleted {
+		if _, err := c.rules(ctx, OpIncludeDeleted); err != nil {
+			return domain.CompanyPage{}, err
+		}
+	}
+	restricted.Where = restrictCountries(rules, restricted.Where)
+	filter = &restricted
+	page, err := c.ICompany.GetMany(ctx, filter)
+	if err != nil {
+		return page, err
+	}
+	allowed := page.Items[:0]
+	for i := range page.Items {
+		if allowsCountry(rules, &page.Items[i]) {
+			allowed = append(allowed, page.Items[i])
+		}
+	}
+	page.Items = allowed
+	return page, nil
+}
+
+// Export is allowed by the getMany rules and exports only companies of allowed countries
+func (c *companyAuthorizer) Export(ctx context.Context, filter *domain.FilterOptions, fn func(*domain.Company) error) error {
+	rules, err := c.rules(ctx, OpGetMany)
+	if err != nil {
+		return err
+	}
+	restricted := domain.FilterOptions{}
+	if filter != nil {
+		restricted = *filter
+	}
+	if restricted.IncludeDeleted {
+		if _, err := c.rules(ctx, OpIncludeDeleted); err != nil {
+			return err
+		}
+	}
+	restricted.Where = restrictCountries(rules, restricted.Where)
+	return c.ICompany.Export(ctx, &restricted, func(company *domain.Company) error {
+		if !allowsCountry(rules, company) {
+			return nil
+		}
+		return fn(company)
+	})
+}
+
+// Search is allowed by the getMany rules and finds only companies of allowed countries
+func (c *companyAuthorizer) Search(ctx context.Context, options *domain.SearchOptions) ([]domain.SearchResult, error) {
+	rules, err := c.rules(ctx, OpGetMany)
+	if err != nil {
+		return nil, err
+	}
+	restricted := *options
+	restricted.Where = restrictCountries(rules, restricted.Where)
+	return c.ICompany.Search(ctx, &restricted)
+}
+
+// History is allowed by the get rules for the country of the latest revision
+func (c *companyAuthorizer) History(ctx context.Context, name, code string) ([]domain.Revision, error) {
+	if _, err := c.rules(ctx, OpGet); err != nil {
+		return nil, err
+	}
+	revisions, err := c.ICompany.History(ctx, name, code)
+	if err != nil || len(revisions) == 0 {
+		return revisions, err
+	}
+	latest := revisions[0].After
+	if latest == nil {
+		latest = revisions[0].Before
+	}
+	if latest != nil {
+		if err := c.authorize(ctx, OpGet, latest); err != nil {
+			return nil, err
+		}
+	}
+	return revisions, nil
+}
+
+func (c *companyAuthorizer) Create(ctx context.Context, company *domain.Company) error {
+	if err := c.authorize(ctx, OpCreate, company); err != nil {
+		return err
+	}
+	return c.ICompany.Create(ctx, company)
+}
+
+func (c *companyAuthorizer) Update(ctx context.Context, oldName, oldCode string, company *domain.Company) (domain.Company, error) {
+	if _, err := c.rules(ctx, OpUpdate); err != nil {
+		return domain.Company{}, err
+	}
+	old, err := c.ICompany.Get(ctx, oldName, oldCode)
+	if err != nil {
+		return domain.Company{}, err
+	}
+	if err := c.authorize(ctx, OpUpdate, &old, company); err != nil {
+		return domain.Company{}, err
+	}
+	return c.ICompany.Update(ctx, oldName, oldCode, company)
+}
+
+// Patch checks the country of the company before and after the patch
+func (c *companyAuthorizer) Patch(ctx context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	if _, err := c.rules(ctx, OpUpdate); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	old, err := c.ICompany.Get(ctx, name, code)
+	if err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	patched := old
+	if err := patch.Apply(&patched); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	if err := c.authorize(ctx, OpUpdate, &old, &patched); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	return c.ICompany.Patch(ctx, name, code, patch)
+}
+
+func (c *companyAuthorizer) UpdateByID(ctx context.Context, id string, company *domain.Company) (domain.Company, error) {
+	if _, err := c.rules(ctx, OpUpdate); err != nil {
+		return domain.Company{}, err
+	}
+	old, err := c.ICompany.GetByID(ctx, id)
+	if err != nil {
+		return domain.Company{}, err
+	}
+	if err := c.authorize(ctx, OpUpdate, &old, company); err != nil {
+		return domain.Company{}, err
+	}
+	return c.ICompany.UpdateByID(ctx, id, company)
+}
+
+func (c *companyAuthorizer) PatchByID(ctx context.Context, id string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	if _, err := c.rules(ctx, OpUpdate); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	old, err := c.ICompany.GetByID(ctx, id)
+	if err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	patched := old
+	if err := patch.Apply(&patched); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	if err := c.authorize(ctx, OpUpdate, &old, &patched); err != nil {
+		return domain.Company{}, domain.Company{}, err
+	}
+	return c.ICompany.PatchByID(ctx, id, patch)
+}
+
+func (c *companyAuthorizer) Delete(ctx context.Context, name, code string) error {
+	if _, err := c.rules(ctx, OpDelete); err != nil {
+		return err
+	}
+	old, err := c.ICompany.Get(ctx, name, code)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(ctx, OpDelete, &old); err != nil {
+		return err
+	}
+	return c.ICompany.Delete(ctx, name, code)
+}
+
+func (c *companyAuthorizer) DeleteByID(ctx context.Context, id string) error {
+	if _, err := c.rules(ctx, OpDelete); err != nil {
+		return err
+	}
+	old, err := c.ICompany.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(ctx, OpDelete, &old); err != nil {
+		return err
+	}
+	return c.ICompany.DeleteByID(ctx, id)
+}
+
+// Batch authorizes every operation like a single create, update or delete
+func (c *companyAuthorizer) Batch(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+	results, pending := domain.CheckBatch(ops, options, func(op *domain.BatchOperation) error {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		if op.Action == domain.BatchCreate {
+			return c.authorize(ctx, OpCreate, op.Company)
+		}
+		if _, err := c.rules(ctx, string(op.Action)); err != nil {
+			return err
+		}
+		old, err := c.ICompany.Get(ctx, op.Name, op.Code)
+		if err != nil {
+			return err
+		}
+		if op.Action == domain.BatchUpdate {
+			return c.authorize(ctx, OpUpdate, &old, op.Company)
+		}
+		return c.authorize(ctx, string(op.Action), &old)
+	})
+	if err := domain.ApplyBatch(ctx, ops, options, results, pending, c.ICompany.Batch); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Restore checks the country of the deleted company in its history
+func (c *companyAuthorizer) Restore(ctx context.Context, name, code string) (domain.Company, error) {
+	if _, err := c.rules(ctx, OpRestore); err != nil {
+		return domain.Company{}, err
+	}
+	revisions, err := c.ICompany.History(ctx, name, code)
+	if err != nil {
+		return domain.Company{}, err
+	}
+	if len(revisions) > 0 && revisions[0].Before != nil {
+		if err := c.authorize(ctx, OpRestore, revisions[0].Before); err != nil {
+			return domain.Company{}, err
+		}
+	}
+	return c.ICompany.Restore(ctx, name, code)
+}
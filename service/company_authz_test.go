@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCompanyAuthorizer(t *testing.T) {
+	policy := Policy{
+		OpCreate: {
+			{Scopes: []string{"company:write"}},
+			{Roles: []string{"editor"}, Countries: []string{"UA"}},
+		},
+		OpDelete: {{Scopes: []string{"company:admin"}}, {Countries: []string{"UA"}}}, // the last rule never matches
+	}
+	writer := domain.Claims{"sub": "writer", "scope": "company:write"}
+	editor := domain.Claims{"sub": "editor", "roles": []any{"editor"}}
+	admin := domain.Claims{"sub": "admin", "scope": "company:admin"}
+
+	cases := []struct {
+		name    string
+		claims  domain.Claims
+		call    func(ctx context.Context, c domain.ICompany) error
+		success bool
+	}{
+		{
+			name:   "writer creates",
+			claims: writer,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Create(ctx, &domain.Company{Name: "w", Code: "w", Country: "CY"})
+			},
+			success: true,
+		},
+		{
+			name:   "editor creates in allowed country",
+			claims: editor,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Create(ctx, &domain.Company{Name: "e", Code: "e", Country: "ua"})
+			},
+			success: true,
+		},
+		{
+			name:   "editor creates in other country",
+			claims: editor,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Create(ctx, &domain.Company{Name: "e2", Code: "e2", Country: "CY"})
+			},
+		},
+		{
+			name: "anonymous creates",
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Create(ctx, &domain.Company{Name: "a", Code: "a", Country: "UA"})
+			},
+		},
+		{
+			name:   "writer gets without rules",
+			claims: writer,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				_, err := c.Get(ctx, "1", "1")
+				return err
+			},
+		},
+		{
+			name: "anonymous restores without rules",
+			call: func(ctx context.Context, c domain.ICompany) error {
+				_, err := c.Restore(ctx, "1", "1")
+				return err
+			},
+		},
+		{
+			name:   "writer deletes",
+			claims: writer,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Delete(ctx, "1", "1")
+			},
+		},
+		{
+			name:   "admin deletes",
+			claims: admin,
+			call: func(ctx context.Context, c domain.ICompany) error {
+				return c.Delete(ctx, "1", "1")
+			},
+			success: true,
+		},
+	}
+
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{{Name: "1", Code: "1", Country: "UA"}}},
+		policy,
+		log.StandardLogger(),
+	)
+	for _, c := range cases {
+		ctx := context.Background()
+		if c.claims != nil {
+			ctx = context.WithValue(ctx, domain.CtxUserClaimsKey, c.claims)
+			ctx = context.WithValue(ctx, domain.CtxUserSubjectKey, c.claims.Subject())
+		}
+		err := c.call(ctx, company)
+		if c.success && err != nil {
+			t.Errorf("case %s: %s", c.name, err.Error())
+		}
+		if !c.success && !errors.Is(err, domain.ErrForbidden) {
+			t.Errorf("case %s should be forbidden but got %v", c.name, err)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	valid := Policy{OpGet: {{Scopes: []string{"company:read"}}, {Roles: []string{"viewer"}, Countries: []string{"UA"}}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("want a valid policy but got %s", err)
+	}
+	invalid := Policy{OpDelete: {{Scopes: []string{"company:admin"}}, {Countries: []string{"UA"}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("a rule without scopes and roles should be rejected")
+	}
+}
+
+func TestCompanyAuthorizerGetManyFiltersCountries(t *testing.T) {
+	policy := Policy{OpGetMany: {{Roles: []string{"viewer"}, Countries: []string{"UA"}}}}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{
+			{Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+			{Name: "2", Code: "2", Country: "Cyprus", CountryCode: "CY"},
+			{Name: "3", Code: "3", Country: "Ukraine", CountryCode: "UA"},
+		}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"viewer"}})
+	limit := 2
+	page, err := company.GetMany(ctx, &domain.FilterOptions{Limit: &limit})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].Name != "1" || page.Items[1].Name != "3" {
+		t.Errorf("want a full page of companies 1 and 3 but got %v", page.Items)
+	}
+}
+
+func TestCompanyAuthorizerSearchFiltersCountries(t *testing.T) {
+	policy := Policy{OpGetMany: {{Roles: []string{"viewer"}, Countries: []string{"CY"}}}}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{
+			{Name: "Acme UA", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+			{Name: "Acme CY", Code: "2", Country: "Cyprus", CountryCode: "CY"},
+		}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"viewer"}})
+	results, err := company.Search(ctx, &domain.SearchOptions{Query: "acme", Limit: 10})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(results) != 1 || results[0].Company.Name != "Acme CY" {
+		t.Errorf("want only the company of Cyprus but got %v", results)
+	}
+	if _, err := company.Search(context.Background(), &domain.SearchOptions{Query: "acme"}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("search without the role should be forbidden but got %v", err)
+	}
+}
+
+func TestCompanyAuthorizerHistory(t *testing.T) {
+	policy := Policy{OpGet: {{Roles: []string{"viewer"}, Countries: []string{"CY"}}}}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{
+			{Name: "ua", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+			{Name: "cy", Code: "2", Country: "Cyprus", CountryCode: "CY"},
+		}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"viewer"}})
+	if _, err := company.History(ctx, "cy", "2"); err != nil {
+		t.Errorf("history of an allowed country should be returned but got %s", err)
+	}
+	if _, err := company.History(ctx, "ua", "1"); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("history of other country should be forbidden but got %v", err)
+	}
+}
+
+func TestCompanyAuthorizerDeleted(t *testing.T) {
+	policy := Policy{
+		OpGetMany:        {{Scopes: []string{"company:read"}}, {Scopes: []string{"company:admin"}}},
+		OpRestore:        {{Scopes: []string{"company:admin"}, Countries: []string{"UA"}}},
+		OpIncludeDeleted: {{Scopes: []string{"company:admin"}}},
+	}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{deleted: []*domain.Company{
+			{Name: "ua", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+			{Name: "cy", Code: "2", Country: "Cyprus", CountryCode: "CY"},
+		}},
+		policy,
+		log.StandardLogger(),
+	)
+	admin := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"scope": "company:admin"})
+	if _, err := company.Restore(admin, "ua", "1"); err != nil {
+		t.Errorf("admin should restore a company of an allowed country but got %s", err)
+	}
+	if _, err := company.Restore(admin, "cy", "2"); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("restore of other country should be forbidden but got %v", err)
+	}
+	if _, err := company.GetMany(admin, &domain.FilterOptions{IncludeDeleted: true}); err != nil {
+		t.Errorf("admin should list deleted companies but got %s", err)
+	}
+	if _, err := company.GetMany(context.Background(), &domain.FilterOptions{IncludeDeleted: true}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("anonymous should not list deleted companies but got %v", err)
+	}
+	reader := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"scope": "company:read"})
+	if _, err := company.GetMany(reader, &domain.FilterOptions{}); err != nil {
+		t.Errorf("reader should list companies but got %s", err)
+	}
+	if _, err := company.GetMany(reader, &domain.FilterOptions{IncludeDeleted: true}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("reader should not list deleted companies but got %v", err)
+	}
+}
+
+func TestCompanyAuthorizerPatchChecksNewCountry(t *testing.T) {
+	policy := Policy{OpUpdate: {{Roles: []string{"editor"}, Countries: []string{"UA"}}}}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{{Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"}}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"editor"}})
+
+	website, country := "https://example.com", "Cyprus"
+	if _, _, err := company.Patch(ctx, "1", "1", &domain.CompanyPatch{Website: &website}); err != nil {
+		t.Errorf("editor should patch a company of UA but got %s", err.Error())
+	}
+	if _, _, err := company.Patch(ctx, "1", "1", &domain.CompanyPatch{Country: &country}); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("editor should not move a company to CY but got %v", err)
+	}
+}
+
+func TestCompanyAuthorizerBatch(t *testing.T) {
+	policy := Policy{
+		OpCreate: {{Roles: []string{"editor"}, Countries: []string{"UA"}}},
+		OpDelete: {{Scopes: []string{"company:admin"}}},
+	}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{{Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"}}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"editor"}})
+	ops := []domain.BatchOperation{
+		{Action: domain.BatchCreate, Company: &domain.Company{Name: "2", Code: "2", Country: "Ukraine", CountryCode: "UA"}},
+		{Action: domain.BatchCreate, Company: &domain.Company{Name: "3", Code: "3", Country: "Cyprus", CountryCode: "CY"}},
+		{Action: domain.BatchDelete, Name: "1", Code: "1"},
+	}
+	results, err := company.Batch(ctx, ops, &domain.BatchOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if results[0].Err != nil || !errors.Is(results[1].Err, domain.ErrForbidden) || !errors.Is(results[2].Err, domain.ErrForbidden) {
+		t.Errorf("want only the create in UA allowed but got %+v", results)
+	}
+
+	results, err = company.Batch(ctx, ops[:2], &domain.BatchOptions{})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if !errors.Is(results[0].Err, domain.ErrRolledBack) || !errors.Is(results[1].Err, domain.ErrForbidden) {
+		t.Errorf("want the batch rolled back by the forbidden create but got %+v", results)
+	}
+}
+
+func TestCompanyAuthorizerExportFiltersCountries(t *testing.T) {
+	policy := Policy{OpGetMany: {{Roles: []string{"viewer"}, Countries: []string{"CY"}}}}
+	company := NewCompanyAuthorizer(
+		&MockICompanyDB{storage: []*domain.Company{
+			{Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+			{Name: "2", Code: "2", Country: "Cyprus", CountryCode: "CY"},
+		}},
+		policy,
+		log.StandardLogger(),
+	)
+	ctx := context.WithValue(context.Background(), domain.CtxUserClaimsKey, domain.Claims{"roles": []any{"viewer"}})
+	var names []string
+	err := company.Export(ctx, &domain.FilterOptions{}, func(c *domain.Company) error {
+		names = append(names, c.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(names) != 1 || names[0] != "2" {
+		t.Errorf("want only company 2 exported but got %v", names)
+	}
+	if err := company.Export(context.Background(), &domain.FilterOptions{}, nil); !errors.Is(err, domain.ErrForbidden) {
+		t.Errorf("want forbidden without the viewer role but got %v", err)
+	}
+}
@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"github.com/OleksiiKhanin/companysvc/domain"
 	log "github.com/sirupsen/logrus"
-	"strings"
+	"net/netip"
+	"reflect"
 	"testing"
 )
 
@@ -23,6 +25,7 @@ func (pub PublisherMock) Publish(subj string, data []byte) error {
 
 type MockICompanyDB struct {
 	storage []*domain.Company
+	deleted []*domain.Company
 }
 
 func (m *MockICompanyDB) find(name, code string) int {
@@ -42,43 +45,88 @@ func (m *MockICompanyDB) Get(ctx context.Context, name, code string) (domain.Com
 	return *m.storage[i], nil
 }
 
-func (m *MockICompanyDB) GetMany(ctx context.Context, filter *domain.FilterOptions) ([]domain.Company, error) {
+func (m *MockICompanyDB) GetByID(ctx context.Context, id string) (domain.Company, error) {
+	if i := m.findByID(id); i >= 0 {
+		return *m.storage[i], nil
+	}
+	return domain.Company{}, domain.ErrNotFound
+}
+
+func (m *MockICompanyDB) GetMany(ctx context.Context, filter *domain.FilterOptions) (domain.CompanyPage, error) {
 	res := make([]domain.Company, 0)
 	for i := range m.storage {
 		if filter.Limit != nil && *filter.Limit > 0 && len(res) >= *filter.Limit {
-			return res, nil
-		}
-		if filter.Params["name"] != "" && strings.Contains(m.storage[i].Name, filter.Params["name"]) {
-			res = append(res, *m.storage[i])
+			return domain.CompanyPage{Items: res}, nil
 		}
-		if filter.Params["code"] != "" && strings.Contains(m.storage[i].Code, filter.Params["code"]) {
+		if domain.Matches(filter.Where, m.storage[i]) {
 			res = append(res, *m.storage[i])
 		}
-		if filter.Params["country"] != "" && strings.Contains(m.storage[i].Country, filter.Params["country"]) {
-			res = append(res, *m.storage[i])
+	}
+	return domain.CompanyPage{Items: res}, nil
+}
+
+func (m *MockICompanyDB) Export(ctx context.Context, filter *domain.FilterOptions, fn func(*domain.Company) error) error {
+	for i := range m.storage {
+		if filter.Where != nil && !domain.Matches(filter.Where, m.storage[i]) {
+			continue
 		}
-		if filter.Params["website"] != "" && strings.Contains(m.storage[i].Website, filter.Params["website"]) {
-			res = append(res, *m.storage[i])
+		company := *m.storage[i]
+		if err := fn(&company); err != nil {
+			return err
 		}
-		if filter.Params["phone"] != "" && strings.Contains(m.storage[i].Phone, filter.Params["phone"]) {
-			res = append(res, *m.storage[i])
+	}
+	return nil
+}
+
+func (m *MockICompanyDB) Search(ctx context.Context, options *domain.SearchOptions) ([]domain.SearchResult, error) {
+	terms := domain.SearchTerms(options.Query)
+	res := make([]domain.SearchResult, 0)
+	for i := range m.storage {
+		if highlights := domain.HighlightCompany(m.storage[i], terms); len(highlights) > 0 && domain.Matches(options.Where, m.storage[i]) {
+			res = append(res, domain.SearchResult{Company: *m.storage[i], Score: 1, Highlights: highlights})
 		}
 	}
 	return res, nil
 }
 
+func (m *MockICompanyDB) History(ctx context.Context, name, code string) ([]domain.Revision, error) {
+	if i := m.find(name, code); i >= 0 {
+		return []domain.Revision{{CompanyID: m.storage[i].ID, Operation: domain.CreateCompany, After: m.storage[i]}}, nil
+	}
+	for i := len(m.deleted) - 1; i >= 0; i-- {
+		if m.deleted[i].Name == name && m.deleted[i].Code == code {
+			return []domain.Revision{{CompanyID: m.deleted[i].ID, Operation: domain.DeleteCompany, Before: m.deleted[i]}}, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
 func (m *MockICompanyDB) Delete(_ context.Context, name, code string) error {
 	i := m.find(name, code)
 	if i < 0 {
 		return errors.New("company does not exist")
 	}
-	if i >= 0 {
-		m.storage = m.storage[:i]
-		m.storage = append(m.storage, m.storage[i:]...)
-	}
+	m.deleted = append(m.deleted, m.storage[i])
+	m.storage = append(m.storage[:i], m.storage[i+1:]...)
 	return nil
 }
 
+func (m *MockICompanyDB) Restore(_ context.Context, name, code string) (domain.Company, error) {
+	for i := len(m.deleted) - 1; i >= 0; i-- {
+		if m.deleted[i].Name != name || m.deleted[i].Code != code {
+			continue
+		}
+		if m.find(name, code) >= 0 {
+			return domain.Company{}, domain.ErrAlreadyExists
+		}
+		company := m.deleted[i]
+		m.deleted = append(m.deleted[:i], m.deleted[i+1:]...)
+		m.storage = append(m.storage, company)
+		return *company, nil
+	}
+	return domain.Company{}, domain.ErrNotFound
+}
+
 func (m *MockICompanyDB) Create(_ context.Context, company *domain.Company) error {
 	if m.find(company.Name, company.Code) >= 0 {
 		return errors.New("company already exist")
@@ -87,13 +135,100 @@ func (m *MockICompanyDB) Create(_ context.Context, company *domain.Company) erro
 	return nil
 }
 
-func (m *MockICompanyDB) Update(_ context.Context, oldName, oldCode string, company *domain.Company) error {
+func (m *MockICompanyDB) Update(_ context.Context, oldName, oldCode string, company *domain.Company) (domain.Company, error) {
 	i := m.find(oldName, oldCode)
 	if i < 0 {
-		return errors.New("company not found")
+		return domain.Company{}, errors.New("company not found")
 	}
+	before := *m.storage[i]
+	company.ID = before.ID
 	m.storage[i] = company
-	return nil
+	return before, nil
+}
+
+func (m *MockICompanyDB) Patch(_ context.Context, name, code string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	i := m.find(name, code)
+	if i < 0 {
+		return domain.Company{}, domain.Company{}, domain.ErrNotFound
+	}
+	before := *m.storage[i]
+	company := before
+	if err := patch.Apply(&company); err != nil {
+		return before, company, err
+	}
+	company.Version++
+	m.storage[i] = &company
+	return before, company, nil
+}
+
+func (m *MockICompanyDB) findByID(id string) int {
+	for i := range m.storage {
+		if m.storage[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *MockICompanyDB) UpdateByID(_ context.Context, id string, company *domain.Company) (domain.Company, error) {
+	i := m.findByID(id)
+	if i < 0 {
+		return domain.Company{}, domain.ErrNotFound
+	}
+	before := *m.storage[i]
+	company.ID = id
+	m.storage[i] = company
+	return before, nil
+}
+
+func (m *MockICompanyDB) PatchByID(_ context.Context, id string, patch *domain.CompanyPatch) (domain.Company, domain.Company, error) {
+	i := m.findByID(id)
+	if i < 0 {
+		return domain.Company{}, domain.Company{}, domain.ErrNotFound
+	}
+	return m.Patch(context.Background(), m.storage[i].Name, m.storage[i].Code, patch)
+}
+
+func (m *MockICompanyDB) DeleteByID(_ context.Context, id string) error {
+	i := m.findByID(id)
+	if i < 0 {
+		return domain.ErrNotFound
+	}
+	return m.Delete(context.Background(), m.storage[i].Name, m.storage[i].Code)
+}
+
+// Batch applies operations one by one, without ContinueOnError the storage is restored on a failure
+func (m *MockICompanyDB) Batch(ctx context.Context, ops []domain.BatchOperation, options *domain.BatchOptions) ([]domain.BatchResult, error) {
+	storage := append([]*domain.Company(nil), m.storage...)
+	results := make([]domain.BatchResult, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op.Action {
+		case domain.BatchCreate:
+			company := *op.Company
+			results[i].Company = company
+			err = m.Create(ctx, &company)
+		case domain.BatchUpdate:
+			company := *op.Company
+			results[i].Before, err = m.Update(ctx, op.Name, op.Code, &company)
+			results[i].Company = company
+		case domain.BatchDelete:
+			if results[i].Company, err = m.Get(ctx, op.Name, op.Code); err == nil {
+				results[i].Before = results[i].Company
+				err = m.Delete(ctx, op.Name, op.Code)
+			}
+		}
+		if err != nil && !options.ContinueOnError {
+			m.storage = storage
+			for j := range results {
+				results[j] = domain.BatchResult{Err: domain.ErrRolledBack}
+			}
+			results[i].Err = err
+			return results, nil
+		}
+		results[i].Err = err
+	}
+	return results, nil
 }
 
 const (
@@ -111,17 +246,17 @@ type testCase struct {
 func TestCompanyServiceCreate(t *testing.T) {
 	createCases := []testCase{
 		{
-			c:       &domain.Company{Name: "1", Code: "1"},
+			c:       &domain.Company{Name: "1", Code: "1", Country: "UA"},
 			country: countrySuccess,
 			success: true,
 		},
 		{
-			c:       &domain.Company{Name: "3", Code: "3"},
+			c:       &domain.Company{Name: "3", Code: "3", Country: "UA"},
 			country: countryFail,
 			success: false,
 		},
 		{
-			c:       &domain.Company{Name: "1", Code: "1"},
+			c:       &domain.Company{Name: "1", Code: "1", Country: "UA"},
 			country: countrySuccess,
 			success: false,
 		},
@@ -134,7 +269,7 @@ func TestCompanyServiceCreate(t *testing.T) {
 		allowedCountriesCode: []string{countrySuccess},
 	}
 
-	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, "1.1.1.1")
+	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, netip.MustParseAddr("1.1.1.1"))
 	for i := range createCases {
 		company.locationClient = CountryResolverMock(func(ip string) (string, error) {
 			return createCases[i].country, nil
@@ -163,12 +298,12 @@ func TestCompanyServiceCreate(t *testing.T) {
 func TestCompanyServiceDelete(t *testing.T) {
 	createCases := []testCase{
 		{
-			c:       &domain.Company{Name: "1", Code: "1"},
+			c:       &domain.Company{Name: "1", Code: "1", Country: "UA"},
 			country: countrySuccess,
 			success: true,
 		},
 		{
-			c:       &domain.Company{Name: "3", Code: "3"},
+			c:       &domain.Company{Name: "3", Code: "3", Country: "UA"},
 			country: countryFail,
 			success: false,
 		},
@@ -181,7 +316,7 @@ func TestCompanyServiceDelete(t *testing.T) {
 		allowedCountriesCode: []string{countrySuccess},
 	}
 
-	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, "1.1.1.1")
+	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, netip.MustParseAddr("1.1.1.1"))
 	for i := range createCases {
 		company.locationClient = CountryResolverMock(func(ip string) (string, error) {
 			return createCases[i].country, nil
@@ -209,18 +344,18 @@ func TestCompanyServiceDelete(t *testing.T) {
 func TestCompanyServiceUpdate(t *testing.T) {
 	updateCases := []testCase{
 		{
-			c:       &domain.Company{Name: "not found", Code: "not found"},
+			c:       &domain.Company{Name: "not found", Code: "not found", Country: "UA"},
 			success: false,
 		},
 		{
-			c:       &domain.Company{Name: "1", Code: "1"},
+			c:       &domain.Company{Name: "1", Code: "1", Country: "UA"},
 			success: true,
 		},
 	}
 
 	var company = companyService{
 		ICompany: &MockICompanyDB{storage: []*domain.Company{
-			&domain.Company{Name: "1", Code: "1"},
+			&domain.Company{Name: "1", Code: "1", Country: "UA"},
 		}},
 		l: log.StandardLogger(),
 		locationClient: CountryResolverMock(func(ip string) (string, error) {
@@ -231,7 +366,7 @@ func TestCompanyServiceUpdate(t *testing.T) {
 		allowedCountriesCode: []string{countrySuccess},
 	}
 
-	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, "1.1.1.1")
+	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, netip.MustParseAddr("1.1.1.1"))
 	for i := range updateCases {
 		if !updateCases[i].success {
 			company.event = PublisherMock(func(_ string, _ []byte) error {
@@ -243,7 +378,7 @@ func TestCompanyServiceUpdate(t *testing.T) {
 		} else {
 			company.event = nil
 		}
-		err := company.Update(ctx, updateCases[i].c.Name, updateCases[i].c.Code, updateCases[i].c)
+		_, err := company.Update(ctx, updateCases[i].c.Name, updateCases[i].c.Code, updateCases[i].c)
 		if updateCases[i].success && err != nil {
 			t.Error(err.Error())
 		}
@@ -252,3 +387,224 @@ func TestCompanyServiceUpdate(t *testing.T) {
 		}
 	}
 }
+
+// snapshotDB fails reads of the service, snapshots of events must come from the locked changes
+type snapshotDB struct {
+	*MockICompanyDB
+	t *testing.T
+}
+
+func (s snapshotDB) Get(ctx context.Context, name, code string) (domain.Company, error) {
+	s.t.Error("the snapshot before the change must not be read separately")
+	return s.MockICompanyDB.Get(ctx, name, code)
+}
+
+func (s snapshotDB) GetByID(ctx context.Context, id string) (domain.Company, error) {
+	s.t.Error("the snapshot before the change must not be read separately")
+	return s.MockICompanyDB.GetByID(ctx, id)
+}
+
+func TestCompanyServiceUpdateEvent(t *testing.T) {
+	var published []domain.CloudEvent
+	company := companyService{
+		ICompany: snapshotDB{t: t, MockICompanyDB: &MockICompanyDB{storage: []*domain.Company{
+			{ID: "id", Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+		}}},
+		l: log.StandardLogger(),
+		event: PublisherMock(func(_ string, data []byte) error {
+			var e domain.CloudEvent
+			if err := json.Unmarshal(data, &e); err != nil {
+				t.Error(err)
+			}
+			published = append(published, e)
+			return nil
+		}),
+		channel: pubChannel,
+	}
+
+	if _, err := company.Update(context.Background(), "1", "1", &domain.Company{Name: "1", Code: "1", Country: "Cyprus"}); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if _, err := company.UpdateByID(context.Background(), "id", &domain.Company{Name: "1", Code: "1", Country: "Poland"}); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(published) != 2 || published[0].Data.Before.CountryCode != "UA" || published[0].Data.After.CountryCode != "CY" ||
+		published[1].Data.Before.CountryCode != "CY" || published[1].Data.After.CountryCode != "PL" {
+		t.Errorf("want update events with the companies before the updates but got %+v", published)
+	}
+}
+
+func TestCompanyServiceRestore(t *testing.T) {
+	var published []domain.CloudEvent
+	company := companyService{
+		ICompany: &MockICompanyDB{deleted: []*domain.Company{{ID: "id", Name: "1", Code: "1", Country: "Ukraine"}}},
+		l:        log.StandardLogger(),
+		event: PublisherMock(func(_ string, data []byte) error {
+			var e domain.CloudEvent
+			if err := json.Unmarshal(data, &e); err != nil {
+				t.Error(err)
+			}
+			published = append(published, e)
+			return nil
+		}),
+		locationClient: CountryResolverMock(func(ip string) (string, error) {
+			return countrySuccess, nil
+		}),
+		channel:              pubChannel,
+		allowedCountriesCode: []string{countrySuccess},
+	}
+
+	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, netip.MustParseAddr("1.1.1.1"))
+	restored, err := company.Restore(ctx, "1", "1")
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if restored.ID != "id" {
+		t.Errorf("want the deleted company but got %+v", restored)
+	}
+	if len(published) != 1 || published[0].Type != domain.CloudEventType(domain.RestoreCompany) ||
+		published[0].Subject != "id" || published[0].Data.Before != nil || published[0].Data.After == nil {
+		t.Errorf("want one restore event but got %+v", published)
+	}
+	if _, err := company.Restore(ctx, "1", "1"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("want not found for a company which is not deleted but got %v", err)
+	}
+}
+
+func TestCompanyServicePatch(t *testing.T) {
+	var published []domain.CloudEvent
+	company := companyService{
+		ICompany: snapshotDB{t: t, MockICompanyDB: &MockICompanyDB{storage: []*domain.Company{
+			{ID: "id", Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"},
+		}}},
+		l: log.StandardLogger(),
+		event: PublisherMock(func(_ string, data []byte) error {
+			var e domain.CloudEvent
+			if err := json.Unmarshal(data, &e); err != nil {
+				t.Error(err)
+			}
+			published = append(published, e)
+			return nil
+		}),
+		channel: pubChannel,
+	}
+
+	phone := "044 123 45 67"
+	_, patched, err := company.Patch(context.Background(), "1", "1", &domain.CompanyPatch{Phone: &phone})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if patched.Name != "1" || patched.Phone.E164 != "+380441234567" {
+		t.Errorf("want the phone of company 1 changed but got %+v", patched)
+	}
+	if len(published) != 1 || published[0].Type != domain.CloudEventType(domain.UpdateCompany) ||
+		!reflect.DeepEqual(published[0].Data.ChangedFields, []string{"phone"}) || published[0].Data.Before.Phone.E164 != "" {
+		t.Errorf("want one update event of the phone but got %+v", published)
+	}
+
+	if _, _, err := company.Patch(context.Background(), "1", "1", &domain.CompanyPatch{Phone: &phone}); err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if len(published) != 1 {
+		t.Errorf("a patch without changes should not be published but got %+v", published)
+	}
+
+	empty := ""
+	if _, _, err := company.Patch(context.Background(), "1", "1", &domain.CompanyPatch{Code: &empty}); !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("want validation error when the code is removed but got %v", err)
+	}
+}
+
+type flushPublisherMock struct {
+	PublisherMock
+	batches []int // sizes of published batches
+	flushed int
+}
+
+func (f *flushPublisherMock) PublishMessages(subj string, msgs []*domain.Message) error {
+	f.batches = append(f.batches, len(msgs))
+	for _, msg := range msgs {
+		if err := f.Publish(subj, msg.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *flushPublisherMock) Flush() error {
+	f.flushed++
+	return nil
+}
+
+func TestCompanyServiceBatch(t *testing.T) {
+	var (
+		published []domain.CloudEvent
+		lookups   int
+	)
+	pub := &flushPublisherMock{PublisherMock: func(_ string, data []byte) error {
+		var e domain.CloudEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Error(err)
+		}
+		published = append(published, e)
+		return nil
+	}}
+	db := &MockICompanyDB{storage: []*domain.Company{{ID: "id", Name: "1", Code: "1", Country: "Ukraine", CountryCode: "UA"}}}
+	company := companyService{
+		ICompany: snapshotDB{t: t, MockICompanyDB: db},
+		l:        log.StandardLogger(),
+		event:    pub,
+		locationClient: CountryResolverMock(func(ip string) (string, error) {
+			lookups++
+			return countrySuccess, nil
+		}),
+		channel:              pubChannel,
+		allowedCountriesCode: []string{countrySuccess},
+	}
+	ctx := context.WithValue(context.Background(), domain.CtxUserIPKey, netip.MustParseAddr("1.1.1.1"))
+
+	ops := []domain.BatchOperation{
+		{Action: domain.BatchCreate, Company: &domain.Company{Name: "2", Code: "2", Country: "ua"}},
+		{Action: domain.BatchUpdate, Name: "1", Code: "1", Company: &domain.Company{Name: "1", Code: "1", Country: "Cyprus"}},
+		{Action: domain.BatchCreate, Company: &domain.Company{Name: "3", Code: "3"}}, // no country
+	}
+	results, err := company.Batch(ctx, ops, &domain.BatchOptions{})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if !errors.Is(results[0].Err, domain.ErrRolledBack) || !errors.Is(results[1].Err, domain.ErrRolledBack) ||
+		!errors.Is(results[2].Err, domain.ErrValidation) {
+		t.Errorf("want the batch rolled back by the invalid company but got %+v", results)
+	}
+	if len(db.storage) != 1 || len(published) != 0 {
+		t.Errorf("nothing should be applied but got %d companies and events %+v", len(db.storage), published)
+	}
+
+	results, err = company.Batch(ctx, ops, &domain.BatchOptions{ContinueOnError: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if results[0].Err != nil || results[0].Company.Name != "2" || len(db.storage) != 1 || len(published) != 0 {
+		t.Errorf("want the dry run checked without changes but got %+v", results)
+	}
+
+	results, err = company.Batch(ctx, ops, &domain.BatchOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("error was not expected: %s", err)
+	}
+	if results[0].Err != nil || results[1].Err != nil || !errors.Is(results[2].Err, domain.ErrValidation) {
+		t.Errorf("want the first two operations applied but got %+v", results)
+	}
+	if results[0].Company.CountryCode != "UA" || results[1].Company.CountryCode != "CY" {
+		t.Errorf("want normalized companies but got %+v", results)
+	}
+	if len(published) != 2 || published[0].Type != domain.CloudEventType(domain.CreateCompany) ||
+		published[1].Type != domain.CloudEventType(domain.UpdateCompany) || published[1].Data.Before.CountryCode != "UA" ||
+		published[1].Data.After.CountryCode != "CY" || !reflect.DeepEqual(pub.batches, []int{2}) || pub.flushed != 1 {
+		t.Errorf("want create and update events in one batch flushed once but got %+v in %v flushed %d times",
+			published, pub.batches, pub.flushed)
+	}
+	if lookups != 3 {
+		t.Errorf("want one location lookup per batch but got %d", lookups)
+	}
+}
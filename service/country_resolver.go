@@ -0,0 +1,147 @@
+package service
+
+import (
+	"container/list"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolverMetrics - hits and misses of cached resolvers on /debug/vars
+var resolverMetrics = expvar.NewMap("countryResolver")
+
+// resolvedCountry checks that a resolver returned an ISO 3166-1 alpha-2 code, not an error page or a placeholder
+func resolvedCountry(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !domain.IsCountryCode(code) {
+		if len(code) > 32 {
+			code = code[:32] + "..."
+		}
+		return "", fmt.Errorf("%q is not a country code", code)
+	}
+	return code, nil
+}
+
+type cacheEntry struct {
+	ip      string
+	code    string
+	expires time.Time
+}
+
+type cachedResolver struct {
+	next domain.CountryResolver
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // the front is the most recently used
+}
+
+// NewCachedResolver caches up to size countries resolved by next for ttl, the least recently used ones are evicted
+// first. Errors are not cached.
+func NewCachedResolver(next domain.CountryResolver, size int, ttl time.Duration) domain.CountryResolver {
+	if size <= 0 {
+		size = 10000
+	}
+	return &cachedResolver{
+		next:    next,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+func (c *cachedResolver) Resolve(ip string) (string, error) {
+	if code, ok := c.get(ip); ok {
+		resolverMetrics.Add("hits", 1)
+		return code, nil
+	}
+	resolverMetrics.Add("misses", 1)
+	code, err := c.next.Resolve(ip)
+	if err != nil {
+		return "", err
+	}
+	c.put(ip, code)
+	return code, nil
+}
+
+func (c *cachedResolver) get(ip string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[ip]
+	if !ok {
+		return "", false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, ip)
+		return "", false
+	}
+	c.lru.MoveToFront(e)
+	return entry.code, true
+}
+
+func (c *cachedResolver) put(ip, code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{ip: ip, code: code, expires: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[ip]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[ip] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).ip)
+	}
+}
+
+type staticResolver string
+
+// NewStaticResolver resolves every address to the country, e.g. for local runs where addresses are private
+func NewStaticResolver(code string) (domain.CountryResolver, error) {
+	code, err := resolvedCountry(code)
+	if err != nil {
+		return nil, fmt.Errorf("static resolver: %w", err)
+	}
+	return staticResolver(code), nil
+}
+
+func (s staticResolver) Resolve(string) (string, error) {
+	return string(s), nil
+}
+
+type chainResolver struct {
+	resolvers []domain.CountryResolver
+	names     []string
+	l         *log.Logger
+	logPrefix string
+}
+
+// NewChainResolver asks resolvers in order until one of them resolves the country, names are used in logs and errors
+func NewChainResolver(names []string, resolvers []domain.CountryResolver, l *log.Logger) domain.CountryResolver {
+	return &chainResolver{resolvers: resolvers, names: names, l: l, logPrefix: "countryResolve"}
+}
+
+func (c *chainResolver) Resolve(ip string) (string, error) {
+	failures := make([]string, 0, len(c.resolvers))
+	for i, r := range c.resolvers {
+		code, err := r.Resolve(ip)
+		if err == nil {
+			return code, nil
+		}
+		c.l.Debugf("%s: %s: %s", c.logPrefix, c.names[i], err.Error())
+		failures = append(failures, c.names[i]+": "+err.Error())
+	}
+	return "", fmt.Errorf("no resolver knows the country of %s: %s", ip, strings.Join(failures, "; "))
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCachedResolver(t *testing.T) {
+	calls := map[string]int{}
+	fail := false
+	cached := NewCachedResolver(CountryResolverMock(func(ip string) (string, error) {
+		calls[ip]++
+		if fail {
+			return "", errors.New("unavailable")
+		}
+		return "UA", nil
+	}), 2, 50*time.Millisecond)
+
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "2.2.2.2", "1.1.1.1", "3.3.3.3", "1.1.1.1", "2.2.2.2"} {
+		if code, err := cached.Resolve(ip); err != nil || code != "UA" {
+			t.Fatalf("want UA for %s but got %q: %v", ip, code, err)
+		}
+	}
+	// 2.2.2.2 is evicted by 3.3.3.3 as the least recently used one
+	if calls["1.1.1.1"] != 1 || calls["2.2.2.2"] != 2 || calls["3.3.3.3"] != 1 {
+		t.Errorf("unexpected resolver calls %v", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fail = true
+	if _, err := cached.Resolve("1.1.1.1"); err == nil {
+		t.Error("an expired country must be resolved again")
+	}
+	if _, err := cached.Resolve("1.1.1.1"); err == nil || calls["1.1.1.1"] != 3 {
+		t.Errorf("errors must not be cached, calls %v", calls)
+	}
+}
+
+func TestChainResolver(t *testing.T) {
+	local := CountryResolverMock(func(ip string) (string, error) {
+		if ip == "10.0.0.1" {
+			return "", errors.New("address is not in the database")
+		}
+		return "CY", nil
+	})
+	remote := CountryResolverMock(func(ip string) (string, error) {
+		if ip == "10.0.0.1" {
+			return "", errors.New("reserved address")
+		}
+		return "UA", nil
+	})
+	chain := NewChainResolver([]string{"mmdb", "ipapi"}, []domain.CountryResolver{local, remote}, log.StandardLogger())
+	if code, err := chain.Resolve("1.2.3.4"); err != nil || code != "CY" {
+		t.Errorf("want CY of the first resolver but got %q: %v", code, err)
+	}
+	_, err := chain.Resolve("10.0.0.1")
+	if err == nil || !strings.Contains(err.Error(), "mmdb: address is not in the database; ipapi: reserved address") {
+		t.Errorf("want errors of all resolvers but got %v", err)
+	}
+}
+
+func TestIPAPIResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.2.3.4/country_code/":
+			w.Write([]byte("ua\n"))
+		case "/10.0.0.1/country_code/":
+			w.Write([]byte("Undefined"))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("XX"))
+		}
+	}))
+	defer server.Close()
+
+	resolver := GetResolverIPAPI(server.URL, 0, time.Second, log.StandardLogger())
+	if code, err := resolver.Resolve("1.2.3.4"); err != nil || code != "UA" {
+		t.Errorf("want UA but got %q: %v", code, err)
+	}
+	if code, err := resolver.Resolve("10.0.0.1"); err == nil {
+		t.Errorf("want an error for a response which is not a country code but got %q", code)
+	}
+	if code, err := resolver.Resolve("5.6.7.8"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("want an error for status 403 but got %q: %v", code, err)
+	}
+}
+
+func TestStaticResolver(t *testing.T) {
+	resolver, err := NewStaticResolver("cy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := resolver.Resolve("127.0.0.1"); err != nil || code != "CY" {
+		t.Errorf("want CY but got %q: %v", code, err)
+	}
+	if _, err := NewStaticResolver("Undefined"); err == nil {
+		t.Error("want an error for a value which is not a country code")
+	}
+}
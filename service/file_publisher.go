@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// FileRecord - a line of the file publisher, Data is the JSON data of the message
+type FileRecord struct {
+	Subject string            `json:"subject"`
+	ID      string            `json:"id,omitempty"`
+	Type    domain.EventType  `json:"type,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Header  map[string]string `json:"header,omitempty"`
+	Data    json.RawMessage   `json:"data"`
+}
+
+type filePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher appends messages to the file as newline delimited JSON records, see FileRecord.
+// Only JSON data can be published, Flush syncs the file to the disk.
+func NewFilePublisher(path string) (domain.Publisher, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open events file: %w", err)
+	}
+	return &filePublisher{file: file}, nil
+}
+
+func (f *filePublisher) Publish(subj string, data []byte) error {
+	return f.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (f *filePublisher) PublishMessage(subj string, msg *domain.Message) error {
+	if !json.Valid(msg.Data) {
+		return fmt.Errorf("write message %s to file: data is not JSON", msg.ID)
+	}
+	line, err := json.Marshal(FileRecord{
+		Subject: subj,
+		ID:      msg.ID,
+		Type:    msg.Type,
+		Key:     msg.Key,
+		Header:  msg.Header,
+		Data:    msg.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("write message %s to file: %w", msg.ID, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// a single write per line, so the lines of concurrent writers are not mixed
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write message %s to file: %w", msg.ID, err)
+	}
+	return nil
+}
+
+func (f *filePublisher) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
@@ -6,6 +6,9 @@ import (
 	retry "github.com/hashicorp/go-retryablehttp"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 type ipAPIResolver struct {
@@ -15,25 +18,31 @@ type ipAPIResolver struct {
 	logPrefix string
 }
 
-func GetResolverIPAPI(url string, requestAttempt int, l *log.Logger) domain.CountryResolver {
+// GetResolverIPAPI resolves countries with the ipapi.co API, a request waits timeout (zero is no timeout)
+// and is retried requestAttempt times on network errors, 429 and 5xx responses
+func GetResolverIPAPI(url string, requestAttempt int, timeout time.Duration, l *log.Logger) domain.CountryResolver {
 	client := retry.NewClient()
 	client.RetryMax = requestAttempt
+	client.HTTPClient.Timeout = timeout
 	client.Logger = l
 	return &ipAPIResolver{client: client, url: url, l: l, logPrefix: "countryResolve"}
 }
 
 func (i *ipAPIResolver) Resolve(ip string) (string, error) {
 	//GET https://ipapi.co/{ip}/country_code/
-	uri := fmt.Sprintf("%s/%s/country_code/", i.url, ip)
+	uri := fmt.Sprintf("%s/%s/country_code/", i.url, url.PathEscape(ip))
 	resp, err := i.client.Get(uri)
 	if err != nil {
 		return "", fmt.Errorf("get country from remote api: %w", err)
 	}
 	defer resp.Body.Close()
-	code, err := io.ReadAll(resp.Body)
+	code, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
 	if err != nil {
 		return "", fmt.Errorf("read responce body: %w", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get country from remote api: response status %s", resp.Status)
+	}
 	i.l.Tracef("%s: result country code: %s", i.logPrefix, code)
-	return string(code), nil
+	return resolvedCountry(string(code))
 }
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// jetStream - the part of nats.JetStreamContext used by the publisher
+type jetStream interface {
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+}
+
+type jetStreamPublisher struct {
+	js            jetStream
+	stream        string
+	channel       string
+	ackTimeout    time.Duration
+	retryAttempts int
+	retryWait     time.Duration
+	l             *log.Logger
+	logPrefix     string
+
+	mu    sync.Mutex
+	ready bool // the stream is created or checked
+}
+
+// StreamName returns the default JetStream stream name of a channel, e.g. COMPANIES for companies
+func StreamName(channel string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(channel))
+}
+
+// NewJetStreamPublisher publishes events to the stream which captures channel and channel.> subjects.
+// Events are published to channel.<event type>, e.g. companies.update, with the message id in Nats-Msg-Id,
+// so the server drops duplicates. A publish waits for the ack up to ackTimeout and is retried retryAttempts times.
+// The stream is created, or checked if it exists, on the first publish.
+func NewJetStreamPublisher(
+	conn *nats.Conn,
+	stream, channel string,
+	ackTimeout time.Duration,
+	retryAttempts int,
+	l *log.Logger,
+) (domain.Publisher, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("create jetstream context: %w", err)
+	}
+	return newJetStreamPublisher(js, stream, channel, ackTimeout, retryAttempts, l), nil
+}
+
+func newJetStreamPublisher(js jetStream, stream, channel string, ackTimeout time.Duration, retryAttempts int, l *log.Logger) *jetStreamPublisher {
+	if stream == "" {
+		stream = StreamName(channel)
+	}
+	return &jetStreamPublisher{
+		js:            js,
+		stream:        stream,
+		channel:       channel,
+		ackTimeout:    ackTimeout,
+		retryAttempts: retryAttempts,
+		retryWait:     100 * time.Millisecond,
+		l:             l,
+		logPrefix:     "jetStreamPublisher",
+	}
+}
+
+// ensureStream creates the stream or checks that it captures the channel subjects
+func (j *jetStreamPublisher) ensureStream() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.ready {
+		return nil
+	}
+	subjects := []string{j.channel, j.channel + ".>"}
+	info, err := j.js.StreamInfo(j.stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		j.l.Infof("%s: create stream %s for %s", j.logPrefix, j.stream, strings.Join(subjects, ", "))
+		_, err = j.js.AddStream(&nats.StreamConfig{
+			Name:       j.stream,
+			Subjects:   subjects,
+			Storage:    nats.FileStorage,
+			Duplicates: 2 * time.Minute,
+		})
+		if err != nil {
+			return fmt.Errorf("create stream %s: %w", j.stream, err)
+		}
+		j.ready = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get stream %s: %w", j.stream, err)
+	}
+	for _, subject := range subjects {
+		if !hasSubject(info.Config.Subjects, subject) {
+			return fmt.Errorf("stream %s doesn't capture subject %s", j.stream, subject)
+		}
+	}
+	j.ready = true
+	return nil
+}
+
+func hasSubject(subjects []string, subject string) bool {
+	for _, s := range subjects {
+		if s == subject || s == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *jetStreamPublisher) Publish(subj string, data []byte) error {
+	return j.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (j *jetStreamPublisher) PublishMessage(subj string, msg *domain.Message) error {
+	if err := j.ensureStream(); err != nil {
+		return err
+	}
+	if msg.Type != "" {
+		subj += "." + string(msg.Type)
+	}
+	m := nats.NewMsg(subj)
+	m.Data = msg.Data
+	for k, v := range msg.Header {
+		m.Header.Set(k, v)
+	}
+	if msg.ID != "" {
+		m.Header.Set(nats.MsgIdHdr, msg.ID)
+	}
+	var err error
+	for attempt := 0; attempt <= j.retryAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(j.retryWait << (attempt - 1))
+		}
+		var ack *nats.PubAck
+		if ack, err = j.js.PublishMsg(m, nats.AckWait(j.ackTimeout)); err == nil {
+			if ack.Duplicate {
+				j.l.Debugf("%s: message %s is already in stream %s", j.logPrefix, msg.ID, ack.Stream)
+			}
+			return nil
+		}
+		j.l.Infof("%s: publish to %s, attempt %d: %s", j.logPrefix, subj, attempt+1, err.Error())
+	}
+	return fmt.Errorf("publish to %s: %w", subj, err)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+type jetStreamMock struct {
+	streams   map[string]*nats.StreamConfig
+	published []*nats.Msg
+	failures  int
+}
+
+func (j *jetStreamMock) StreamInfo(stream string, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	cfg, ok := j.streams[stream]
+	if !ok {
+		return nil, nats.ErrStreamNotFound
+	}
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func (j *jetStreamMock) AddStream(cfg *nats.StreamConfig, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	j.streams[cfg.Name] = cfg
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func (j *jetStreamMock) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	if j.failures > 0 {
+		j.failures--
+		return nil, nats.ErrTimeout
+	}
+	j.published = append(j.published, m)
+	return &nats.PubAck{Stream: "COMPANIES"}, nil
+}
+
+func TestJetStreamPublisher(t *testing.T) {
+	js := &jetStreamMock{streams: map[string]*nats.StreamConfig{}, failures: 1}
+	pub := newJetStreamPublisher(js, "", "companies", 0, 2, log.StandardLogger())
+	pub.retryWait = 0
+
+	msg := domain.Message{ID: "42", Type: domain.UpdateCompany, Data: []byte(`{}`)}
+	if err := domain.PublishMessage(pub, "companies", &msg); err != nil {
+		t.Fatalf("publish should be retried but got %s", err)
+	}
+	cfg, ok := js.streams["COMPANIES"]
+	if !ok || !hasSubject(cfg.Subjects, "companies.>") || cfg.Duplicates == 0 {
+		t.Fatalf("want stream COMPANIES with companies.> subjects and deduplication but got %+v", js.streams)
+	}
+	if len(js.published) != 1 || js.published[0].Subject != "companies.update" ||
+		js.published[0].Header.Get(nats.MsgIdHdr) != "42" {
+		t.Errorf("want message 42 on companies.update but got %+v", js.published)
+	}
+
+	js.failures = 3
+	if err := pub.Publish("companies", []byte(`{}`)); !errors.Is(err, nats.ErrTimeout) {
+		t.Errorf("want timeout after all attempts but got %v", err)
+	}
+}
+
+func TestJetStreamPublisherChecksStream(t *testing.T) {
+	js := &jetStreamMock{streams: map[string]*nats.StreamConfig{
+		"EVENTS": {Name: "EVENTS", Subjects: []string{"companies"}},
+	}}
+	pub := newJetStreamPublisher(js, "EVENTS", "companies", 0, 0, log.StandardLogger())
+	if err := pub.Publish("companies", []byte(`{}`)); err == nil {
+		t.Error("the stream without companies.> subjects must be rejected")
+	}
+	if len(js.published) != 0 {
+		t.Errorf("nothing should be published but got %d messages", len(js.published))
+	}
+}
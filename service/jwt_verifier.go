@@ -0,0 +1,255 @@
+package service
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+// JWTKey - a key which can be used to check token signatures
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Secret    []byte         // HS256 only
+	PublicKey *rsa.PublicKey // RS256 only
+}
+
+type jwtVerifier struct {
+	keys       []JWTKey
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	requireExp bool
+	now        func() time.Time
+	l          *log.Logger
+	logPrefix  string
+}
+
+// NewJWTVerifier checks tokens signed by keys, tokens without exp are rejected when requireExp is set
+func NewJWTVerifier(keys []JWTKey, issuer, audience string, leeway time.Duration, requireExp bool, l *log.Logger) domain.TokenVerifier {
+	return &jwtVerifier{
+		keys:       keys,
+		issuer:     issuer,
+		audience:   audience,
+		leeway:     leeway,
+		requireExp: requireExp,
+		now:        time.Now,
+		l:          l,
+		logPrefix:  "jwtVerifier",
+	}
+}
+
+// LoadRSAPublicKey reads a PEM encoded RSA public key (PKIX or PKCS1) or certificate
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key file %s is not PEM encoded", path)
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		if key, ok := key.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("public key in %s is not an RSA key", path)
+}
+
+// LoadJWKS reads RSA ("kty":"RSA") and symmetric ("kty":"oct") keys from a local JWKS file.
+// Encryption keys and keys of other algorithms (e.g. an RSA key with "alg":"RS512") are skipped.
+func LoadJWKS(path string) ([]JWTKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks file: %w", err)
+	}
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == AlgRS256):
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("decode modulus of key %s: %w", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("decode exponent of key %s: %w", k.Kid, err)
+			}
+			keys = append(keys, JWTKey{
+				ID:        k.Kid,
+				Algorithm: AlgRS256,
+				PublicKey: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())},
+			})
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == AlgHS256):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("decode secret of key %s: %w", k.Kid, err)
+			}
+			keys = append(keys, JWTKey{ID: k.Kid, Algorithm: AlgHS256, Secret: secret})
+		}
+	}
+	return keys, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (j *jwtVerifier) Verify(token string) (domain.Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token must consist of three parts")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode token signature: %w", err)
+	}
+	if err := j.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims domain.Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode token claims: %w", err)
+	}
+	if err := j.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	j.l.Tracef("%s: token of %s verified", j.logPrefix, claims.Subject())
+	return claims, nil
+}
+
+func (j *jwtVerifier) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	if header.Alg != AlgHS256 && header.Alg != AlgRS256 {
+		return fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	digest := sha256.Sum256([]byte(signed))
+	for _, key := range j.keys {
+		// The algorithm is bound to the key so an RSA public key can never be used as an HMAC secret
+		if key.Algorithm != header.Alg || (header.Kid != "" && key.ID != "" && key.ID != header.Kid) {
+			continue
+		}
+		switch key.Algorithm {
+		case AlgHS256:
+			mac := hmac.New(sha256.New, key.Secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+		case AlgRS256:
+			if key.PublicKey != nil && rsa.VerifyPKCS1v15(key.PublicKey, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid token signature")
+}
+
+func (j *jwtVerifier) validateClaims(claims domain.Claims) error {
+	now := j.now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok && j.requireExp {
+		return errors.New("token has no expiration time")
+	}
+	if ok && now.After(exp.Add(j.leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(j.leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	if j.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.issuer {
+			return fmt.Errorf("unexpected token issuer %q", iss)
+		}
+	}
+	if j.audience != "" && !hasAudience(claims["aud"], j.audience) {
+		return errors.New("token is not issued for this audience")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func numericDate(v any) (time.Time, bool) {
+	sec, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(sec), 0), true
+}
+
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for i := range v {
+			if s, ok := v[i].(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
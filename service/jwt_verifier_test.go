@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func signToken(t *testing.T, alg, kid string, claims map[string]any, sign func(data []byte) []byte) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	secret := []byte("secret")
+	verifier := NewJWTVerifier(
+		[]JWTKey{{ID: "k1", Algorithm: AlgHS256, Secret: secret}},
+		"issuer", "companysvc", 0, true, log.StandardLogger(),
+	)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	cases := []struct {
+		name    string
+		token   string
+		success bool
+	}{
+		{
+			name: "valid",
+			token: signToken(t, AlgHS256, "k1", map[string]any{
+				"sub": "user", "iss": "issuer", "aud": "companysvc", "exp": exp, "scope": "company:read",
+			}, hs256(secret)),
+			success: true,
+		},
+		{
+			name: "audience list",
+			token: signToken(t, AlgHS256, "", map[string]any{
+				"sub": "user", "iss": "issuer", "aud": []string{"other", "companysvc"}, "exp": exp,
+			}, hs256(secret)),
+			success: true,
+		},
+		{
+			name: "without expiration",
+			token: signToken(t, AlgHS256, "k1", map[string]any{
+				"sub": "user", "iss": "issuer", "aud": "companysvc",
+			}, hs256(secret)),
+		},
+		{
+			name: "wrong secret",
+			token: signToken(t, AlgHS256, "k1", map[string]any{
+				"sub": "user", "iss": "issuer", "aud": "companysvc", "exp": exp,
+			}, hs256([]byte("other"))),
+		},
+		{
+			name: "expired",
+			token: signToken(t, AlgHS256, "k1", map[string]any{
+				"sub": "user", "iss": "issuer", "aud": "companysvc", "exp": float64(time.Now().Add(-time.Hour).Unix()),
+			}, hs256(secret)),
+		},
+		{
+			name: "wrong issuer",
+			token: signToken(t, AlgHS256, "k1", map[string]any{
+				"sub": "user", "iss": "other", "aud": "companysvc", "exp": exp,
+			}, hs256(secret)),
+		},
+		{
+			name:  "malformed",
+			token: "abc.def",
+		},
+	}
+	for _, c := range cases {
+		claims, err := verifier.Verify(c.token)
+		if c.success && err != nil {
+			t.Errorf("case %s: %s", c.name, err.Error())
+		}
+		if !c.success && err == nil {
+			t.Errorf("case %s should be fail but not", c.name)
+		}
+		if c.success && claims.Subject() != "user" {
+			t.Errorf("case %s: want subject user but got %s", c.name, claims.Subject())
+		}
+	}
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	verifier := NewJWTVerifier(
+		[]JWTKey{{ID: "rsa", Algorithm: AlgRS256, PublicKey: &key.PublicKey}},
+		"", "", 0, false, log.StandardLogger(),
+	)
+	token := signToken(t, AlgRS256, "rsa", map[string]any{"sub": "service", "scp": []string{"company:write"}}, rs256)
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatalf("RS256 token should be valid: %s", err.Error())
+	}
+	if !claims.HasScopes("company:write") {
+		t.Errorf("want scope company:write but got %v", claims.Scopes())
+	}
+	// An RSA key must never be accepted as an HMAC secret
+	forged := signToken(t, AlgHS256, "rsa", map[string]any{"sub": "attacker"}, hs256(key.PublicKey.N.Bytes()))
+	if _, err := verifier.Verify(forged); err == nil {
+		t.Error("HS256 token signed with the RSA public key should be rejected")
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes())
+	secret := base64.RawURLEncoding.EncodeToString([]byte("secret"))
+	jwks := `{"keys": [
+		{"kty": "RSA", "kid": "rsa", "n": "` + n + `", "e": "AQAB"},
+		{"kty": "RSA", "kid": "rsa-sig", "alg": "RS256", "use": "sig", "n": "` + n + `", "e": "AQAB"},
+		{"kty": "RSA", "kid": "rsa-enc", "use": "enc", "n": "` + n + `", "e": "AQAB"},
+		{"kty": "RSA", "kid": "rs512", "alg": "RS512", "n": "` + n + `", "e": "AQAB"},
+		{"kty": "RSA", "kid": "ps256", "alg": "PS256", "n": "` + n + `", "e": "AQAB"},
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": "` + secret + `"},
+		{"kty": "oct", "kid": "hs512", "alg": "HS512", "k": "` + secret + `"},
+		{"kty": "EC", "kid": "ec", "alg": "ES256"}
+	]}`
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, k := range keys {
+		got[k.ID] = k.Algorithm
+	}
+	want := map[string]string{"rsa": AlgRS256, "rsa-sig": AlgRS256, "hmac": AlgHS256}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want keys %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// MemoryMessage - a message published to the memory bus
+type MemoryMessage struct {
+	Subject string
+	domain.Message
+}
+
+// MemoryBus - an in-process publisher and subscriber for tests and local runs. Messages are delivered to
+// the subscriptions synchronously by the publish, a failed message is not redelivered. Subjects of
+// subscriptions can have NATS wildcards, e.g. companies.> or companies.*.
+type MemoryBus struct {
+	mu       sync.Mutex
+	messages []MemoryMessage
+	history  int // how many last messages are kept for Messages
+	subs     []*memorySubscription
+	next     map[string]int // round robin position of queue groups
+}
+
+type memorySubscription struct {
+	bus     *MemoryBus
+	subject string
+	group   string
+	handler func(*domain.Message) error
+}
+
+// NewMemoryBus keeps the last history messages for Messages, zero keeps none, so a long local run
+// doesn't grow the memory
+func NewMemoryBus(history int) *MemoryBus {
+	return &MemoryBus{history: history, next: map[string]int{}}
+}
+
+func (b *MemoryBus) Publish(subj string, data []byte) error {
+	return b.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (b *MemoryBus) PublishMessage(subj string, msg *domain.Message) error {
+	m := MemoryMessage{Subject: subj, Message: *msg}
+	if msg.Header != nil {
+		m.Header = make(map[string]string, len(msg.Header))
+		for k, v := range msg.Header {
+			m.Header[k] = v
+		}
+	}
+	m.Data = append([]byte(nil), msg.Data...)
+
+	b.mu.Lock()
+	if b.history > 0 {
+		if len(b.messages) == b.history {
+			copy(b.messages, b.messages[1:])
+			b.messages = b.messages[:len(b.messages)-1]
+		}
+		b.messages = append(b.messages, m)
+	}
+	var handlers []func(*domain.Message) error
+	groups := map[string][]*memorySubscription{}
+	for _, s := range b.subs {
+		if !matchSubject(s.subject, subj) {
+			continue
+		}
+		if s.group == "" {
+			handlers = append(handlers, s.handler)
+			continue
+		}
+		groups[s.group] = append(groups[s.group], s)
+	}
+	for group, members := range groups {
+		handlers = append(handlers, members[b.next[group]%len(members)].handler)
+		b.next[group]++
+	}
+	b.mu.Unlock()
+
+	// handlers are called without the lock, so they can publish too
+	for _, h := range handlers {
+		delivered := m.Message
+		h(&delivered)
+	}
+	return nil
+}
+
+// Subscribe receives messages of the subject, every message is delivered to one member of a queue group
+func (b *MemoryBus) Subscribe(subj, group string, handler func(*domain.Message) error) (domain.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &memorySubscription{bus: b, subject: subj, group: group, handler: handler}
+	b.subs = append(b.subs, s)
+	return s, nil
+}
+
+// Messages returns the last published messages in order
+func (b *MemoryBus) Messages() []MemoryMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]MemoryMessage(nil), b.messages...)
+}
+
+func (s *memorySubscription) Drain() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for i, sub := range s.bus.subs {
+		if sub == s {
+			s.bus.subs = append(s.bus.subs[:i], s.bus.subs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// matchSubject matches a subject with a NATS pattern, * matches a token and > matches the rest of tokens
+func matchSubject(pattern, subject string) bool {
+	patternTokens, tokens := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, p := range patternTokens {
+		if p == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(tokens)
+}
@@ -0,0 +1,22 @@
+package service
+
+import "testing"
+
+func TestMemoryBusHistory(t *testing.T) {
+	bus := NewMemoryBus(2)
+	for _, data := range []string{"1", "2", "3"} {
+		if err := bus.Publish("companies", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := bus.Messages()
+	if len(messages) != 2 || string(messages[0].Data) != "2" || string(messages[1].Data) != "3" {
+		t.Errorf("want the last 2 messages, got %+v", messages)
+	}
+
+	bus = NewMemoryBus(0)
+	bus.Publish("companies", []byte("1"))
+	if messages = bus.Messages(); len(messages) != 0 {
+		t.Errorf("no history must keep no messages, got %+v", messages)
+	}
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+)
+
+// mmdbMetadataMarker - the metadata of a MaxMind DB follows the last occurrence of the marker
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// errMMDBNotFound - the database has no network of the address
+var errMMDBNotFound = errors.New("address is not in the database")
+
+type mmdbResolver struct {
+	db           []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	dataStart    uint
+	ipv4Start    uint // the node of ::/96 where IPv4 addresses start in an IPv6 tree
+	databaseType string
+}
+
+// NewMMDBResolver resolves countries offline with a MaxMind DB (.mmdb) file, e.g. GeoLite2-Country or GeoLite2-City.
+// The country of the network is used, the registered country if it is unknown. The file is read in memory.
+func NewMMDBResolver(path string) (domain.CountryResolver, error) {
+	db, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mmdb: %w", err)
+	}
+	r, err := newMMDBResolver(db)
+	if err != nil {
+		return nil, fmt.Errorf("open mmdb %s: %w", path, err)
+	}
+	return r, nil
+}
+
+func newMMDBResolver(db []byte) (*mmdbResolver, error) {
+	i := bytes.LastIndex(db, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("metadata is not found")
+	}
+	metaStart := uint(i + len(mmdbMetadataMarker))
+	d := mmdbDecoder{buf: db[metaStart:]}
+	value, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	meta, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	r := &mmdbResolver{db: db}
+	var found bool
+	if r.nodeCount, found = mmdbUint(meta["node_count"]); !found {
+		return nil, errors.New("node_count is not defined")
+	}
+	if r.recordSize, found = mmdbUint(meta["record_size"]); !found {
+		return nil, errors.New("record_size is not defined")
+	}
+	if r.ipVersion, found = mmdbUint(meta["ip_version"]); !found {
+		return nil, errors.New("ip_version is not defined")
+	}
+	r.databaseType, _ = meta["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > metaStart {
+		return nil, errors.New("search tree is larger than the database")
+	}
+	r.dataStart = treeSize + 16
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func mmdbUint(v any) (uint, bool) {
+	n, ok := v.(uint64)
+	return uint(n), ok
+}
+
+func (r *mmdbResolver) Resolve(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid ip %q", ip)
+	}
+	record, err := r.lookup(addr.Unmap())
+	if err != nil {
+		return "", fmt.Errorf("lookup %s in %s: %w", ip, r.databaseType, err)
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]any)
+		if code, ok := country["iso_code"].(string); ok && code != "" {
+			return resolvedCountry(code)
+		}
+	}
+	return "", fmt.Errorf("lookup %s in %s: no country", ip, r.databaseType)
+}
+
+// lookup walks the search tree by the address bits and decodes the data record of the network
+func (r *mmdbResolver) lookup(addr netip.Addr) (map[string]any, error) {
+	node := uint(0)
+	if addr.Is4() {
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, errors.New("IPv6 address in an IPv4 database")
+	}
+	ip := addr.AsSlice()
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, errMMDBNotFound
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("search tree is too deep")
+	}
+	offset := node - r.nodeCount - 16
+	d := mmdbDecoder{buf: r.db[r.dataStart:]}
+	value, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decode record: %w", err)
+	}
+	record, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("record is not a map")
+	}
+	return record, nil
+}
+
+// record returns the left (bit 0) or the right (bit 1) record of the node
+func (r *mmdbResolver) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.db[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.db[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	b := r.db[node*8+bit*4:]
+	return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3])
+}
+
+// mmdbDecoder decodes values of the MaxMind DB data section format, pointers are offsets in buf
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const mmdbMaxDepth = 32
+
+func (d *mmdbDecoder) bytes(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buf)) || offset+size < offset {
+		return nil, errors.New("value is out of the data section")
+	}
+	return d.buf[offset : offset+size], nil
+}
+
+func (d *mmdbDecoder) uint(offset, size uint) (uint64, error) {
+	b, err := d.bytes(offset, size)
+	if err != nil || size > 8 {
+		return 0, errors.New("invalid unsigned integer")
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// decode returns the value at offset and the offset after it
+func (d *mmdbDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data is nested too deep")
+	}
+	ctrl, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+	typ, size := uint(ctrl[0]>>5), uint(ctrl[0]&0x1f)
+	if typ == 1 {
+		return d.decodePointer(offset, size, depth)
+	}
+	if typ == 0 {
+		ext, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ, offset = 7+uint(ext[0]), offset+1
+	}
+	if size >= 29 {
+		n := size - 28
+		extra, err := d.uint(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		size = [...]uint{29, 285, 65821}[n-1] + uint(extra)
+	}
+	switch typ {
+	case 2:
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case 3:
+		n, err := d.uint(offset, 8)
+		return math.Float64frombits(n), offset + 8, err
+	case 4, 10: // bytes and uint128
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case 5, 6, 9:
+		n, err := d.uint(offset, size)
+		return n, offset + size, err
+	case 8:
+		n, err := d.uint(offset, size)
+		return int32(n), offset + size, err
+	case 15:
+		n, err := d.uint(offset, 4)
+		return math.Float32frombits(uint32(n)), offset + 4, err
+	case 14:
+		return size != 0, offset, nil
+	case 7:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if m[k], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v any
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+func (d *mmdbDecoder) decodePointer(offset, size uint, depth int) (any, uint, error) {
+	n := (size>>3)&3 + 1
+	b, err := d.uint(offset, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	pointer := uint(b)
+	switch n {
+	case 1, 2, 3:
+		pointer |= (size & 7) << (8 * n)
+		pointer += [...]uint{0, 2048, 526336}[n-1]
+	}
+	value, _, err := d.decode(pointer, depth+1)
+	return value, offset + n, err
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbWriter builds a small MaxMind DB with 24 bit records
+type mmdbWriter struct {
+	ipVersion int
+	nodes     [][2]int // negative records point to data, -1 - offset
+	data      bytes.Buffer
+}
+
+func mmdbControl(buf *bytes.Buffer, typ, size int) {
+	if typ <= 7 {
+		buf.WriteByte(byte(typ<<5 | size))
+		return
+	}
+	buf.WriteByte(byte(size))
+	buf.WriteByte(byte(typ - 7))
+}
+
+// mmdbPointer - an offset of a value in the data section
+type mmdbPointer int
+
+func mmdbEncode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case mmdbPointer:
+		if v < 2048 {
+			buf.Write([]byte{byte(1<<5 | int(v)>>8&7), byte(v)})
+			return
+		}
+		v -= 2048
+		buf.Write([]byte{byte(1<<5 | 1<<3 | int(v)>>16&7), byte(v >> 8), byte(v)})
+	case string:
+		mmdbControl(buf, 2, len(v))
+		buf.WriteString(v)
+	case int:
+		var b []byte
+		for n := v; n > 0; n >>= 8 {
+			b = append([]byte{byte(n)}, b...)
+		}
+		mmdbControl(buf, 6, len(b))
+		buf.Write(b)
+	case []string:
+		mmdbControl(buf, 11, len(v))
+		for _, s := range v {
+			mmdbEncode(buf, s)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mmdbControl(buf, 7, len(v))
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	}
+}
+
+func (w *mmdbWriter) insert(prefix string, record any) {
+	p := netip.MustParsePrefix(prefix)
+	ip := p.Addr().AsSlice()
+	bits := p.Bits()
+	if w.ipVersion == 6 && p.Addr().Is4() {
+		ip, bits = append(make([]byte, 12), ip...), bits+96 // ::a.b.c.d as MaxMind DBs keep IPv4 networks
+	}
+	offset := w.data.Len()
+	mmdbEncode(&w.data, record)
+	if len(w.nodes) == 0 {
+		w.nodes = append(w.nodes, [2]int{0, 0})
+	}
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(ip[i/8]>>(7-i%8)) & 1
+		if i == bits-1 {
+			w.nodes[node][bit] = -1 - offset
+			return
+		}
+		if w.nodes[node][bit] <= 0 {
+			w.nodes = append(w.nodes, [2]int{0, 0})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func (w *mmdbWriter) bytes() []byte {
+	var db bytes.Buffer
+	count := len(w.nodes)
+	for _, n := range w.nodes {
+		for _, r := range n {
+			switch {
+			case r < 0:
+				r = count + 16 + (-1 - r)
+			case r == 0:
+				r = count // empty
+			}
+			db.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(w.data.Bytes())
+	db.Write(mmdbMetadataMarker)
+	mmdbEncode(&db, map[string]any{
+		"node_count":                  count,
+		"record_size":                 24,
+		"ip_version":                  w.ipVersion,
+		"database_type":               "GeoLite2-Country",
+		"languages":                   []string{"en"},
+		"binary_format_major_version": 2,
+	})
+	return db.Bytes()
+}
+
+func TestMMDBResolver(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		w := &mmdbWriter{ipVersion: ipVersion}
+		w.insert("1.2.3.0/24", map[string]any{"country": map[string]any{"iso_code": "UA"}})
+		w.insert("10.0.0.0/8", mmdbPointer(0)) // the record of 1.2.3.0/24
+		w.data.Write(make([]byte, 4096))
+		far := w.data.Len()
+		w.insert("11.0.0.0/8", map[string]any{"country": map[string]any{"iso_code": "CY"}})
+		w.insert("12.0.0.0/8", mmdbPointer(far))
+		w.insert("5.0.0.0/8", map[string]any{"registered_country": map[string]any{"iso_code": "cy"}})
+		w.insert("9.9.9.9/32", map[string]any{"country": map[string]any{"iso_code": "XX"}})
+		if ipVersion == 6 {
+			w.insert("2a02:2378::/32", map[string]any{"country": map[string]any{"iso_code": "UA"}})
+		}
+		path := filepath.Join(t.TempDir(), "test.mmdb")
+		if err := os.WriteFile(path, w.bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		resolver, err := NewMMDBResolver(path)
+		if err != nil {
+			t.Fatalf("IPv%d: open database: %s", ipVersion, err.Error())
+		}
+		cases := map[string]string{"1.2.3.4": "UA", "1.2.3.255": "UA", "5.6.7.8": "CY", "::ffff:1.2.3.4": "UA", "10.1.1.1": "UA", "12.0.0.1": "CY"}
+		if ipVersion == 6 {
+			cases["2a02:2378:1::1"] = "UA"
+		}
+		for ip, want := range cases {
+			if got, err := resolver.Resolve(ip); err != nil || got != want {
+				t.Errorf("IPv%d: want %s for %s but got %q: %v", ipVersion, want, ip, got, err)
+			}
+		}
+		for _, ip := range []string{"1.2.4.1", "9.9.9.9", "2001:db8::1", "not-ip"} {
+			if code, err := resolver.Resolve(ip); err == nil {
+				t.Errorf("IPv%d: want an error for %s but got %s", ipVersion, ip, code)
+			}
+		}
+	}
+	if _, err := newMMDBResolver([]byte("not a database")); err == nil {
+		t.Error("want an error for a file without metadata")
+	}
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/nats-io/nats.go"
+)
+
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATSPublisher publishes to core NATS, message headers are sent as NATS headers
+func NewNATSPublisher(conn *nats.Conn) domain.Publisher {
+	return &natsPublisher{conn: conn}
+}
+
+func (n *natsPublisher) Publish(subj string, data []byte) error {
+	return n.conn.Publish(subj, data)
+}
+
+func (n *natsPublisher) PublishMessage(subj string, msg *domain.Message) error {
+	m := nats.NewMsg(subj)
+	m.Data = msg.Data
+	for k, v := range msg.Header {
+		m.Header.Set(k, v)
+	}
+	return n.conn.PublishMsg(m)
+}
+
+func (n *natsPublisher) Flush() error {
+	return n.conn.Flush()
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/nats-io/nats.go"
+)
+
+const drainTimeout = 30 * time.Second
+
+type natsSubscriber struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext // nil for core NATS
+}
+
+// NewNATSSubscriber subscribes to core NATS queue groups, messages are not redelivered
+func NewNATSSubscriber(conn *nats.Conn) domain.Subscriber {
+	return &natsSubscriber{conn: conn}
+}
+
+// NewJetStreamSubscriber subscribes by durable JetStream consumers named by the group. A new consumer receives
+// only new messages, a message is acked when the handler succeeds and redelivered when it fails.
+func NewJetStreamSubscriber(conn *nats.Conn) (domain.Subscriber, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("create jetstream context: %w", err)
+	}
+	return &natsSubscriber{conn: conn, js: js}, nil
+}
+
+func (n *natsSubscriber) Subscribe(subj, group string, handler func(msg *domain.Message) error) (domain.Subscription, error) {
+	cb := func(m *nats.Msg) {
+		msg := &domain.Message{Header: make(map[string]string, len(m.Header)), Data: m.Data}
+		for k := range m.Header {
+			msg.Header[strings.ToLower(k)] = m.Header.Get(k)
+		}
+		msg.ID = msg.Header[strings.ToLower(nats.MsgIdHdr)]
+		err := handler(msg)
+		if n.js == nil {
+			return
+		}
+		if err != nil {
+			m.Nak()
+		} else {
+			m.Ack()
+		}
+	}
+	var (
+		sub *nats.Subscription
+		err error
+	)
+	if n.js == nil {
+		sub, err = n.conn.QueueSubscribe(subj, group, cb)
+	} else {
+		sub, err = n.js.QueueSubscribe(subj, group, cb, nats.Durable(group), nats.ManualAck(), nats.DeliverNew())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to %s: %w", subj, err)
+	}
+	return &natsSubscription{sub: sub}, nil
+}
+
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+func (n *natsSubscription) Drain() error {
+	if err := n.sub.Drain(); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(drainTimeout); n.sub.IsValid(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("drain subscription to %s: timeout", n.sub.Subject)
+		}
+	}
+	return nil
+}
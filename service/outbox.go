@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	outboxBatchSize  = 100
+	outboxMaxBackoff = time.Minute
+	outboxPruneEvery = time.Hour
+)
+
+// Outbox metrics are published by expvar as "outbox", e.g. on /debug/vars
+var (
+	outboxPending    = new(expvar.Int)
+	outboxLagSeconds = new(expvar.Float) // age of the oldest unpublished event
+	outboxPublished  = new(expvar.Int)
+	outboxFailures   = new(expvar.Int)
+	outboxParked     = new(expvar.Int)
+)
+
+func init() {
+	metrics := expvar.NewMap("outbox")
+	metrics.Set("pending", outboxPending)
+	metrics.Set("lagSeconds", outboxLagSeconds)
+	metrics.Set("published", outboxPublished)
+	metrics.Set("failures", outboxFailures)
+	metrics.Set("parked", outboxParked)
+}
+
+// RunOutboxRelay publishes events of the outbox every interval until ctx is done. Relays of all replicas share
+// the events, see domain.Outbox.Claim. A failed event is retried with a doubling delay up to outboxMaxBackoff
+// and later events of its company wait for it; after maxAttempts (zero is unlimited) it is parked.
+// Events published more than retention ago are removed, zero retention keeps them. Events are delivered
+// at least once: an event may be published again if it can't be marked sent.
+func RunOutboxRelay(
+	ctx context.Context,
+	outbox domain.Outbox,
+	publisher domain.Publisher,
+	channel string,
+	format domain.EventFormat,
+	interval, retention time.Duration,
+	maxAttempts int,
+	l *log.Logger,
+) {
+	const logPrefix = "outbox"
+	var lastPrune time.Time
+	backoff := interval
+	for {
+		wait := interval
+		n, err := relayOutbox(ctx, outbox, publisher, channel, format, interval, maxAttempts, l)
+		outboxPublished.Add(int64(n))
+		if err != nil {
+			outboxFailures.Add(1)
+			l.Warnf("%s: relay events: %s, retry in %s", logPrefix, err.Error(), backoff)
+			wait = backoff
+			if backoff *= 2; backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+		} else {
+			backoff = interval
+			if n == outboxBatchSize {
+				wait = 0 // there are more pending events
+			}
+		}
+		if stats, err := outbox.Stats(ctx); err != nil {
+			l.Warnf("%s: get stats: %s", logPrefix, err.Error())
+		} else {
+			outboxPending.Set(stats.Pending)
+			outboxParked.Set(stats.Parked)
+			lag := 0.0
+			if stats.Oldest != nil {
+				lag = time.Since(*stats.Oldest).Seconds()
+			}
+			outboxLagSeconds.Set(lag)
+		}
+		if retention > 0 && time.Since(lastPrune) > outboxPruneEvery {
+			lastPrune = time.Now()
+			if n, err := outbox.Prune(ctx, lastPrune.Add(-retention)); err != nil {
+				l.Warnf("%s: remove sent events: %s", logPrefix, err.Error())
+			} else if n > 0 {
+				l.Infof("%s: %d events sent more than %s ago are removed", logPrefix, n, retention)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// relayOutbox publishes claimed events in order. After a failed event the later events of its company
+// are skipped, so events of a company are never reordered; an event which can't be encoded is parked at once.
+func relayOutbox(
+	ctx context.Context,
+	outbox domain.Outbox,
+	publisher domain.Publisher,
+	channel string,
+	format domain.EventFormat,
+	retryWait time.Duration,
+	maxAttempts int,
+	l *log.Logger,
+) (int, error) {
+	messages, err := outbox.Claim(ctx, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	sent := make([]int64, 0, len(messages))
+	blocked := make(map[string]bool) // companies with a failed event
+	var failure error
+	for _, m := range messages {
+		if blocked[m.Event.CompanyID] {
+			continue // claimed again when the failed event is published
+		}
+		if m.Event.ID == "" {
+			// the id must stay the same when the event is published again, so duplicates can be dropped
+			m.Event.ID = "outbox-" + strconv.FormatInt(m.ID, 10)
+		}
+		msg, err := format.Encode(&m.Event)
+		var next time.Time
+		if err == nil {
+			if err = domain.PublishMessage(publisher, channel, msg); err != nil {
+				next = time.Now().Add(outboxRetryWait(retryWait, m.Attempts+1))
+			}
+		}
+		if err == nil {
+			sent = append(sent, m.ID)
+			continue
+		}
+		if maxAttempts > 0 && m.Attempts+1 >= maxAttempts {
+			next = time.Time{}
+		}
+		if next.IsZero() {
+			l.Errorf("outbox: park event %d after %d attempts: %s", m.ID, m.Attempts+1, err.Error())
+		} else {
+			blocked[m.Event.CompanyID] = true
+		}
+		if failure == nil {
+			failure = fmt.Errorf("publish event %d: %w", m.ID, err)
+		}
+		if err := outbox.MarkFailed(ctx, m.ID, err.Error(), next); err != nil {
+			failure = fmt.Errorf("%s, %w", failure.Error(), err)
+		}
+	}
+	if f, ok := publisher.(flusher); ok && len(sent) > 0 {
+		if err := f.Flush(); err != nil {
+			return 0, fmt.Errorf("flush events: %w", err) // not marked sent, they are published again
+		}
+	}
+	if err := outbox.MarkSent(ctx, sent...); err != nil {
+		return 0, err
+	}
+	return len(sent), failure
+}
+
+// outboxRetryWait doubles the wait after every failed attempt up to outboxMaxBackoff
+func outboxRetryWait(wait time.Duration, attempts int) time.Duration {
+	for i := 1; i < attempts && wait < outboxMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > outboxMaxBackoff {
+		wait = outboxMaxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+type outboxMock struct {
+	mu       sync.Mutex
+	messages []domain.OutboxMessage
+	sent     []int64
+	failed   []int64
+	parked   []int64
+	retryAt  map[int64]time.Time
+}
+
+// Claim returns due messages like the storage: a message waits while an older one of its company waits for a retry
+func (o *outboxMock) Claim(_ context.Context, limit int) ([]domain.OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := make([]domain.OutboxMessage, 0, limit)
+	waiting := make(map[string]bool)
+	for _, m := range o.messages {
+		if contains(o.sent, m.ID) || contains(o.parked, m.ID) {
+			continue
+		}
+		if time.Now().Before(o.retryAt[m.ID]) || waiting[m.Event.CompanyID] {
+			waiting[m.Event.CompanyID] = true
+			continue
+		}
+		if len(pending) < limit {
+			m.Attempts = count(o.failed, m.ID)
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func contains(ids []int64, id int64) bool {
+	return count(ids, id) > 0
+}
+
+func count(ids []int64, id int64) int {
+	n := 0
+	for _, v := range ids {
+		if v == id {
+			n++
+		}
+	}
+	return n
+}
+
+func (o *outboxMock) MarkSent(_ context.Context, ids ...int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, ids...)
+	return nil
+}
+
+func (o *outboxMock) MarkFailed(_ context.Context, id int64, _ string, next time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed = append(o.failed, id)
+	if next.IsZero() {
+		o.parked = append(o.parked, id)
+	}
+	if o.retryAt == nil {
+		o.retryAt = make(map[int64]time.Time)
+	}
+	o.retryAt[id] = next
+	return nil
+}
+
+func (o *outboxMock) Stats(context.Context) (domain.OutboxStats, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return domain.OutboxStats{Pending: int64(len(o.messages) - len(o.sent) - len(o.parked)), Parked: int64(len(o.parked))}, nil
+}
+
+func (o *outboxMock) Prune(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRunOutboxRelay(t *testing.T) {
+	outbox := &outboxMock{messages: []domain.OutboxMessage{
+		{ID: 1, Event: domain.Event{Type: domain.CreateCompany, CompanyID: "1"}},
+		{ID: 2, Event: domain.Event{Type: domain.UpdateCompany, CompanyID: "1"}},
+		{ID: 3, Event: domain.Event{Type: domain.DeleteCompany, CompanyID: "1"}},
+	}}
+	published := make(chan domain.CloudEvent, 10)
+	failOnce := true
+	pub := PublisherMock(func(subj string, data []byte) error {
+		var e domain.CloudEvent
+		if err := json.Unmarshal(data, &e); err != nil || subj != "companies" {
+			t.Errorf("unexpected event %s on %s", data, subj)
+		}
+		if e.Type == domain.CloudEventType(domain.UpdateCompany) && failOnce {
+			failOnce = false
+			return errors.New("queue is down")
+		}
+		published <- e
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunOutboxRelay(ctx, outbox, pub, "companies", domain.EventFormat{}, time.Millisecond, time.Hour, 0, log.StandardLogger())
+		close(done)
+	}()
+	want := []domain.EventType{domain.CreateCompany, domain.UpdateCompany, domain.DeleteCompany}
+	for i := range want {
+		select {
+		case e := <-published:
+			if e.Type != domain.CloudEventType(want[i]) {
+				t.Errorf("event %d: want %s but got %s, events must be published in order", i, want[i], e.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d was not published", i)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay should stop when the context is done")
+	}
+
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	if len(outbox.sent) != 3 || len(outbox.failed) != 1 || outbox.failed[0] != 2 {
+		t.Errorf("want 3 sent events and a failed attempt of event 2 but got sent %v failed %v", outbox.sent, outbox.failed)
+	}
+	if outboxPending.Value() != 0 {
+		t.Errorf("want no pending events in metrics but got %d", outboxPending.Value())
+	}
+}
+
+func TestRelayOutboxParksFailingEvents(t *testing.T) {
+	outbox := &outboxMock{messages: []domain.OutboxMessage{
+		{ID: 1, Event: domain.Event{Type: domain.CreateCompany, CompanyID: "1"}},
+		{ID: 2, Event: domain.Event{Type: domain.CreateCompany, CompanyID: "2"}},
+		{ID: 3, Event: domain.Event{Type: domain.UpdateCompany, CompanyID: "1"}},
+		{ID: 4, Event: domain.Event{Type: domain.UpdateCompany, CompanyID: "2"}},
+	}}
+	var published []string
+	pub := PublisherMock(func(_ string, data []byte) error {
+		var e domain.CloudEvent
+		json.Unmarshal(data, &e)
+		if e.ID == "outbox-1" {
+			return errors.New("message is too large")
+		}
+		published = append(published, e.ID)
+		return nil
+	})
+	relay := func() {
+		relayOutbox(context.Background(), outbox, pub, "companies", domain.EventFormat{}, time.Millisecond, 2, log.StandardLogger())
+	}
+
+	// the first event of company 1 fails, the events of company 2 are not blocked by it
+	relay()
+	if len(published) != 2 || published[0] != "outbox-2" || published[1] != "outbox-4" {
+		t.Fatalf("want events of company 2 published but got %v", published)
+	}
+	relay()
+	if len(published) != 2 {
+		t.Fatalf("want event 3 waiting for the retry of event 1 but got %v", published)
+	}
+
+	// the second attempt parks event 1 and the later event of company 1 is published
+	time.Sleep(5 * time.Millisecond)
+	relay()
+	relay()
+	if len(outbox.parked) != 1 || outbox.parked[0] != 1 || len(published) != 3 || published[2] != "outbox-3" {
+		t.Errorf("want event 1 parked and event 3 published but got parked %v published %v", outbox.parked, published)
+	}
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// publisherBackend - a publisher with a stand-in of its broker, received returns messages got by the broker
+type publisherBackend struct {
+	publisher domain.Publisher
+	received  func() []MemoryMessage
+	// features the broker keeps, all of them keep the subject, the order and the data
+	headers bool
+	ids     bool
+	keys    bool
+}
+
+func TestPublisherConformance(t *testing.T) {
+	backends := map[string]func(t *testing.T) publisherBackend{
+		"nats": natsBackend,
+		"memory": func(t *testing.T) publisherBackend {
+			bus := NewMemoryBus(100)
+			return publisherBackend{publisher: bus, received: bus.Messages, headers: true, ids: true, keys: true}
+		},
+		"file":       fileBackend,
+		"redis":      redisBackend,
+		"rest-proxy": restProxyBackend,
+	}
+	for name, backend := range backends {
+		backend := backend
+		t.Run(name, func(t *testing.T) {
+			testPublisherConformance(t, backend(t))
+		})
+	}
+}
+
+func testPublisherConformance(t *testing.T, b publisherBackend) {
+	format := domain.EventFormat{Binary: b.headers}
+	var sent []*domain.Message
+	for i, op := range []domain.EventType{domain.CreateCompany, domain.UpdateCompany, domain.DeleteCompany} {
+		msg, err := format.Encode(&domain.Event{Type: op, CompanyID: "c1", Subject: domain.Company{Name: fmt.Sprint("acme", i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msg)
+	}
+	// the first message on its own, the others as a batch
+	if err := domain.PublishMessage(b.publisher, "companies", sent[0]); err != nil {
+		t.Fatalf("publish %s: %s", sent[0].Type, err.Error())
+	}
+	if err := domain.PublishMessages(b.publisher, "companies", sent[1:]); err != nil {
+		t.Fatalf("publish batch: %s", err.Error())
+	}
+	flush(t, b.publisher)
+	got := waitReceived(t, b.received, len(sent))
+	for i, msg := range sent {
+		m := got[i]
+		if m.Subject != "companies" || string(m.Data) != string(msg.Data) {
+			t.Errorf("message %d: want %s on companies but got %s on %s", i, msg.Data, m.Data, m.Subject)
+		}
+		if b.ids && m.ID != msg.ID {
+			t.Errorf("message %d: want id %s but got %s", i, msg.ID, m.ID)
+		}
+		if b.keys && m.Key != "c1" {
+			t.Errorf("message %d: want key c1 but got %s", i, m.Key)
+		}
+		for k, v := range msg.Header {
+			if b.headers && m.Header[k] != v {
+				t.Errorf("message %d: want header %s=%s but got %q", i, k, v, m.Header[k])
+			}
+		}
+		e, err := domain.DecodeCloudEvent(&m.Message)
+		if err != nil || e.ID != msg.ID || e.EventType() != msg.Type {
+			t.Errorf("message %d can't be decoded as the event %s: %+v %v", i, msg.ID, e, err)
+		}
+	}
+
+	if err := b.publisher.Publish("companies", []byte(`{"plain":true}`)); err != nil {
+		t.Fatalf("publish data: %s", err.Error())
+	}
+	flush(t, b.publisher)
+	if got = waitReceived(t, b.received, len(sent)+1); string(got[len(sent)].Data) != `{"plain":true}` {
+		t.Errorf("want plain data but got %s", got[len(sent)].Data)
+	}
+
+	const concurrent = 20
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := b.publisher.Publish("companies", []byte(strconv.Itoa(i))); err != nil {
+				t.Errorf("concurrent publish: %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+	flush(t, b.publisher)
+	got = waitReceived(t, b.received, len(sent)+1+concurrent)
+	seen := map[string]bool{}
+	for _, m := range got[len(sent)+1:] {
+		seen[string(m.Data)] = true
+	}
+	if len(seen) != concurrent {
+		t.Errorf("want %d distinct concurrent messages but got %d", concurrent, len(seen))
+	}
+}
+
+func flush(t *testing.T, p domain.Publisher) {
+	if f, ok := p.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			t.Fatalf("flush: %s", err.Error())
+		}
+	}
+}
+
+func waitReceived(t *testing.T, received func() []MemoryMessage, n int) []MemoryMessage {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := received()
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d messages but got %d", n, len(got))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// standIn records messages received by a broker stand-in
+type standIn struct {
+	mu       sync.Mutex
+	messages []MemoryMessage
+}
+
+func (s *standIn) add(m MemoryMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, m)
+}
+
+func (s *standIn) received() []MemoryMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MemoryMessage(nil), s.messages...)
+}
+
+func listen(t *testing.T, serve func(conn net.Conn)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l
+}
+
+// natsBackend runs a stand-in which speaks enough of the NATS client protocol to receive published messages
+func natsBackend(t *testing.T) publisherBackend {
+	s := &standIn{}
+	l := listen(t, func(conn net.Conn) {
+		port := conn.LocalAddr().(*net.TCPAddr).Port
+		fmt.Fprintf(conn, `INFO {"server_id":"stand-in","version":"2.9.0","proto":1,"headers":true,"max_payload":1048576,"host":"127.0.0.1","port":%d}`+"\r\n", port)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch strings.ToUpper(fields[0]) {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "PUB", "HPUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				m := MemoryMessage{Subject: fields[1], Message: domain.Message{Data: payload[:size]}}
+				if fields[0] == "HPUB" {
+					headerSize, _ := strconv.Atoi(fields[len(fields)-2])
+					m.Header = map[string]string{}
+					for _, h := range strings.Split(string(payload[:headerSize]), "\r\n")[1:] {
+						if k, v, ok := strings.Cut(h, ":"); ok {
+							m.Header[strings.ToLower(k)] = strings.TrimSpace(v)
+						}
+					}
+					m.Data = payload[headerSize:size]
+				}
+				s.add(m)
+			}
+		}
+	})
+	conn, err := nats.Connect("nats://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return publisherBackend{publisher: NewNATSPublisher(conn), received: s.received, headers: true}
+}
+
+func fileBackend(t *testing.T) publisherBackend {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	publisher, err := NewFilePublisher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := func() []MemoryMessage {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messages []MemoryMessage
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var r FileRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatalf("invalid line %s: %s", line, err.Error())
+			}
+			messages = append(messages, MemoryMessage{
+				Subject: r.Subject,
+				Message: domain.Message{ID: r.ID, Type: r.Type, Key: r.Key, Header: r.Header, Data: r.Data},
+			})
+		}
+		return messages
+	}
+	if publisher.Publish("companies", []byte("not json")) == nil {
+		t.Error("file publisher must reject data which is not JSON")
+	}
+	return publisherBackend{publisher: publisher, received: received, headers: true, ids: true, keys: true}
+}
+
+// redisBackend runs a stand-in which replies to RESP commands and records XADD entries
+func redisBackend(t *testing.T) publisherBackend {
+	s := &standIn{}
+	var seq int64
+	var seqMu sync.Mutex
+	l := listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readRESPCommand(r)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "AUTH", "SELECT":
+				io.WriteString(conn, "+OK\r\n")
+			case "XADD":
+				m := MemoryMessage{Subject: args[1], Message: domain.Message{Header: map[string]string{}}}
+				i := 2
+				if strings.ToUpper(args[i]) == "MAXLEN" {
+					i += 3
+				}
+				for i += 1; i+1 < len(args); i += 2 {
+					switch k, v := args[i], args[i+1]; {
+					case k == "id":
+						m.ID = v
+					case k == "type":
+						m.Type = domain.EventType(v)
+					case k == "key":
+						m.Key = v
+					case k == "data":
+						m.Data = []byte(v)
+					case strings.HasPrefix(k, redisHeaderPrefix):
+						m.Header[strings.TrimPrefix(k, redisHeaderPrefix)] = v
+					}
+				}
+				seqMu.Lock()
+				seq++
+				id := fmt.Sprintf("%d-0", seq)
+				seqMu.Unlock()
+				s.add(m)
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(id), id)
+			default:
+				fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+			}
+		}
+	})
+	publisher, err := NewRedisPublisher("redis://:secret@"+l.Addr().String()+"/1", 1000, time.Second, log.StandardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publisherBackend{publisher: publisher, received: s.received, headers: true, ids: true, keys: true}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+// restProxyBackend runs a stand-in of the REST proxy produce API
+func restProxyBackend(t *testing.T) publisherBackend {
+	s := &standIn{}
+	var offset int64
+	var offsetMu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := strings.TrimPrefix(r.URL.Path, "/topics/")
+		var req restProxyProduceRequest
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != restProxyBinaryContentType || topic == r.URL.Path {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		offsetMu.Lock()
+		defer offsetMu.Unlock()
+		var resp restProxyProduceResponse
+		resp.Offsets = make([]struct {
+			Partition int    `json:"partition"`
+			Offset    int64  `json:"offset"`
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		}, len(req.Records))
+		for i, record := range req.Records {
+			s.add(MemoryMessage{Subject: topic, Message: domain.Message{Key: string(record.Key), Data: record.Value}})
+			resp.Offsets[i].Offset = offset
+			offset++
+		}
+		w.Header().Set("Content-Type", restProxyAccept)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(proxy.Close)
+	publisher := NewRESTProxyPublisher(proxy.URL, time.Second, 1, log.StandardLogger())
+	binary, _ := domain.EventFormat{Binary: true}.Encode(&domain.Event{Type: domain.CreateCompany})
+	if domain.PublishMessage(publisher, "companies", binary) == nil {
+		t.Error("rest-proxy publisher must reject messages with headers")
+	}
+	return publisherBackend{publisher: publisher, received: s.received, keys: true}
+}
+
+func TestMatchSubject(t *testing.T) {
+	for _, c := range []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"companies", "companies", true},
+		{"companies", "companies.create", false},
+		{"companies.>", "companies.create", true},
+		{"companies.>", "companies", false},
+		{"companies.*", "companies.create", true},
+		{"companies.*", "companies.create.v1", false},
+		{">", "companies", true},
+	} {
+		if got := matchSubject(c.pattern, c.subject); got != c.want {
+			t.Errorf("match %s with %s: want %v but got %v", c.pattern, c.subject, c.want, got)
+		}
+	}
+}
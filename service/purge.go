@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunPurge permanently removes companies deleted more than retention ago, every interval until ctx is done
+func RunPurge(ctx context.Context, purger domain.Purger, retention, interval time.Duration, l *log.Logger) {
+	const logPrefix = "purge"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := purger.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			l.Errorf("%s: remove deleted companies: %s", logPrefix, err.Error())
+		} else if n > 0 {
+			l.Infof("%s: %d companies deleted more than %s ago are removed", logPrefix, n, retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
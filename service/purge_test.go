@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type purgerMock struct {
+	calls chan time.Time
+}
+
+func (p *purgerMock) Purge(_ context.Context, deletedBefore time.Time) (int64, error) {
+	p.calls <- deletedBefore
+	return 1, nil
+}
+
+func TestRunPurge(t *testing.T) {
+	purger := &purgerMock{calls: make(chan time.Time, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunPurge(ctx, purger, time.Hour, time.Millisecond, log.StandardLogger())
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case deletedBefore := <-purger.calls:
+			if age := time.Since(deletedBefore); age < time.Hour || age > time.Hour+time.Minute {
+				t.Errorf("want companies deleted an hour ago but got %s", age)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("purge was not called")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purge should stop when the context is done")
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+// redisHeaderPrefix - message headers are stream entry fields with the prefix, e.g. header:ce-type
+const redisHeaderPrefix = "header:"
+
+type redisPublisher struct {
+	addr     string
+	username string
+	password string
+	db       int
+	maxLen   int64
+	timeout  time.Duration
+
+	l         *log.Logger
+	logPrefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedisPublisher appends messages to the Redis stream named after the subject with XADD. An entry has the id,
+// type, key and data fields and a header:<name> field per header. Streams are trimmed to about maxLen entries,
+// zero keeps all of them. rawURL is redis://[[user]:password@]host[:port][/db], a command waits timeout.
+func NewRedisPublisher(rawURL string, maxLen int64, timeout time.Duration, l *log.Logger) (domain.Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid redis url %q, use redis://[[user]:password@]host[:port][/db]", rawURL)
+	}
+	p := &redisPublisher{
+		addr:      u.Host,
+		maxLen:    maxLen,
+		timeout:   timeout,
+		l:         l,
+		logPrefix: "redisPublisher",
+	}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		p.username = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if p.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	if p.timeout <= 0 {
+		p.timeout = 5 * time.Second
+	}
+	return p, nil
+}
+
+func (p *redisPublisher) Publish(subj string, data []byte) error {
+	return p.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (p *redisPublisher) PublishMessage(subj string, msg *domain.Message) error {
+	args := [][]byte{[]byte("XADD"), []byte(subj)}
+	if p.maxLen > 0 {
+		args = append(args, []byte("MAXLEN"), []byte("~"), []byte(strconv.FormatInt(p.maxLen, 10)))
+	}
+	args = append(args, []byte("*"),
+		[]byte("id"), []byte(msg.ID),
+		[]byte("type"), []byte(msg.Type),
+		[]byte("key"), []byte(msg.Key),
+		[]byte("data"), msg.Data,
+	)
+	names := make([]string, 0, len(msg.Header))
+	for name := range msg.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, []byte(redisHeaderPrefix+name), []byte(msg.Header[name]))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reused := p.conn != nil
+	id, err := p.do(args...)
+	var redisErr redisError
+	if err != nil && reused && !errors.As(err, &redisErr) {
+		// the connection could be closed by the server while idle
+		p.l.Debugf("%s: reconnect: %s", p.logPrefix, err.Error())
+		id, err = p.do(args...)
+	}
+	if err != nil {
+		return fmt.Errorf("add message to stream %s: %w", subj, err)
+	}
+	p.l.Tracef("%s: message %s is added to %s as %s", p.logPrefix, msg.ID, subj, id)
+	return nil
+}
+
+// redisError - an error reply of the server, the connection is still usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends the command and returns its reply, the connection is closed on network errors
+func (p *redisPublisher) do(args ...[]byte) (string, error) {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return "", err
+		}
+	}
+	reply, err := p.command(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		p.conn.Close()
+		p.conn, p.r = nil, nil
+	}
+	return reply, err
+}
+
+func (p *redisPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return err
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+	var setup [][][]byte
+	if p.password != "" {
+		if p.username != "" {
+			setup = append(setup, [][]byte{[]byte("AUTH"), []byte(p.username), []byte(p.password)})
+		} else {
+			setup = append(setup, [][]byte{[]byte("AUTH"), []byte(p.password)})
+		}
+	}
+	if p.db != 0 {
+		setup = append(setup, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(p.db))})
+	}
+	for _, cmd := range setup {
+		if _, err := p.command(cmd...); err != nil {
+			conn.Close()
+			p.conn, p.r = nil, nil
+			return fmt.Errorf("%s: %w", cmd[0], err)
+		}
+	}
+	return nil
+}
+
+// command writes the command as a RESP array of bulk strings and reads a simple, integer or bulk string reply
+func (p *redisPublisher) command(args ...[]byte) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n", len(arg))
+		buf.Write(arg)
+		buf.WriteString("\r\n")
+	}
+	p.conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err := p.conn.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("unexpected reply %q", line)
+		}
+		bulk := make([]byte, n+2)
+		if _, err := io.ReadFull(p.r, bulk); err != nil {
+			return "", err
+		}
+		return string(bulk[:n]), nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
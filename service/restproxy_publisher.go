@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	retry "github.com/hashicorp/go-retryablehttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	restProxyBinaryContentType = "application/vnd.kafka.binary.v2+json"
+	restProxyAccept            = "application/vnd.kafka.v2+json"
+)
+
+// restProxyRecord - a record of the binary embedded format, key and value are sent base64 encoded
+type restProxyRecord struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+}
+
+type restProxyProduceRequest struct {
+	Records []restProxyRecord `json:"records"`
+}
+
+type restProxyProduceResponse struct {
+	Offsets []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+type restProxyPublisher struct {
+	client    *retry.Client
+	url       string
+	l         *log.Logger
+	logPrefix string
+}
+
+// NewRESTProxyPublisher produces messages to the Kafka topic named after the subject through the REST proxy API v2
+// (Confluent REST Proxy, Redpanda HTTP Proxy etc.) at proxyURL. The company id is the record key, so events
+// of a company keep their order in a partition. The API has no record headers, so only structured events
+// can be produced. A request waits timeout and is retried retryAttempts times.
+func NewRESTProxyPublisher(proxyURL string, timeout time.Duration, retryAttempts int, l *log.Logger) domain.Publisher {
+	client := retry.NewClient()
+	client.RetryMax = retryAttempts
+	client.Logger = l
+	if timeout > 0 {
+		client.HTTPClient.Timeout = timeout
+	}
+	return &restProxyPublisher{client: client, url: strings.TrimSuffix(proxyURL, "/"), l: l, logPrefix: "restProxyPublisher"}
+}
+
+func (k *restProxyPublisher) Publish(subj string, data []byte) error {
+	return k.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+func (k *restProxyPublisher) PublishMessage(subj string, msg *domain.Message) error {
+	return k.PublishMessages(subj, []*domain.Message{msg})
+}
+
+// PublishMessages produces all messages with one request
+func (k *restProxyPublisher) PublishMessages(subj string, msgs []*domain.Message) error {
+	records := make([]restProxyRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		for name := range msg.Header {
+			if !strings.EqualFold(name, "content-type") {
+				return fmt.Errorf("produce to %s: header %s is not supported by the Kafka REST proxy", subj, name)
+			}
+		}
+		record := restProxyRecord{Value: msg.Data}
+		if msg.Key != "" {
+			record.Key = []byte(msg.Key)
+		}
+		records = append(records, record)
+	}
+	body, err := json.Marshal(restProxyProduceRequest{Records: records})
+	if err != nil {
+		return fmt.Errorf("produce to %s: %w", subj, err)
+	}
+	req, err := retry.NewRequest(http.MethodPost, k.url+"/topics/"+url.PathEscape(subj), body)
+	if err != nil {
+		return fmt.Errorf("produce to %s: %w", subj, err)
+	}
+	req.Header.Set("Content-Type", restProxyBinaryContentType)
+	req.Header.Set("Accept", restProxyAccept)
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("produce to %s: %w", subj, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("produce to %s: read response: %w", subj, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("produce to %s: response status %s: %s", subj, resp.Status, bytes.TrimSpace(respBody))
+	}
+	var produced restProxyProduceResponse
+	if err := json.Unmarshal(respBody, &produced); err != nil {
+		return fmt.Errorf("produce to %s: decode response: %w", subj, err)
+	}
+	for i, o := range produced.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			return fmt.Errorf("produce to %s: %s", subj, o.Error)
+		}
+		if i < len(msgs) {
+			k.l.Tracef("%s: message %s is produced to %s[%d] at %d", k.logPrefix, msgs[i].ID, subj, o.Partition, o.Offset)
+		}
+	}
+	return nil
+}
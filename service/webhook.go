@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookBatchSize    = 50
+	webhookMaxRetryWait = 6 * time.Hour
+	// WebhookSignatureHeader - "sha256=" and the hex HMAC-SHA256 of the timestamp, "." and the body, see SignWebhook
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// SignWebhook returns the signature of a delivery body sent at the unix timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookPublisher struct {
+	webhooks domain.Webhooks
+}
+
+// NewWebhookPublisher adds deliveries of published events to the webhooks subscribed to them,
+// they are sent by WebhookDispatcher
+func NewWebhookPublisher(webhooks domain.Webhooks) domain.Publisher {
+	return &webhookPublisher{webhooks: webhooks}
+}
+
+func (w *webhookPublisher) Publish(subj string, data []byte) error {
+	return w.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+// PublishMessage delivers the event as a structured CloudEvent whatever mode it is published in
+func (w *webhookPublisher) PublishMessage(_ string, msg *domain.Message) error {
+	e, err := domain.DecodeCloudEvent(msg)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+	_, err = w.webhooks.Enqueue(context.Background(), e.ID, e.EventType(), payload)
+	return err
+}
+
+type fanoutPublisher []domain.Publisher
+
+// NewFanoutPublisher publishes every message to all publishers, nil ones are skipped
+func NewFanoutPublisher(publishers ...domain.Publisher) domain.Publisher {
+	fanout := make(fanoutPublisher, 0, len(publishers))
+	for _, p := range publishers {
+		if p != nil {
+			fanout = append(fanout, p)
+		}
+	}
+	switch len(fanout) {
+	case 0:
+		return nil
+	case 1:
+		return fanout[0]
+	}
+	return fanout
+}
+
+func (f fanoutPublisher) Publish(subj string, data []byte) error {
+	return f.PublishMessage(subj, &domain.Message{Data: data})
+}
+
+// PublishMessage tries all publishers and returns the first error
+func (f fanoutPublisher) PublishMessage(subj string, msg *domain.Message) error {
+	var first error
+	for _, p := range f {
+		if err := domain.PublishMessage(p, subj, msg); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// PublishMessages passes the batch to all publishers and returns the first error
+func (f fanoutPublisher) PublishMessages(subj string, msgs []*domain.Message) error {
+	var first error
+	for _, p := range f {
+		if err := domain.PublishMessages(p, subj, msgs); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f fanoutPublisher) Flush() error {
+	var first error
+	for _, p := range f {
+		if fl, ok := p.(flusher); ok {
+			if err := fl.Flush(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// WebhookDispatcher sends due webhook deliveries and records the attempts
+type WebhookDispatcher struct {
+	webhooks    domain.Webhooks
+	client      *http.Client
+	maxAttempts int
+	retryWait   time.Duration
+	l           *log.Logger
+	logPrefix   string
+}
+
+// NewWebhookDispatcher sends deliveries with the timeout. A failed delivery is retried after retryWait,
+// the wait is doubled by every attempt, and it is failed after maxAttempts. Zero values are replaced by defaults.
+func NewWebhookDispatcher(webhooks domain.Webhooks, timeout time.Duration, maxAttempts int, retryWait time.Duration, l *log.Logger) *WebhookDispatcher {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+	if retryWait <= 0 {
+		retryWait = 30 * time.Second
+	}
+	return &WebhookDispatcher{
+		webhooks:    webhooks,
+		client:      newWebhookClient(timeout, domain.IsPublicAddr),
+		maxAttempts: maxAttempts,
+		retryWait:   retryWait,
+		l:           l,
+		logPrefix:   "webhooks",
+	}
+}
+
+// newWebhookClient dials the addresses allowed only. The check is done on the resolved address
+// of every connection, redirects included, so a name can't be rebound to a private address after
+// the webhook is validated. Proxies from the environment are not used since they would dial instead.
+func newWebhookClient(timeout time.Duration, allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("webhook target %s: %w", address, err)
+			}
+			if !allowed(addrPort.Addr()) {
+				return fmt.Errorf("webhook target %s is not a public address", address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Run dispatches deliveries every interval until ctx is done
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		wait := interval
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			d.l.Warnf("%s: dispatch deliveries: %s", d.logPrefix, err.Error())
+		} else if n == webhookBatchSize {
+			wait = 0 // there are more due deliveries
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Dispatch sends due deliveries once and returns their number
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.webhooks.Due(ctx, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := range deliveries {
+		attempt := d.send(ctx, &deliveries[i])
+		if err := d.webhooks.RecordAttempt(ctx, deliveries[i].ID, &attempt); err != nil {
+			return i, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, delivery *domain.WebhookDelivery) domain.DeliveryAttempt {
+	attempt := domain.DeliveryAttempt{Status: domain.DeliveryDelivered}
+	status, err := d.post(ctx, delivery)
+	attempt.ResponseStatus = status
+	if err == nil {
+		return attempt
+	}
+	attempt.Error = err.Error()
+	if delivery.Attempts+1 >= d.maxAttempts {
+		attempt.Status = domain.DeliveryFailed
+		d.l.Warnf("%s: delivery %d to %s failed: %s", d.logPrefix, delivery.ID, delivery.URL, err.Error())
+		return attempt
+	}
+	wait := d.retryWait << delivery.Attempts
+	if wait > webhookMaxRetryWait || wait <= 0 {
+		wait = webhookMaxRetryWait
+	}
+	attempt.Status, attempt.Next = domain.DeliveryPending, time.Now().Add(wait)
+	d.l.Infof("%s: delivery %d to %s, retry in %s: %s", d.logPrefix, delivery.ID, delivery.URL, wait, err.Error())
+	return attempt
+}
+
+// post sends the delivery and returns the response status, any status but 2xx is an error
+func (d *WebhookDispatcher) post(ctx context.Context, delivery *domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set("User-Agent", "companysvc-webhooks")
+	req.Header.Set("X-Webhook-Id", delivery.WebhookID)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OleksiiKhanin/companysvc/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhooksMock keeps deliveries in memory, webhook management is not used by the service
+type webhooksMock struct {
+	domain.Webhooks
+	mu         sync.Mutex
+	webhook    domain.Webhook
+	deliveries []domain.WebhookDelivery
+	next       []time.Time
+}
+
+func (m *webhooksMock) Enqueue(_ context.Context, eventID string, eventType domain.EventType, payload []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.webhook.Accepts(eventType) {
+		return 0, nil
+	}
+	m.deliveries = append(m.deliveries, domain.WebhookDelivery{
+		ID:        int64(len(m.deliveries) + 1),
+		WebhookID: m.webhook.ID,
+		EventID:   eventID,
+		EventType: eventType,
+		Status:    domain.DeliveryPending,
+		Payload:   payload,
+	})
+	m.next = append(m.next, time.Time{})
+	return 1, nil
+}
+
+func (m *webhooksMock) Due(_ context.Context, limit int) ([]domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []domain.WebhookDelivery
+	for i, d := range m.deliveries {
+		if d.Status == domain.DeliveryPending && !m.next[i].After(time.Now()) && len(due) < limit {
+			d.URL, d.Secret = m.webhook.URL, m.webhook.Secret
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (m *webhooksMock) RecordAttempt(_ context.Context, id int64, attempt *domain.DeliveryAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := &m.deliveries[id-1]
+	d.Status, d.ResponseStatus, d.LastError = attempt.Status, attempt.ResponseStatus, attempt.Error
+	d.Attempts++
+	if !attempt.Next.IsZero() {
+		m.next[id-1] = attempt.Next
+	}
+	return nil
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	webhooks := &webhooksMock{webhook: domain.Webhook{ID: "w1", EventTypes: []domain.EventType{domain.UpdateCompany}}}
+	pub := NewWebhookPublisher(webhooks)
+	for _, format := range []domain.EventFormat{{}, {Binary: true}} {
+		for _, op := range []domain.EventType{domain.CreateCompany, domain.UpdateCompany} {
+			msg, err := format.Encode(&domain.Event{Type: op, CompanyID: "1", Subject: domain.Company{Name: "Acme"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := domain.PublishMessage(pub, "companies", msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(webhooks.deliveries) != 2 {
+		t.Fatalf("want 2 update deliveries, got %+v", webhooks.deliveries)
+	}
+	for _, d := range webhooks.deliveries {
+		e, err := domain.DecodeCloudEvent(&domain.Message{Data: d.Payload})
+		if err != nil {
+			t.Fatalf("payload must be a structured CloudEvent: %s", err.Error())
+		}
+		if e.ID != d.EventID || e.EventType() != domain.UpdateCompany || e.Data.After.Name != "Acme" {
+			t.Errorf("unexpected payload %s of delivery %+v", d.Payload, d)
+		}
+	}
+	if err := pub.Publish("companies", []byte("{}")); err == nil {
+		t.Error("want an error for a message which is not a CloudEvent")
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	const secret = "0123456789abcdef"
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if err != nil || r.Header.Get(WebhookSignatureHeader) != SignWebhook(secret, timestamp, body) {
+			t.Errorf("invalid signature %q of %s", r.Header.Get(WebhookSignatureHeader), body)
+		}
+		if r.Header.Get("X-Webhook-Id") != "w1" || r.Header.Get("Content-Type") != "application/cloudevents+json" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	webhooks := &webhooksMock{webhook: domain.Webhook{ID: "w1", URL: receiver.URL, Secret: secret}}
+	webhooks.Enqueue(context.Background(), "e1", domain.CreateCompany, []byte(`{"id":"e1"}`))
+	dispatcher := newLoopbackDispatcher(webhooks, 3)
+
+	if n, err := dispatcher.Dispatch(context.Background()); err != nil || n != 1 {
+		t.Fatalf("want 1 dispatched delivery, got %d: %v", n, err)
+	}
+	d := webhooks.deliveries[0]
+	if d.Status != domain.DeliveryPending || d.Attempts != 1 || d.ResponseStatus != http.StatusInternalServerError || d.LastError == "" {
+		t.Fatalf("failed delivery must be retried, got %+v", d)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := dispatcher.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d = webhooks.deliveries[0]
+	if d.Status != domain.DeliveryDelivered || d.Attempts != 2 || d.ResponseStatus != http.StatusNoContent {
+		t.Fatalf("delivery must be delivered, got %+v", d)
+	}
+}
+
+func TestWebhookDispatcherFails(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	webhooks := &webhooksMock{webhook: domain.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"}}
+	webhooks.Enqueue(context.Background(), "e1", domain.DeleteCompany, []byte(`{}`))
+	dispatcher := newLoopbackDispatcher(webhooks, 2)
+	for i := 0; i < 3; i++ {
+		if _, err := dispatcher.Dispatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	d := webhooks.deliveries[0]
+	if d.Status != domain.DeliveryFailed || d.Attempts != 2 || d.ResponseStatus != http.StatusBadGateway {
+		t.Fatalf("delivery must fail after 2 attempts, got %+v", d)
+	}
+}
+
+// newLoopbackDispatcher may deliver to the httptest receivers on the loopback interface
+func newLoopbackDispatcher(webhooks domain.Webhooks, maxAttempts int) *WebhookDispatcher {
+	dispatcher := NewWebhookDispatcher(webhooks, time.Second, maxAttempts, time.Millisecond, log.StandardLogger())
+	dispatcher.client = newWebhookClient(time.Second, func(addr netip.Addr) bool { return addr.IsLoopback() })
+	return dispatcher
+}
+
+func TestWebhookDispatcherRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	webhooks := &webhooksMock{webhook: domain.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"}}
+	webhooks.Enqueue(context.Background(), "e1", domain.CreateCompany, []byte(`{}`))
+	dispatcher := NewWebhookDispatcher(webhooks, time.Second, 2, time.Millisecond, log.StandardLogger())
+	if _, err := dispatcher.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := webhooks.deliveries[0]; called || d.Attempts != 1 || !strings.Contains(d.LastError, "not a public address") {
+		t.Fatalf("delivery to a loopback address must not be sent, got %+v", d)
+	}
+}
+
+func TestFanoutPublisher(t *testing.T) {
+	var got []string
+	first := PublisherMock(func(subj string, data []byte) error {
+		got = append(got, "first")
+		return nil
+	})
+	second := PublisherMock(func(subj string, data []byte) error {
+		got = append(got, "second")
+		return nil
+	})
+	if NewFanoutPublisher(nil, nil) != nil {
+		t.Error("fanout of no publishers must be nil")
+	}
+	if err := NewFanoutPublisher(first, nil, second).Publish("companies", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("want both publishers in order, got %v", got)
+	}
+}